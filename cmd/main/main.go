@@ -1,21 +1,73 @@
 package main
 
 import (
+	"log"
+	"os"
+	"strings"
+
 	auth_routes "github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/auth-routes"
+	auth_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/auth-utils"
 	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
 	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
 	property_routes "github.com/Brian-Mashavakure/smart-prop-server/pkg/property-service/property-routes"
+	property_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/property-service/property-utils"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 func main() {
 	connector.Connector()
 
-	connector.DB.AutoMigrate(models.User{}, models.Preferences{}, models.Property{}, models.Booking{})
+	// accounts from before email verification existed count as verified, checked before AutoMigrate adds the column
+	backfillVerified := !connector.DB.Migrator().HasColumn(&models.User{}, "VerifiedAt")
+
+	connector.DB.AutoMigrate(models.User{}, models.Preferences{}, models.Amenity{}, models.Property{}, models.Booking{}, models.Session{}, models.RefreshToken{}, models.TokenRevocation{}, models.UserToken{}, models.UserRole{}, models.RecoveryCode{}, models.Identity{}, models.OAuthState{}, models.APIKey{}, models.AuditEvent{}, models.PropertyImage{})
+
+	if backfillVerified {
+		result := connector.DB.Model(&models.User{}).Where("verified_at IS NULL").Update("verified_at", gorm.Expr("created_at"))
+		if result.Error != nil {
+			log.Printf("Error occurred trying to mark existing accounts as verified:\n %v", result.Error)
+		}
+	}
+
+	if _, err := auth_utils.EncryptStoredTOTPSecrets(); err != nil {
+		log.Printf("Error occurred trying to encrypt stored totp secrets:\n %v", err)
+	}
+
+	// full text search needs a generated column and index that AutoMigrate cannot create
+	if err := property_utils.EnsureSearchIndex(); err != nil {
+		log.Printf("Error occurred trying to create the property search index:\n %v", err)
+	}
+
+	// scraped listings only match amenity searches once linked to the catalogue, so linking waits for the seed
+	if err := property_utils.SeedAmenities(); err != nil {
+		log.Printf("Error occurred trying to seed the amenities catalogue, listings will not be linked:\n %v", err)
+	} else {
+		property_utils.StartAmenityLinker()
+	}
+
+	// accounts past their deletion grace period are erased in the background
+	auth_utils.StartAccountPurger()
 
 	router := gin.Default()
+	// login throttling keys on ClientIP, so X-Forwarded-For is only believed from proxies listed in TRUSTED_PROXIES
+	if err := router.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Printf("Error occurred trying to set trusted proxies, trusting none:\n %v", err)
+		router.SetTrustedProxies(nil)
+	}
 	auth_routes.AuthRoutes(router)
 	property_routes.PropertyRoutes(router)
 
 	router.Run(":8090")
 }
+
+// comma separated ips or cidrs of the reverse proxies in front of the server
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
@@ -0,0 +1,214 @@
+package auth_handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	auth_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/auth-utils"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/middleware"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	mail_service "github.com/Brian-Mashavakure/smart-prop-server/pkg/mail-service"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// one json file per section of the export
+func buildExportArchive(data map[string]interface{}) ([]byte, error) {
+	sections := make([]string, 0, len(data))
+	for section := range data {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, section := range sections {
+		file, err := archive.Create(section + ".json")
+		if err != nil {
+			return nil, err
+		}
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(data[section]); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// download everything stored about the current user, ?format=zip for an archive instead of json
+func ExportAccountHandler(c *gin.Context) {
+	user := middleware.CurrentUser(c)
+	format := c.DefaultQuery("format", "json")
+
+	if format != "json" && format != "zip" {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "invalid format", nil, map[string]interface{}{"error": "format must be json or zip"}))
+		return
+	}
+
+	data, err := auth_utils.ExportUserData(user)
+	if err != nil {
+		log.Printf("Error occurred trying to export account data:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to export account", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+	data["exported_at"] = time.Now().UTC()
+
+	fileName := fmt.Sprintf("smart-prop-export-%d-%s", user.ID, time.Now().UTC().Format("20060102"))
+
+	if format == "zip" {
+		archive, err := buildExportArchive(data)
+		if err != nil {
+			log.Printf("Error occurred trying to build export archive:\n %v", err)
+			c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to export account", nil, map[string]interface{}{"error": "something went wrong"}))
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName+".zip"))
+		c.Data(http.StatusOK, "application/zip", archive)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName+".json"))
+	c.Header("Content-Type", "application/json")
+	c.IndentedJSON(http.StatusOK, data)
+}
+
+// deletion needs the password, or an emailed confirmation for accounts that only log in through a provider;
+// the account is erased once the grace period has passed unless the user logs back in and cancels
+func RequestAccountDeletionHandler(c *gin.Context) {
+	user := middleware.CurrentUser(c)
+	var req AccountDeletionRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+	password := req.Password
+
+	if user.DeletionRequestedAt != nil {
+		c.JSON(http.StatusConflict, utils.ReturnJsonResponse("failed", "deletion already requested", nil, map[string]interface{}{"error": "this account is already scheduled for deletion"}))
+		return
+	}
+
+	if user.PASSWORD == "" {
+		sendDeletionConfirmation(c, user)
+		return
+	}
+
+	if password == "" {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "invalid request", nil, map[string]interface{}{"password": "password is required"}))
+		return
+	}
+	match, err := auth_utils.ComparePasswordAndHash(user.PASSWORD, password)
+	if hasherBusy(c, err, "failed to delete account") {
+		return
+	}
+	if !match {
+		middleware.Audit(c, auth_utils.AuditAccountDeletionRequested, user.ID, auth_utils.AuditOutcomeFailure, map[string]interface{}{"reason": "bad_password"})
+		c.JSON(http.StatusUnauthorized, utils.ReturnJsonResponse("failed", "password is incorrect", nil, map[string]interface{}{"error": "password is incorrect"}))
+		return
+	}
+
+	scheduleAccountDeletion(c, user)
+}
+
+// passwordless accounts prove it is them by following an emailed link
+func sendDeletionConfirmation(c *gin.Context, user models.User) {
+	token, err := auth_utils.IssueSignedToken(user.ID, auth_utils.PurposeAccountDeletion, auth_utils.AccountDeletionTTL)
+	if err != nil {
+		log.Printf("Error occurred trying to issue deletion confirmation:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to delete account", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nUse the link below to confirm that your Smart Prop account should be deleted. It expires in %v.\n\n%s\n\nIf you did not ask for this you can ignore this email.\n",
+		user.NAME, auth_utils.AccountDeletionTTL, mail_service.AppLink("confirm-account-deletion", token))
+	if err := mail_service.GetMailer().Send(user.EMAIL, "Confirm your account deletion", body); err != nil {
+		log.Printf("Error occurred trying to send deletion confirmation:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to delete account", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusAccepted, utils.ReturnJsonResponse("success", "check your email to confirm the deletion", map[string]interface{}{"confirmation_required": true}, nil))
+}
+
+// the emailed deletion link, the token stands in for the password so no login is needed
+func ConfirmAccountDeletionHandler(c *gin.Context) {
+	var req TokenRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+
+	record, err := auth_utils.ConsumeSignedToken(req.Token, auth_utils.PurposeAccountDeletion)
+	if errors.Is(err, auth_utils.ErrSignedTokenInvalid) {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "invalid confirmation token", nil, map[string]interface{}{"error": err.Error()}))
+		return
+	}
+	if err != nil {
+		log.Printf("Error occurred trying to consume deletion confirmation:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to delete account", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	var user models.User
+	if result := connector.DB.First(&user, record.UserID); result.Error != nil {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "invalid confirmation token", nil, map[string]interface{}{"error": auth_utils.ErrSignedTokenInvalid.Error()}))
+		return
+	}
+	if user.DeletionRequestedAt != nil {
+		c.JSON(http.StatusConflict, utils.ReturnJsonResponse("failed", "deletion already requested", nil, map[string]interface{}{"error": "this account is already scheduled for deletion"}))
+		return
+	}
+
+	scheduleAccountDeletion(c, user)
+}
+
+func scheduleAccountDeletion(c *gin.Context, user models.User) {
+	purgeAt, err := auth_utils.RequestAccountDeletion(user.ID)
+	if err != nil {
+		log.Printf("Error occurred trying to schedule account deletion:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to delete account", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	middleware.Audit(c, auth_utils.AuditAccountDeletionRequested, user.ID, auth_utils.AuditOutcomeSuccess, map[string]interface{}{"purge_at": purgeAt})
+
+	body := fmt.Sprintf("Hi %s,\n\nYour Smart Prop account is scheduled for deletion on %s. Log in and cancel the deletion before then if you change your mind.\n",
+		user.NAME, purgeAt.UTC().Format("2 January 2006"))
+	if err := mail_service.GetMailer().Send(user.EMAIL, "Your account will be deleted", body); err != nil {
+		log.Printf("Error occurred trying to send deletion notice:\n %v", err)
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "account scheduled for deletion, you have been logged out", map[string]interface{}{"purge_at": purgeAt}, nil))
+}
+
+func CancelAccountDeletionHandler(c *gin.Context) {
+	user := middleware.CurrentUser(c)
+
+	cancelled, err := auth_utils.CancelAccountDeletion(user.ID)
+	if err != nil {
+		log.Printf("Error occurred trying to cancel account deletion:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to cancel deletion", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+	if !cancelled {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "no deletion pending", nil, map[string]interface{}{"error": "this account is not scheduled for deletion"}))
+		return
+	}
+
+	middleware.Audit(c, auth_utils.AuditAccountDeletionCancelled, user.ID, auth_utils.AuditOutcomeSuccess, nil)
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "account deletion cancelled", nil, nil))
+}
@@ -0,0 +1,128 @@
+package auth_handlers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	auth_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/auth-utils"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/middleware"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// load the account an admin endpoint acts on, writes a 404 and returns false when it does not exist
+func adminTargetUser(c *gin.Context, userID uint) (models.User, bool) {
+	var user models.User
+	if result := connector.DB.First(&user, userID); result.Error != nil {
+		c.JSON(http.StatusNotFound, utils.ReturnJsonResponse("failed", "User not found", nil, map[string]interface{}{"error": "user could not be found in our system"}))
+		return models.User{}, false
+	}
+	return user, true
+}
+
+func createAPIKey(c *gin.Context, user models.User, req CreateAPIKeyRequest) {
+	scopes, err := auth_utils.ParseScopes(req.Scopes)
+	if err != nil || len(scopes) == 0 {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "invalid request", nil, map[string]interface{}{"scopes": "scopes must be any of properties:read, properties:write, preferences:write, bookings:read, bookings:write"}))
+		return
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresInDays != 0 {
+		expiry := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &expiry
+	}
+
+	plainKey, key, err := auth_utils.CreateAPIKey(user.ID, strings.TrimSpace(req.Name), scopes, expiresAt)
+	if err != nil {
+		log.Printf("Error occurred trying to create api key:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to create api key", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	middleware.Audit(c, auth_utils.AuditAPIKeyCreated, user.ID, auth_utils.AuditOutcomeSuccess, map[string]interface{}{"key_id": key.ID, "prefix": key.Prefix, "scopes": scopes, "created_by": middleware.CurrentUserID(c)})
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "api key created, it will not be shown again", map[string]interface{}{"api_key": plainKey, "key": key}, nil))
+}
+
+func listAPIKeys(c *gin.Context, userID uint) {
+	keys, err := auth_utils.ListAPIKeys(userID)
+	if err != nil {
+		log.Printf("Error occurred trying to list api keys:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to retrieve api keys", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "api keys retrieved", map[string]interface{}{"api_keys": keys}, nil))
+}
+
+func revokeAPIKey(c *gin.Context, ownerID uint) {
+	var req APIKeyIDRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+	keyID := req.KeyID
+
+	revoked, err := auth_utils.RevokeAPIKey(keyID, ownerID)
+	if err != nil {
+		log.Printf("Error occurred trying to revoke api key:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to revoke api key", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+	if !revoked {
+		c.JSON(http.StatusNotFound, utils.ReturnJsonResponse("failed", "api key not found", nil, map[string]interface{}{"error": "no active api key with that id"}))
+		return
+	}
+
+	middleware.Audit(c, auth_utils.AuditAPIKeyRevoked, middleware.CurrentUserID(c), auth_utils.AuditOutcomeSuccess, map[string]interface{}{"key_id": keyID, "admin": ownerID == 0})
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "api key revoked", map[string]interface{}{"key_id": keyID}, nil))
+}
+
+func CreateAPIKeyHandler(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+	createAPIKey(c, middleware.CurrentUser(c), req)
+}
+
+func ListAPIKeysHandler(c *gin.Context) {
+	listAPIKeys(c, middleware.CurrentUserID(c))
+}
+
+func RevokeAPIKeyHandler(c *gin.Context) {
+	revokeAPIKey(c, middleware.CurrentUserID(c))
+}
+
+func AdminCreateAPIKeyHandler(c *gin.Context) {
+	var req AdminCreateAPIKeyRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+	if user, ok := adminTargetUser(c, req.UserID); ok {
+		createAPIKey(c, user, req.CreateAPIKeyRequest)
+	}
+}
+
+func AdminListAPIKeysHandler(c *gin.Context) {
+	var req UserIDRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+	if user, ok := adminTargetUser(c, req.UserID); ok {
+		listAPIKeys(c, user.ID)
+	}
+}
+
+// admins can revoke any key regardless of owner
+func AdminRevokeAPIKeyHandler(c *gin.Context) {
+	revokeAPIKey(c, 0)
+}
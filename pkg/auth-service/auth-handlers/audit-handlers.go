@@ -0,0 +1,74 @@
+package auth_handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	auth_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/auth-utils"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// admin view of the security audit trail, filter with user_id, actor_id, event_type, outcome, from and to (RFC 3339)
+// and page with before_id
+func AuditEventsHandler(c *gin.Context) {
+	filter := auth_utils.AuditFilter{
+		EventType: c.Query("event_type"),
+		Outcome:   c.Query("outcome"),
+	}
+	fieldErrors := map[string]interface{}{}
+
+	uintParams := map[string]*uint{"user_id": &filter.UserID, "actor_id": &filter.ActorID, "before_id": &filter.BeforeID}
+	for name, target := range uintParams {
+		if raw := c.Query(name); raw != "" {
+			value, err := strconv.ParseUint(raw, 10, 64)
+			if err != nil {
+				fieldErrors[name] = name + " must be a number"
+				continue
+			}
+			*target = uint(value)
+		}
+	}
+
+	timeParams := map[string]*time.Time{"from": &filter.From, "to": &filter.To}
+	for name, target := range timeParams {
+		if raw := c.Query(name); raw != "" {
+			value, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				fieldErrors[name] = name + " must be an RFC 3339 timestamp"
+				continue
+			}
+			*target = value
+		}
+	}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			fieldErrors["limit"] = "limit must be a positive number"
+		}
+		filter.Limit = limit
+	}
+
+	if len(fieldErrors) > 0 {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "invalid filters", nil, fieldErrors))
+		return
+	}
+
+	events, err := auth_utils.QueryAuditEvents(filter)
+	if err != nil {
+		log.Printf("Error occurred trying to query audit events:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to retrieve audit events", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	data := map[string]interface{}{"events": events}
+	if len(events) > 0 {
+		data["next_before_id"] = events[len(events)-1].ID
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "audit events retrieved", data, nil))
+}
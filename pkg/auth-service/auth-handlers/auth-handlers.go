@@ -1,11 +1,14 @@
 package auth_handlers
 
 import (
+	"errors"
 	"log"
 	"net/http"
-	"time"
+	"strconv"
+	"strings"
 
 	auth_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/auth-utils"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/middleware"
 	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
 	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
 	"github.com/Brian-Mashavakure/smart-prop-server/pkg/utils"
@@ -13,19 +16,33 @@ import (
 )
 
 func RegisterHandler(c *gin.Context) {
-	name := c.Request.FormValue("name")
-	email := c.Request.FormValue("email")
-	password := c.Request.FormValue("password")
-
-	hashedPassword := auth_utils.HashPassword(string(password))
+	var req RegisterRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	email := req.Email
+	password := req.Password
 
 	errorMessage := "Failed To Register User"
 
+	hashedPassword, hashErr := auth_utils.HashPassword(password)
+	if hasherBusy(c, hashErr, errorMessage) {
+		return
+	}
+	if hashErr != nil {
+		log.Printf("Error occurred trying to hash password:\n %v", hashErr)
+		hashError := utils.ReturnJsonResponse("failed", errorMessage, nil, map[string]interface{}{"error": "failed to secure password"})
+		c.JSON(http.StatusInternalServerError, hashError)
+		return
+	}
+
 	var existingUser models.User
 
 	oldEmail := connector.DB.Where("email = ?", email).First(&existingUser)
 	if oldEmail.Error == nil {
-		log.Printf("User Already Exists:\n %n", oldEmail.Error)
+		log.Println("User Already Exists")
+		middleware.Audit(c, auth_utils.AuditRegister, 0, auth_utils.AuditOutcomeFailure, map[string]interface{}{"reason": "email_taken", "email_hash": auth_utils.AuditEmailHash(email)})
 		oldEmailResponse := utils.ReturnJsonResponse("failed", errorMessage, nil, map[string]interface{}{"error": "user already exists"})
 		c.JSON(http.StatusOK, oldEmailResponse)
 		return
@@ -39,59 +56,137 @@ func RegisterHandler(c *gin.Context) {
 
 	result := connector.DB.Create(&user)
 	if result.Error != nil {
-		log.Printf("Error occurred trying to create user:\n %n", result.Error)
+		log.Printf("Error occurred trying to create user:\n %v", result.Error)
 		createError := utils.ReturnJsonResponse("failed", errorMessage, nil, map[string]interface{}{"error": "failed to create user in db"})
 		c.JSON(http.StatusInternalServerError, createError)
 		return
 	}
 
-	currentDateTime := time.Now().Format("20060102150405")
-	tokenString, tokenErr := auth_utils.GenerateJWTToken(currentDateTime, email)
+	if roleErr := auth_utils.GrantRole(user.ID, auth_utils.RoleTenant); roleErr != nil {
+		log.Printf("Error occurred trying to assign default role:\n %v", roleErr)
+	}
+
+	if mailErr := sendVerificationEmail(user); mailErr != nil {
+		log.Printf("Error occurred trying to send verification email:\n %v", mailErr)
+	}
+
+	tokens, tokenErr := auth_utils.CreateSession(user, middleware.Client(c))
 	if tokenErr != nil {
-		log.Printf("Error occurred trying to create user:\n %n", tokenErr)
+		log.Printf("Error occurred trying to create session:\n %v", tokenErr)
 		tokenError := utils.ReturnJsonResponse("failed", errorMessage, nil, map[string]interface{}{"error": "failed to generate token"})
 		c.JSON(http.StatusInternalServerError, tokenError)
 		return
 	}
 
-	finalResponse := utils.ReturnJsonResponse("success", "User created successfully", map[string]interface{}{"user_id": user.ID, "token": tokenString}, nil)
+	middleware.Audit(c, auth_utils.AuditRegister, user.ID, auth_utils.AuditOutcomeSuccess, nil)
+	middleware.Audit(c, auth_utils.AuditTokenIssued, user.ID, auth_utils.AuditOutcomeSuccess, map[string]interface{}{"session_id": tokens.SessionID})
+
+	finalResponse := utils.ReturnJsonResponse("success", "User created successfully", tokens.ResponseData(user.ID), nil)
 	c.Header("Content-Type", "application/json")
 	c.JSON(http.StatusOK, finalResponse)
 }
 
 func LoginHandler(c *gin.Context) {
-	email := c.Request.FormValue("email")
-	password := c.Request.FormValue("password")
+	var req LoginRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+	email := req.Email
+	password := req.Password
+	clientIP := c.ClientIP()
+
+	if wait := auth_utils.LoginRetryAfter(email, clientIP); wait > 0 {
+		log.Printf("Login throttled for %s\n", clientIP)
+		middleware.Audit(c, auth_utils.AuditLogin, 0, auth_utils.AuditOutcomeFailure, map[string]interface{}{"reason": "throttled", "email_hash": auth_utils.AuditEmailHash(email)})
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		throttleResponse := utils.ReturnJsonResponse("failed", "too many failed login attempts", nil, map[string]interface{}{"error": "too many failed login attempts, please try again later"})
+		c.JSON(http.StatusTooManyRequests, throttleResponse)
+		return
+	}
+
+	// unknown email and wrong password get the same response so accounts cannot be enumerated
+	invalidResponse := utils.ReturnJsonResponse("failed", "invalid credentials", nil, map[string]interface{}{"error": "incorrect email or password"})
 
 	var user models.User
 	result := connector.DB.Where("email = ?", email).First(&user)
 	if result.Error != nil {
-		log.Printf("Error occurred trying to find user:\n %n", result.Error)
-		resultResponse := utils.ReturnJsonResponse("failed", "User not found", nil, map[string]interface{}{"error": "user could not be found in our system"})
-		c.JSON(http.StatusBadRequest, resultResponse)
+		log.Printf("Error occurred trying to find user:\n %v", result.Error)
+		// burn the same hashing time as a real comparison so response timing does not leak the account
+		if hasherBusy(c, auth_utils.DummyPasswordCompare(password), "login failed") {
+			return
+		}
+		auth_utils.RecordLoginFailure(email, clientIP)
+		middleware.Audit(c, auth_utils.AuditLogin, 0, auth_utils.AuditOutcomeFailure, map[string]interface{}{"reason": "unknown_account", "email_hash": auth_utils.AuditEmailHash(email)})
+		c.JSON(http.StatusUnauthorized, invalidResponse)
 		return
 	}
 
 	//compare passwords
-	comparison := auth_utils.ComparePasswordAndHash(user.PASSWORD, password)
+	comparison, compareErr := auth_utils.ComparePasswordAndHash(user.PASSWORD, password)
+	if hasherBusy(c, compareErr, "login failed") {
+		return
+	}
 	if comparison == false {
 		log.Println("Passwords do not match")
-		passwordResponse := utils.ReturnJsonResponse("failed", "password mismatch", nil, map[string]interface{}{"error": "incorrect password provided"})
-		c.JSON(http.StatusBadRequest, passwordResponse)
+		auth_utils.RecordLoginFailure(email, clientIP)
+		middleware.Audit(c, auth_utils.AuditLogin, user.ID, auth_utils.AuditOutcomeFailure, map[string]interface{}{"reason": "bad_password"})
+		c.JSON(http.StatusUnauthorized, invalidResponse)
+		return
+	}
+
+	auth_utils.RecordLoginSuccess(email)
+	middleware.Audit(c, auth_utils.AuditLogin, user.ID, auth_utils.AuditOutcomeSuccess, map[string]interface{}{"method": "password"})
+
+	// upgrade hashes made under an older policy now that the plain password is at hand
+	if auth_utils.PasswordNeedsRehash(user.PASSWORD) {
+		if rehashed, hashErr := auth_utils.HashPassword(password); hashErr != nil {
+			log.Printf("Error occurred trying to rehash password:\n %v", hashErr)
+		} else if update := connector.DB.Model(&user).Update("password", rehashed); update.Error != nil {
+			log.Printf("Error occurred trying to store rehashed password:\n %v", update.Error)
+		}
+	}
+	completeLogin(c, user, "login successful")
+}
+
+func RefreshTokenHandler(c *gin.Context) {
+	var req RefreshTokenRequest
+	if !utils.BindRequest(c, &req) {
 		return
 	}
 
-	currentDateTime := time.Now().Format("20060102150405")
-	tokenString, err := auth_utils.GenerateJWTToken(currentDateTime, user.EMAIL)
+	tokens, user, err := auth_utils.RotateRefreshToken(req.RefreshToken, middleware.Client(c))
+	if errors.Is(err, auth_utils.ErrRefreshTokenReused) {
+		log.Println("Refresh token reuse detected, session revoked")
+		middleware.Audit(c, auth_utils.AuditTokenRefresh, user.ID, auth_utils.AuditOutcomeFailure, map[string]interface{}{"reason": "reuse"})
+		c.JSON(http.StatusUnauthorized, utils.ReturnJsonResponse("failed", "session revoked", nil, map[string]interface{}{"error": "refresh token has already been used, please log in again"}))
+		return
+	}
+	if errors.Is(err, auth_utils.ErrRefreshTokenInvalid) {
+		middleware.Audit(c, auth_utils.AuditTokenRefresh, 0, auth_utils.AuditOutcomeFailure, map[string]interface{}{"reason": "invalid"})
+		c.JSON(http.StatusUnauthorized, utils.ReturnJsonResponse("failed", "invalid refresh token", nil, map[string]interface{}{"error": err.Error()}))
+		return
+	}
 	if err != nil {
-		log.Println("Failed to generate token")
-		tokenResponse := utils.ReturnJsonResponse("failed", "bad request", nil, map[string]interface{}{"error": "something went wrong"})
-		c.JSON(http.StatusBadRequest, tokenResponse)
+		log.Printf("Error occurred trying to rotate refresh token:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to refresh token", nil, map[string]interface{}{"error": "something went wrong"}))
 		return
 	}
 
-	finalResponse := utils.ReturnJsonResponse("success", "login successful", map[string]interface{}{"user_id": user.ID, "token": tokenString}, nil)
+	middleware.Audit(c, auth_utils.AuditTokenRefresh, user.ID, auth_utils.AuditOutcomeSuccess, map[string]interface{}{"session_id": tokens.SessionID})
+
 	c.Header("Content-Type", "application/json")
-	c.JSON(http.StatusOK, finalResponse)
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "token refreshed", tokens.ResponseData(user.ID), nil))
+}
+
+// public signing keys in standard JWK Set form, left unwrapped so off the shelf jwt libraries can consume it
+func JWKSHandler(c *gin.Context) {
+	jwks, err := auth_utils.JWKS()
+	if err != nil {
+		log.Printf("Error occurred trying to build jwks:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "signing keys unavailable", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
 
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, jwks)
 }
@@ -0,0 +1,50 @@
+package auth_handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	auth_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/auth-utils"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/middleware"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// lets support act as a user, the token names the admin in its act claim and cannot be refreshed
+func ImpersonateHandler(c *gin.Context) {
+	admin := middleware.CurrentUser(c)
+	var req ImpersonateRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+	reason := req.Reason
+
+	target, ok := adminTargetUser(c, req.UserID)
+	if !ok {
+		return
+	}
+
+	token, err := auth_utils.GenerateImpersonationToken(admin, target, middleware.Client(c))
+	if errors.Is(err, auth_utils.ErrImpersonationNotAllowed) {
+		middleware.Audit(c, auth_utils.AuditImpersonationStarted, target.ID, auth_utils.AuditOutcomeFailure, map[string]interface{}{"admin_id": admin.ID, "reason": reason})
+		c.JSON(http.StatusForbidden, utils.ReturnJsonResponse("failed", "impersonation not allowed", nil, map[string]interface{}{"error": err.Error()}))
+		return
+	}
+	if err != nil {
+		log.Printf("Error occurred trying to issue impersonation token:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to impersonate user", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	middleware.Audit(c, auth_utils.AuditImpersonationStarted, target.ID, auth_utils.AuditOutcomeSuccess, map[string]interface{}{"admin_id": admin.ID, "reason": reason})
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "impersonation token issued", map[string]interface{}{
+		"user_id":       target.ID,
+		"token":         token,
+		"token_type":    "Bearer",
+		"expires_in":    int64(auth_utils.ImpersonationTTL.Seconds()),
+		"impersonating": true,
+	}, nil))
+}
@@ -0,0 +1,114 @@
+package auth_handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	auth_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/auth-utils"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/middleware"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	mail_service "github.com/Brian-Mashavakure/smart-prop-server/pkg/mail-service"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// requests over these limits are dropped quietly so the response stays the same for every email
+	accountLinkResendInterval = time.Minute
+	accountLinkDailyLimit     = 10
+)
+
+// issue and mail a single use link off the request, the caller answers straight away so neither the
+// outcome nor the time taken can tell anyone whether the account exists; failures are only logged
+func sendAccountLink(user models.User, purpose string, ttl time.Duration, send func(token string) error) {
+	go func() {
+		lastSent, sentToday, err := auth_utils.RecentSignedTokens(user.ID, purpose, time.Now().Add(-24*time.Hour))
+		if err != nil {
+			log.Printf("Error occurred trying to look up %s links:\n %v", purpose, err)
+			return
+		}
+		if sentToday >= accountLinkDailyLimit || time.Since(lastSent) < accountLinkResendInterval {
+			log.Printf("%s link for user %d suppressed by rate limit\n", purpose, user.ID)
+			return
+		}
+
+		token, err := auth_utils.IssueSignedToken(user.ID, purpose, ttl)
+		if err != nil {
+			log.Printf("Error occurred trying to issue %s link:\n %v", purpose, err)
+			return
+		}
+		if err := send(token); err != nil {
+			log.Printf("Error occurred trying to send %s link:\n %v", purpose, err)
+		}
+	}()
+}
+
+// email a single use login link, the response is the same whether or not the account exists
+func RequestMagicLinkHandler(c *gin.Context) {
+	var req EmailRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+	email := req.Email
+
+	finalResponse := utils.ReturnJsonResponse("success", "if the account exists a login link has been sent", nil, nil)
+
+	var user models.User
+	result := connector.DB.Where("email = ?", email).First(&user)
+	if result.Error != nil {
+		log.Println("Magic link requested for unknown email")
+		c.JSON(http.StatusOK, finalResponse)
+		return
+	}
+
+	sendAccountLink(user, auth_utils.PurposeMagicLogin, auth_utils.MagicLoginTTL, func(token string) error {
+		body := fmt.Sprintf("Hi %s,\n\nUse the link below to log in to Smart Prop. It expires in %v and can only be used once.\n\n%s\n\nIf you did not ask for this you can ignore this email.\n",
+			user.NAME, auth_utils.MagicLoginTTL, mail_service.AppLink("magic-login", token))
+		return mail_service.GetMailer().Send(user.EMAIL, "Your Smart Prop login link", body)
+	})
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, finalResponse)
+}
+
+// exchange a login link token for the same response a password login gives
+func MagicLinkLoginHandler(c *gin.Context) {
+	var req TokenRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+	token := req.Token
+
+	record, err := auth_utils.ConsumeSignedToken(token, auth_utils.PurposeMagicLogin)
+	if errors.Is(err, auth_utils.ErrSignedTokenInvalid) {
+		middleware.Audit(c, auth_utils.AuditLogin, 0, auth_utils.AuditOutcomeFailure, map[string]interface{}{"method": "magic_link", "reason": "invalid_token"})
+		c.JSON(http.StatusUnauthorized, utils.ReturnJsonResponse("failed", "invalid login link", nil, map[string]interface{}{"error": err.Error()}))
+		return
+	}
+	if err != nil {
+		log.Printf("Error occurred trying to consume login link:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to log in", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	var user models.User
+	if result := connector.DB.First(&user, record.UserID); result.Error != nil {
+		c.JSON(http.StatusUnauthorized, utils.ReturnJsonResponse("failed", "invalid login link", nil, map[string]interface{}{"error": auth_utils.ErrSignedTokenInvalid.Error()}))
+		return
+	}
+
+	// opening the link proves the user controls the address
+	if user.VerifiedAt == nil {
+		if update := connector.DB.Model(&user).Update("verified_at", time.Now()); update.Error != nil {
+			log.Printf("Error occurred trying to mark email verified:\n %v", update.Error)
+		}
+	}
+
+	middleware.Audit(c, auth_utils.AuditLogin, user.ID, auth_utils.AuditOutcomeSuccess, map[string]interface{}{"method": "magic_link"})
+
+	completeLogin(c, user, "login successful")
+}
@@ -0,0 +1,250 @@
+package auth_handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	auth_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/auth-utils"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/middleware"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// finish a login once the password (or another first factor) has checked out,
+// accounts with totp enabled get an mfa challenge instead of tokens
+func completeLogin(c *gin.Context, user models.User, message string) {
+	if user.TOTPEnabledAt != nil {
+		mfaToken, err := auth_utils.GenerateMFAToken(user)
+		if err != nil {
+			log.Printf("Failed to generate mfa token: %v", err)
+			c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "bad request", nil, map[string]interface{}{"error": "something went wrong"}))
+			return
+		}
+
+		c.Header("Content-Type", "application/json")
+		c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "mfa required", map[string]interface{}{
+			"user_id":      user.ID,
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+			"expires_in":   int64(auth_utils.MFATokenTTL.Seconds()),
+		}, nil))
+		return
+	}
+
+	issueLogin(c, user, message)
+}
+
+// start a session and respond with the standard login payload
+func issueLogin(c *gin.Context, user models.User, message string) {
+	tokens, err := auth_utils.CreateSession(user, middleware.Client(c))
+	if err != nil {
+		log.Printf("Failed to generate token: %v", err)
+		tokenResponse := utils.ReturnJsonResponse("failed", "bad request", nil, map[string]interface{}{"error": "something went wrong"})
+		c.JSON(http.StatusBadRequest, tokenResponse)
+		return
+	}
+
+	middleware.Audit(c, auth_utils.AuditTokenIssued, user.ID, auth_utils.AuditOutcomeSuccess, map[string]interface{}{"session_id": tokens.SessionID})
+
+	finalResponse := utils.ReturnJsonResponse("success", message, tokens.ResponseData(user.ID), nil)
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, finalResponse)
+}
+
+func EnrollMFAHandler(c *gin.Context) {
+	user := middleware.CurrentUser(c)
+
+	if user.TOTPEnabledAt != nil {
+		c.JSON(http.StatusConflict, utils.ReturnJsonResponse("failed", "mfa already enabled", nil, map[string]interface{}{"error": "two factor authentication is already enabled"}))
+		return
+	}
+
+	secret, err := auth_utils.GenerateTOTPSecret()
+	if err != nil {
+		log.Printf("Error occurred trying to generate totp secret:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to start enrollment", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	sealed, err := auth_utils.SealTOTPSecret(secret)
+	if err != nil {
+		log.Printf("Error occurred trying to encrypt totp secret:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to start enrollment", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	update := connector.DB.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{"totp_secret": sealed, "totp_last_step": 0})
+	if update.Error != nil {
+		log.Printf("Error occurred trying to store totp secret:\n %v", update.Error)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to start enrollment", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "scan the uri with an authenticator app and confirm a code", map[string]interface{}{
+		"secret":      secret,
+		"otpauth_uri": auth_utils.TOTPURI(secret, user.EMAIL),
+	}, nil))
+}
+
+func ConfirmMFAHandler(c *gin.Context) {
+	user := middleware.CurrentUser(c)
+	var req MFACodeRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+	code := req.Code
+
+	if user.TOTPEnabledAt != nil || user.TOTPSecret == "" {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "no pending enrollment", nil, map[string]interface{}{"error": "start enrollment before confirming a code"}))
+		return
+	}
+
+	valid, err := auth_utils.VerifyUserTOTP(user, code)
+	if err != nil {
+		log.Printf("Error occurred trying to verify totp code:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to confirm mfa", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+	if !valid {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "invalid code", nil, map[string]interface{}{"error": "the code is incorrect or has expired"}))
+		return
+	}
+
+	recoveryCodes, err := auth_utils.GenerateRecoveryCodes(user.ID)
+	if err != nil {
+		log.Printf("Error occurred trying to generate recovery codes:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to confirm mfa", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	update := connector.DB.Model(&models.User{}).Where("id = ?", user.ID).Update("totp_enabled_at", time.Now())
+	if update.Error != nil {
+		log.Printf("Error occurred trying to enable mfa:\n %v", update.Error)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to confirm mfa", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	middleware.Audit(c, auth_utils.AuditMFAEnabled, user.ID, auth_utils.AuditOutcomeSuccess, nil)
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "two factor authentication enabled, store the recovery codes somewhere safe", map[string]interface{}{"recovery_codes": recoveryCodes}, nil))
+}
+
+func DisableMFAHandler(c *gin.Context) {
+	user := middleware.CurrentUser(c)
+	var req SecondFactorRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+
+	if user.TOTPEnabledAt == nil {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "mfa not enabled", nil, map[string]interface{}{"error": "two factor authentication is not enabled"}))
+		return
+	}
+
+	if !checkSecondFactor(c, user, req.Code, req.RecoveryCode) {
+		middleware.Audit(c, auth_utils.AuditMFADisabled, user.ID, auth_utils.AuditOutcomeFailure, map[string]interface{}{"reason": "bad_code"})
+		return
+	}
+
+	err := connector.DB.Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{"totp_secret": "", "totp_enabled_at": nil, "totp_last_step": 0}).Error
+	if err == nil {
+		err = connector.DB.Unscoped().Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	}
+	if err != nil {
+		log.Printf("Error occurred trying to disable mfa:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to disable mfa", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	middleware.Audit(c, auth_utils.AuditMFADisabled, user.ID, auth_utils.AuditOutcomeSuccess, nil)
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "two factor authentication disabled", nil, nil))
+}
+
+// second step of a login for accounts with totp enabled
+func VerifyMFALoginHandler(c *gin.Context) {
+	var req MFALoginRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+	mfaToken := req.MFAToken
+	code := req.Code
+	recoveryCode := req.RecoveryCode
+
+	claims, err := auth_utils.ParseMFAToken(mfaToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ReturnJsonResponse("failed", "invalid mfa token", nil, map[string]interface{}{"error": err.Error()}))
+		return
+	}
+
+	userID := claims.UserID()
+	var user models.User
+	if userID == 0 || connector.DB.First(&user, userID).Error != nil {
+		c.JSON(http.StatusUnauthorized, utils.ReturnJsonResponse("failed", "invalid mfa token", nil, map[string]interface{}{"error": auth_utils.ErrMFATokenInvalid.Error()}))
+		return
+	}
+
+	if !checkSecondFactor(c, user, code, recoveryCode) {
+		middleware.Audit(c, auth_utils.AuditMFALogin, user.ID, auth_utils.AuditOutcomeFailure, map[string]interface{}{"reason": "bad_code"})
+		if auth_utils.RecordMFAFailure(claims) {
+			log.Printf("MFA challenge for user %d burned after too many attempts\n", user.ID)
+		}
+		return
+	}
+
+	if err := auth_utils.CompleteMFAChallenge(claims); err != nil {
+		log.Printf("Error occurred trying to burn mfa challenge:\n %v", err)
+	}
+
+	method := "totp"
+	if code == "" {
+		method = "recovery_code"
+	}
+	middleware.Audit(c, auth_utils.AuditMFALogin, user.ID, auth_utils.AuditOutcomeSuccess, map[string]interface{}{"method": method})
+
+	issueLogin(c, user, "login successful")
+}
+
+// check a totp or recovery code, writes the error response itself and returns false on failure
+func checkSecondFactor(c *gin.Context, user models.User, code string, recoveryCode string) bool {
+	if wait := auth_utils.MFARetryAfter(user); wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		c.JSON(http.StatusTooManyRequests, utils.ReturnJsonResponse("failed", "too many incorrect codes", nil, map[string]interface{}{"error": "too many incorrect codes, please try again later"}))
+		return false
+	}
+
+	var valid bool
+	var err error
+	if code != "" {
+		valid, err = auth_utils.VerifyUserTOTP(user, code)
+	} else {
+		valid, err = auth_utils.UseRecoveryCode(user.ID, recoveryCode)
+	}
+
+	if err != nil {
+		log.Printf("Error occurred trying to verify second factor:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to verify code", nil, map[string]interface{}{"error": "something went wrong"}))
+		return false
+	}
+	if !valid {
+		if locked, err := auth_utils.RecordUserMFAFailure(user.ID); err != nil {
+			log.Printf("Error occurred trying to record mfa failure:\n %v", err)
+		} else if locked {
+			log.Printf("Second factor for user %d locked after too many incorrect codes\n", user.ID)
+		}
+		c.JSON(http.StatusUnauthorized, utils.ReturnJsonResponse("failed", "invalid code", nil, map[string]interface{}{"error": "the code is incorrect or has expired"}))
+		return false
+	}
+
+	if err := auth_utils.ClearMFAFailures(user.ID); err != nil {
+		log.Printf("Error occurred trying to clear mfa failures:\n %v", err)
+	}
+	return true
+}
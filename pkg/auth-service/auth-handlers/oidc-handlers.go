@@ -0,0 +1,107 @@
+package auth_handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	auth_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/auth-utils"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/middleware"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// the binding cookie is scoped to the provider's login and callback routes, SameSite=Lax so it survives the
+// top level redirect back from the provider
+func setOIDCBindingCookie(c *gin.Context, provider *auth_utils.OIDCProvider, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     auth_utils.OIDCBindingCookie,
+		Value:    value,
+		Path:     path.Dir(c.Request.URL.Path) + "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(provider.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// start a social login, browsers navigating here with ?redirect=true are sent straight to the provider,
+// otherwise clients get the authorization_url and must keep the cookie set on this response
+func OIDCLoginHandler(c *gin.Context) {
+	provider, err := auth_utils.GetOIDCProvider(c.Param("provider"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, utils.ReturnJsonResponse("failed", "unknown provider", nil, map[string]interface{}{"error": err.Error()}))
+		return
+	}
+
+	authURL, binding, err := provider.AuthorizationURL()
+	if err != nil {
+		log.Printf("Error occurred trying to start %s login:\n %v", provider.Name, err)
+		c.JSON(http.StatusBadGateway, utils.ReturnJsonResponse("failed", "identity provider unavailable", nil, map[string]interface{}{"error": "could not reach the identity provider"}))
+		return
+	}
+
+	setOIDCBindingCookie(c, provider, binding, int(auth_utils.OAuthStateTTL.Seconds()))
+
+	if c.Query("redirect") == "true" {
+		c.Redirect(http.StatusFound, authURL)
+		return
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "redirect the user to the authorization url", map[string]interface{}{"authorization_url": authURL}, nil))
+}
+
+// the provider redirects back here with the authorization code
+func OIDCCallbackHandler(c *gin.Context) {
+	provider, err := auth_utils.GetOIDCProvider(c.Param("provider"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, utils.ReturnJsonResponse("failed", "unknown provider", nil, map[string]interface{}{"error": err.Error()}))
+		return
+	}
+
+	if providerErr := c.Query("error"); providerErr != "" {
+		log.Printf("%s login failed at the provider: %s\n", provider.Name, providerErr)
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "login was not completed", nil, map[string]interface{}{"error": providerErr}))
+		return
+	}
+
+	code := c.Query("code")
+	state := c.Query("state")
+	if code == "" || state == "" {
+		log.Println("code or state parameter is missing")
+		missingParamResponse := utils.ReturnJsonResponse("failed", "code and state are required", nil, map[string]interface{}{"error": "code or state parameter is missing"})
+		c.JSON(http.StatusBadRequest, missingParamResponse)
+		return
+	}
+
+	// the cookie is single use like the state it belongs to
+	binding, _ := c.Cookie(auth_utils.OIDCBindingCookie)
+	setOIDCBindingCookie(c, provider, "", -1)
+
+	claims, err := provider.Exchange(code, state, binding)
+	if errors.Is(err, auth_utils.ErrOIDCStateInvalid) || errors.Is(err, auth_utils.ErrOIDCTokenInvalid) {
+		log.Printf("Rejected %s login:\n %v", provider.Name, err)
+		middleware.Audit(c, auth_utils.AuditLogin, 0, auth_utils.AuditOutcomeFailure, map[string]interface{}{"method": "oidc", "provider": provider.Name, "reason": err.Error()})
+		c.JSON(http.StatusUnauthorized, utils.ReturnJsonResponse("failed", "login failed", nil, map[string]interface{}{"error": err.Error()}))
+		return
+	}
+	if err != nil {
+		log.Printf("Error occurred trying to complete %s login:\n %v", provider.Name, err)
+		c.JSON(http.StatusBadGateway, utils.ReturnJsonResponse("failed", "identity provider unavailable", nil, map[string]interface{}{"error": "could not complete login with the identity provider"}))
+		return
+	}
+
+	user, err := auth_utils.LinkOIDCIdentity(provider.Name, claims)
+	if err != nil {
+		log.Printf("Error occurred trying to link %s identity:\n %v", provider.Name, err)
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "login failed", nil, map[string]interface{}{"error": err.Error()}))
+		return
+	}
+
+	middleware.Audit(c, auth_utils.AuditLogin, user.ID, auth_utils.AuditOutcomeSuccess, map[string]interface{}{"method": "oidc", "provider": provider.Name})
+
+	completeLogin(c, user, "login successful")
+}
@@ -0,0 +1,106 @@
+package auth_handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	auth_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/auth-utils"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/middleware"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	mail_service "github.com/Brian-Mashavakure/smart-prop-server/pkg/mail-service"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// argon2 work is capped so a burst of logins cannot exhaust memory, callers past the cap are told to retry
+func hasherBusy(c *gin.Context, err error, message string) bool {
+	if !errors.Is(err, auth_utils.ErrPasswordHasherBusy) {
+		return false
+	}
+	log.Println("Password hashing is at capacity")
+	c.Header("Retry-After", "1")
+	c.JSON(http.StatusServiceUnavailable, utils.ReturnJsonResponse("failed", message, nil, map[string]interface{}{"error": err.Error()}))
+	return true
+}
+
+func RequestPasswordResetHandler(c *gin.Context) {
+	var req EmailRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+	email := req.Email
+
+	// same response whether or not the account exists so emails cannot be enumerated
+	finalResponse := utils.ReturnJsonResponse("success", "if the account exists a reset link has been sent", nil, nil)
+
+	var user models.User
+	result := connector.DB.Where("email = ?", email).First(&user)
+	if result.Error != nil {
+		log.Println("Password reset requested for unknown email")
+		c.JSON(http.StatusOK, finalResponse)
+		return
+	}
+
+	// rate limited like login links so nobody can flood an inbox with reset mails
+	middleware.Audit(c, auth_utils.AuditPasswordResetRequested, user.ID, auth_utils.AuditOutcomeSuccess, nil)
+	sendAccountLink(user, auth_utils.PurposePasswordReset, auth_utils.PasswordResetTTL, func(token string) error {
+		body := fmt.Sprintf("Hi %s,\n\nUse the link below to reset your Smart Prop password. It expires in %v and can only be used once.\n\n%s\n\nIf you did not ask for this you can ignore this email.\n",
+			user.NAME, auth_utils.PasswordResetTTL, mail_service.AppLink("reset-password", token))
+		return mail_service.GetMailer().Send(user.EMAIL, "Reset your password", body)
+	})
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, finalResponse)
+}
+
+func ConfirmPasswordResetHandler(c *gin.Context) {
+	var req ResetPasswordRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+	token := req.Token
+	password := req.Password
+
+	// hashed before the token is used up so a busy hasher does not cost the user their link
+	hashedPassword, hashErr := auth_utils.HashPassword(password)
+	if hasherBusy(c, hashErr, "failed to reset password") {
+		return
+	}
+	if hashErr != nil {
+		log.Printf("Error occurred trying to hash password:\n %v", hashErr)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to reset password", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	record, err := auth_utils.ConsumeSignedToken(token, auth_utils.PurposePasswordReset)
+	if errors.Is(err, auth_utils.ErrSignedTokenInvalid) {
+		middleware.Audit(c, auth_utils.AuditPasswordReset, 0, auth_utils.AuditOutcomeFailure, map[string]interface{}{"reason": "invalid_token"})
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "invalid reset token", nil, map[string]interface{}{"error": err.Error()}))
+		return
+	}
+	if err != nil {
+		log.Printf("Error occurred trying to consume reset token:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to reset password", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	update := connector.DB.Model(&models.User{}).Where("id = ?", record.UserID).Update("password", hashedPassword)
+	if update.Error != nil {
+		log.Printf("Error occurred trying to update password:\n %v", update.Error)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to reset password", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	// anyone holding the old password may already be logged in
+	if _, err := auth_utils.RevokeUserSessions(record.UserID); err != nil {
+		log.Printf("Error occurred trying to revoke sessions after password reset:\n %v", err)
+	}
+
+	middleware.Audit(c, auth_utils.AuditPasswordReset, record.UserID, auth_utils.AuditOutcomeSuccess, nil)
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "password has been reset", nil, nil))
+}
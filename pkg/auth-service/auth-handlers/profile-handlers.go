@@ -0,0 +1,297 @@
+package auth_handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	auth_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/auth-utils"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/middleware"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	mail_service "github.com/Brian-Mashavakure/smart-prop-server/pkg/mail-service"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// E.164 style numbers, an optional leading + followed by up to 15 digits
+	phonePattern    = regexp.MustCompile(`^\+?[1-9][0-9]{6,14}$`)
+	currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+	// language with an optional region, e.g. en or en-ZW
+	localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`)
+)
+
+func GetProfileHandler(c *gin.Context) {
+	user := middleware.CurrentUser(c)
+
+	roles, err := auth_utils.UserRoles(user)
+	if err != nil {
+		log.Printf("Error occurred trying to load roles:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to retrieve profile", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "profile retrieved", map[string]interface{}{"user": user, "roles": roles}, nil))
+}
+
+// only the fields present in the request are changed, send an empty value to clear optional fields
+func UpdateProfileHandler(c *gin.Context) {
+	user := middleware.CurrentUser(c)
+	var req UpdateProfileRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+
+	updates := map[string]interface{}{}
+	fieldErrors := map[string]interface{}{}
+
+	if req.Name != nil {
+		updates["name"] = strings.TrimSpace(*req.Name)
+	}
+
+	if req.Phone != nil {
+		phone := strings.ReplaceAll(strings.TrimSpace(*req.Phone), " ", "")
+		if phone != "" && !phonePattern.MatchString(phone) {
+			fieldErrors["phone"] = "phone must be an international number such as +263771234567"
+		}
+		updates["phone"] = phone
+	}
+
+	if req.AvatarURL != nil {
+		avatarURL := strings.TrimSpace(*req.AvatarURL)
+		if avatarURL != "" {
+			parsed, err := url.Parse(avatarURL)
+			if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+				fieldErrors["avatar_url"] = "avatar_url must be an http or https url"
+			}
+		}
+		updates["avatar_url"] = avatarURL
+	}
+
+	if req.PreferredCurrency != nil {
+		currency := strings.ToUpper(strings.TrimSpace(*req.PreferredCurrency))
+		if currency != "" && !currencyPattern.MatchString(currency) {
+			fieldErrors["preferred_currency"] = "preferred_currency must be a three letter ISO 4217 code"
+		}
+		updates["preferred_currency"] = currency
+	}
+
+	if req.Locale != nil {
+		locale := strings.TrimSpace(*req.Locale)
+		if locale != "" && !localePattern.MatchString(locale) {
+			fieldErrors["locale"] = "locale must look like en or en-ZW"
+		}
+		updates["locale"] = locale
+	}
+
+	if len(fieldErrors) > 0 {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "invalid request", nil, fieldErrors))
+		return
+	}
+
+	if len(updates) == 0 {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "nothing to update", nil, map[string]interface{}{"error": "send at least one of name, phone, avatar_url, preferred_currency or locale"}))
+		return
+	}
+
+	result := connector.DB.Model(&user).Updates(updates)
+	if result.Error != nil {
+		log.Printf("Error occurred trying to update profile:\n %v", result.Error)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to update profile", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "profile updated", map[string]interface{}{"user": user}, nil))
+}
+
+// other sessions are logged out, the session making the change stays signed in
+func ChangePasswordHandler(c *gin.Context) {
+	user := middleware.CurrentUser(c)
+	var req ChangePasswordRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+	currentPassword := req.CurrentPassword
+	newPassword := req.NewPassword
+
+	// accounts created through social login have no password, they set one with the reset flow
+	match, err := auth_utils.ComparePasswordAndHash(user.PASSWORD, currentPassword)
+	if hasherBusy(c, err, "failed to change password") {
+		return
+	}
+	if !match {
+		middleware.Audit(c, auth_utils.AuditPasswordChange, user.ID, auth_utils.AuditOutcomeFailure, map[string]interface{}{"reason": "bad_password"})
+		c.JSON(http.StatusUnauthorized, utils.ReturnJsonResponse("failed", "current password is incorrect", nil, map[string]interface{}{"error": "current password is incorrect"}))
+		return
+	}
+
+	hashedPassword, hashErr := auth_utils.HashPassword(newPassword)
+	if hasherBusy(c, hashErr, "failed to change password") {
+		return
+	}
+	if hashErr != nil {
+		log.Printf("Error occurred trying to hash password:\n %v", hashErr)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to change password", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	update := connector.DB.Model(&models.User{}).Where("id = ?", user.ID).Update("password", hashedPassword)
+	if update.Error != nil {
+		log.Printf("Error occurred trying to update password:\n %v", update.Error)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to change password", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	keepSession := ""
+	if claims := middleware.CurrentClaims(c); claims != nil {
+		keepSession = claims.SessionID
+	}
+	revoked, err := auth_utils.RevokeOtherSessions(user.ID, keepSession)
+	if err != nil {
+		log.Printf("Error occurred trying to revoke sessions after password change:\n %v", err)
+	}
+
+	middleware.Audit(c, auth_utils.AuditPasswordChange, user.ID, auth_utils.AuditOutcomeSuccess, map[string]interface{}{"sessions_revoked": revoked})
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "password changed", map[string]interface{}{"sessions_revoked": revoked}, nil))
+}
+
+// the new address only replaces the current one once the link sent to it has been opened
+func ChangeEmailHandler(c *gin.Context) {
+	user := middleware.CurrentUser(c)
+	var req ChangeEmailRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+	newEmail := req.NewEmail
+	password := req.Password
+
+	match, err := auth_utils.ComparePasswordAndHash(user.PASSWORD, password)
+	if hasherBusy(c, err, "failed to change email") {
+		return
+	}
+	if !match {
+		middleware.Audit(c, auth_utils.AuditEmailChangeRequested, user.ID, auth_utils.AuditOutcomeFailure, map[string]interface{}{"reason": "bad_password"})
+		c.JSON(http.StatusUnauthorized, utils.ReturnJsonResponse("failed", "password is incorrect", nil, map[string]interface{}{"error": "password is incorrect"}))
+		return
+	}
+
+	if strings.EqualFold(newEmail, user.EMAIL) {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "email unchanged", nil, map[string]interface{}{"error": "new email is the same as the current email"}))
+		return
+	}
+
+	var existingUser models.User
+	if taken := connector.DB.Where("email = ?", newEmail).First(&existingUser); taken.Error == nil {
+		c.JSON(http.StatusConflict, utils.ReturnJsonResponse("failed", "email already in use", nil, map[string]interface{}{"error": "another account already uses this email"}))
+		return
+	}
+
+	update := connector.DB.Model(&models.User{}).Where("id = ?", user.ID).Update("pending_email", newEmail)
+	if update.Error != nil {
+		log.Printf("Error occurred trying to store pending email:\n %v", update.Error)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to change email", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	requestingSession := ""
+	if claims := middleware.CurrentClaims(c); claims != nil {
+		requestingSession = claims.SessionID
+	}
+	token, err := auth_utils.IssueEmailChangeToken(user.ID, newEmail, requestingSession)
+	if err != nil {
+		log.Printf("Error occurred trying to issue email change token:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to change email", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nConfirm this address as the new email for your Smart Prop account using the link below. It expires in %v.\n\n%s\n\nIf you did not ask for this you can ignore this email.\n",
+		user.NAME, auth_utils.EmailChangeTTL, mail_service.AppLink("confirm-email-change", token))
+	if err := mail_service.GetMailer().Send(newEmail, "Confirm your new email address", body); err != nil {
+		log.Printf("Error occurred trying to send email change confirmation:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to change email", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	middleware.Audit(c, auth_utils.AuditEmailChangeRequested, user.ID, auth_utils.AuditOutcomeSuccess, map[string]interface{}{"new_email_hash": auth_utils.AuditEmailHash(newEmail)})
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "a confirmation link has been sent to the new email address", map[string]interface{}{"pending_email": newEmail}, nil))
+}
+
+// access tokens carry the old address, clients have to refresh after the change
+func ConfirmEmailChangeHandler(c *gin.Context) {
+	var req TokenRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+	token := req.Token
+
+	record, err := auth_utils.ConsumeSignedToken(token, auth_utils.PurposeEmailChange)
+	if errors.Is(err, auth_utils.ErrSignedTokenInvalid) {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "invalid confirmation token", nil, map[string]interface{}{"error": err.Error()}))
+		return
+	}
+	if err != nil {
+		log.Printf("Error occurred trying to consume email change token:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to change email", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	var user models.User
+	if result := connector.DB.First(&user, record.UserID); result.Error != nil || user.PendingEmail == "" {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "no email change pending", nil, map[string]interface{}{"error": "there is no pending email change for this account"}))
+		return
+	}
+	// a link sent to an address that has since been replaced by another request cannot confirm the new one
+	if !auth_utils.EmailChangeTokenMatches(record, user.PendingEmail) {
+		middleware.Audit(c, auth_utils.AuditEmailChange, user.ID, auth_utils.AuditOutcomeFailure, map[string]interface{}{"reason": "stale_token"})
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "invalid confirmation token", nil, map[string]interface{}{"error": "this link was sent to an address that is no longer pending"}))
+		return
+	}
+
+	// the address may have been registered since the change was requested
+	var existingUser models.User
+	if taken := connector.DB.Where("email = ? AND id <> ?", user.PendingEmail, user.ID).First(&existingUser); taken.Error == nil {
+		c.JSON(http.StatusConflict, utils.ReturnJsonResponse("failed", "email already in use", nil, map[string]interface{}{"error": "another account already uses this email"}))
+		return
+	}
+
+	oldEmail, newEmail := user.EMAIL, user.PendingEmail
+	update := connector.DB.Model(&user).Updates(map[string]interface{}{
+		"email":         newEmail,
+		"pending_email": "",
+		"verified_at":   time.Now(),
+	})
+	if update.Error != nil {
+		log.Printf("Error occurred trying to update email:\n %v", update.Error)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to change email", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	// whoever was signed in under the old address is logged out, the session that asked for the change stays
+	revoked, err := auth_utils.RevokeOtherSessions(user.ID, record.SessionID)
+	if err != nil {
+		log.Printf("Error occurred trying to revoke sessions after email change:\n %v", err)
+	}
+
+	middleware.Audit(c, auth_utils.AuditEmailChange, user.ID, auth_utils.AuditOutcomeSuccess, map[string]interface{}{"old_email_hash": auth_utils.AuditEmailHash(oldEmail), "new_email_hash": auth_utils.AuditEmailHash(newEmail), "sessions_revoked": revoked})
+
+	body := fmt.Sprintf("Hi %s,\n\nThe email address on your Smart Prop account was changed to %s. If this was not you, reset your password and contact support.\n",
+		user.NAME, newEmail)
+	if err := mail_service.GetMailer().Send(oldEmail, "Your email address was changed", body); err != nil {
+		log.Printf("Error occurred trying to notify previous email:\n %v", err)
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "email changed", map[string]interface{}{"user_id": user.ID, "email": newEmail}, nil))
+}
@@ -0,0 +1,127 @@
+package auth_handlers
+
+import (
+	auth_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/auth-utils"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/utils"
+	"github.com/go-playground/validator/v10"
+)
+
+// request bodies for the auth endpoints, each binds from json or form data via utils.BindRequest
+
+func init() {
+	// new passwords have to meet the policy, logins still accept whatever was set before it existed
+	err := utils.RegisterValidation("password", func(fl validator.FieldLevel) bool {
+		return auth_utils.CheckPasswordPolicy(fl.Field().String()) == nil
+	}, "%s must be 8 to 128 characters (72 bytes when hashed with bcrypt), contain a letter and a number or symbol and not be a common password")
+	if err != nil {
+		panic(err)
+	}
+}
+
+type RegisterRequest struct {
+	Name     string `json:"name" form:"name" binding:"required,notblank,max=100"`
+	Email    string `json:"email" form:"email" binding:"required,email,max=255"`
+	Password string `json:"password" form:"password" binding:"required,password"`
+}
+
+type LoginRequest struct {
+	Email    string `json:"email" form:"email" binding:"required,email"`
+	Password string `json:"password" form:"password" binding:"required,max=128"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" form:"refresh_token" binding:"required"`
+}
+
+// password reset and magic link requests
+type EmailRequest struct {
+	Email string `json:"email" form:"email" binding:"required,email"`
+}
+
+// email verification, email change confirmation and magic link login
+type TokenRequest struct {
+	Token string `json:"token" form:"token" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" form:"token" binding:"required"`
+	Password string `json:"password" form:"password" binding:"required,password"`
+}
+
+type UpdateProfileRequest struct {
+	Name              *string `json:"name" form:"name" binding:"omitempty,notblank,max=100"`
+	Phone             *string `json:"phone" form:"phone" binding:"omitempty,max=20"`
+	AvatarURL         *string `json:"avatar_url" form:"avatar_url" binding:"omitempty,max=500"`
+	PreferredCurrency *string `json:"preferred_currency" form:"preferred_currency" binding:"omitempty,max=3"`
+	Locale            *string `json:"locale" form:"locale" binding:"omitempty,max=20"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" form:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" form:"new_password" binding:"required,password,nefield=CurrentPassword"`
+}
+
+type ChangeEmailRequest struct {
+	NewEmail string `json:"new_email" form:"new_email" binding:"required,email,max=255"`
+	Password string `json:"password" form:"password" binding:"required"`
+}
+
+// accounts without a password (social login only) confirm deletion by email instead
+type AccountDeletionRequest struct {
+	Password string `json:"password" form:"password" binding:"omitempty,max=128"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" form:"code" binding:"required,numeric,len=6"`
+}
+
+// a totp code or, when the authenticator is lost, a recovery code
+type SecondFactorRequest struct {
+	Code         string `json:"code" form:"code" binding:"required_without=RecoveryCode,omitempty,numeric,len=6"`
+	RecoveryCode string `json:"recovery_code" form:"recovery_code" binding:"required_without=Code,omitempty,max=20"`
+}
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" form:"mfa_token" binding:"required"`
+	SecondFactorRequest
+}
+
+type SessionRequest struct {
+	SessionID string `json:"session_id" form:"session_id" binding:"required,max=64"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name          string `json:"name" form:"name" binding:"required,notblank,max=100"`
+	Scopes        string `json:"scopes" form:"scopes" binding:"required"`
+	ExpiresInDays int    `json:"expires_in_days" form:"expires_in_days" binding:"omitempty,min=1,max=365"`
+}
+
+type APIKeyIDRequest struct {
+	KeyID uint `json:"key_id" form:"key_id" binding:"required"`
+}
+
+// admin endpoints that act on another account
+type UserIDRequest struct {
+	UserID uint `json:"user_id" form:"user_id" binding:"required"`
+}
+
+type AdminCreateAPIKeyRequest struct {
+	UserIDRequest
+	CreateAPIKeyRequest
+}
+
+type RoleRequest struct {
+	UserIDRequest
+	Role string `json:"role" form:"role" binding:"required,oneof=tenant landlord agent admin"`
+}
+
+type ImpersonateRequest struct {
+	UserIDRequest
+	Reason string `json:"reason" form:"reason" binding:"required,notblank,max=500"`
+}
+
+type AdminRevokeRequest struct {
+	TokenID   string `json:"token_id" form:"token_id" binding:"omitempty,max=64"`
+	SessionID string `json:"session_id" form:"session_id" binding:"omitempty,max=64"`
+	UserID    uint   `json:"user_id" form:"user_id"`
+}
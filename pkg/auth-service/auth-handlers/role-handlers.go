@@ -0,0 +1,92 @@
+package auth_handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	auth_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/auth-utils"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/middleware"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// bind the user_id and role shared by the role endpoints and load the user
+func roleRequest(c *gin.Context) (models.User, string, bool) {
+	var req RoleRequest
+	if !utils.BindRequest(c, &req) {
+		return models.User{}, "", false
+	}
+
+	user, ok := adminTargetUser(c, req.UserID)
+	if !ok {
+		return models.User{}, "", false
+	}
+	return user, req.Role, true
+}
+
+func GrantRoleHandler(c *gin.Context) {
+	user, role, ok := roleRequest(c)
+	if !ok {
+		return
+	}
+
+	if err := auth_utils.GrantRole(user.ID, role); err != nil {
+		log.Printf("Error occurred trying to grant role:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to grant role", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	roles, err := auth_utils.UserRoles(user)
+	if err != nil {
+		log.Printf("Error occurred trying to load roles:\n %v", err)
+	}
+
+	middleware.Audit(c, auth_utils.AuditRoleGranted, user.ID, auth_utils.AuditOutcomeSuccess, map[string]interface{}{"role": role, "admin_id": middleware.CurrentUserID(c)})
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "role granted", map[string]interface{}{"user_id": user.ID, "roles": roles}, nil))
+}
+
+func RevokeRoleHandler(c *gin.Context) {
+	user, role, ok := roleRequest(c)
+	if !ok {
+		return
+	}
+
+	removed, err := auth_utils.RevokeRole(user, role)
+	if errors.Is(err, auth_utils.ErrLastRole) {
+		c.JSON(http.StatusConflict, utils.ReturnJsonResponse("failed", "cannot revoke last role", nil, map[string]interface{}{"error": err.Error()}))
+		return
+	}
+	if errors.Is(err, auth_utils.ErrBootstrapAdminRole) {
+		c.JSON(http.StatusConflict, utils.ReturnJsonResponse("failed", "cannot revoke bootstrap admin", nil, map[string]interface{}{"error": err.Error()}))
+		return
+	}
+	if err != nil {
+		log.Printf("Error occurred trying to revoke role:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to revoke role", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	if !removed {
+		c.JSON(http.StatusNotFound, utils.ReturnJsonResponse("failed", "role not held", nil, map[string]interface{}{"error": "user does not have this role"}))
+		return
+	}
+
+	// roles are baked into access tokens, end the user's sessions so the change applies immediately
+	if _, err := auth_utils.RevokeUserSessions(user.ID); err != nil {
+		log.Printf("Error occurred trying to revoke sessions after role change:\n %v", err)
+	}
+
+	roles, err := auth_utils.UserRoles(user)
+	if err != nil {
+		log.Printf("Error occurred trying to load roles:\n %v", err)
+	}
+
+	middleware.Audit(c, auth_utils.AuditRoleRevoked, user.ID, auth_utils.AuditOutcomeSuccess, map[string]interface{}{"role": role, "admin_id": middleware.CurrentUserID(c)})
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "role revoked", map[string]interface{}{"user_id": user.ID, "roles": roles}, nil))
+}
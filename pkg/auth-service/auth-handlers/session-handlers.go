@@ -0,0 +1,165 @@
+package auth_handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	auth_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/auth-utils"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/middleware"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func LogoutHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(*auth_utils.JWTClaims)
+
+	if err := auth_utils.RevokeAccessToken(claims.ID, claims.Expiry()); err != nil {
+		log.Printf("Error occurred trying to revoke token:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to log out", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	if claims.SessionID != "" {
+		if err := auth_utils.RevokeSession(claims.SessionID); err != nil {
+			log.Printf("Error occurred trying to revoke session:\n %v", err)
+			c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to log out", nil, map[string]interface{}{"error": "something went wrong"}))
+			return
+		}
+	}
+
+	middleware.Audit(c, auth_utils.AuditLogout, middleware.CurrentUserID(c), auth_utils.AuditOutcomeSuccess, map[string]interface{}{"session_id": claims.SessionID})
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "logged out", nil, nil))
+}
+
+func LogoutAllHandler(c *gin.Context) {
+	claims := c.MustGet("claims").(*auth_utils.JWTClaims)
+	user := middleware.CurrentUser(c)
+
+	revoked, err := auth_utils.RevokeUserSessions(user.ID)
+	if err == nil {
+		err = auth_utils.RevokeAccessToken(claims.ID, claims.Expiry())
+	}
+	if err != nil {
+		log.Printf("Error occurred trying to revoke sessions:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to log out", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	middleware.Audit(c, auth_utils.AuditLogout, user.ID, auth_utils.AuditOutcomeSuccess, map[string]interface{}{"all_sessions": true, "sessions_revoked": revoked})
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "logged out of all devices", map[string]interface{}{"sessions_revoked": revoked}, nil))
+}
+
+// admins can revoke a single token, a single session or every session of a user
+func AdminRevokeHandler(c *gin.Context) {
+	var req AdminRevokeRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+	tokenID, sessionID, userID := req.TokenID, req.SessionID, req.UserID
+
+	if tokenID == "" && sessionID == "" && userID == 0 {
+		log.Println("nothing to revoke")
+		missingParamResponse := utils.ReturnJsonResponse("failed", "token_id, session_id or user_id is required", nil, map[string]interface{}{"error": "nothing to revoke"})
+		c.JSON(http.StatusBadRequest, missingParamResponse)
+		return
+	}
+
+	revoked := map[string]interface{}{}
+
+	if tokenID != "" {
+		// the exact expiry is unknown here, no access token outlives AccessTokenTTL
+		if err := auth_utils.RevokeAccessToken(tokenID, time.Now().Add(auth_utils.AccessTokenTTL)); err != nil {
+			log.Printf("Error occurred trying to revoke token:\n %v", err)
+			c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to revoke token", nil, map[string]interface{}{"error": "something went wrong"}))
+			return
+		}
+		revoked["token_id"] = tokenID
+	}
+
+	if sessionID != "" {
+		if err := auth_utils.RevokeSession(sessionID); err != nil {
+			log.Printf("Error occurred trying to revoke session:\n %v", err)
+			c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to revoke session", nil, map[string]interface{}{"error": "something went wrong"}))
+			return
+		}
+		revoked["session_id"] = sessionID
+	}
+
+	if userID != 0 {
+		count, err := auth_utils.RevokeUserSessions(userID)
+		if err != nil {
+			log.Printf("Error occurred trying to revoke user sessions:\n %v", err)
+			c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to revoke sessions", nil, map[string]interface{}{"error": "something went wrong"}))
+			return
+		}
+		revoked["user_id"] = userID
+		revoked["sessions_revoked"] = count
+	}
+
+	revoked["admin_id"] = middleware.CurrentUserID(c)
+	middleware.Audit(c, auth_utils.AuditAdminRevoke, userID, auth_utils.AuditOutcomeSuccess, revoked)
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "revoked", revoked, nil))
+}
+
+// devices the user is signed in on, the session making the request is marked current
+func ListSessionsHandler(c *gin.Context) {
+	user := middleware.CurrentUser(c)
+	claims := middleware.CurrentClaims(c)
+
+	sessions, err := auth_utils.ActiveSessions(user.ID)
+	if err != nil {
+		log.Printf("Error occurred trying to list sessions:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to retrieve sessions", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	listed := make([]map[string]interface{}, 0, len(sessions))
+	for _, session := range sessions {
+		listed = append(listed, map[string]interface{}{
+			"session_id":   session.SessionID,
+			"device":       session.Device,
+			"user_agent":   session.UserAgent,
+			"ip":           session.IP,
+			"created_at":   session.CreatedAt,
+			"last_seen_at": session.LastSeenAt,
+			"expires_at":   session.ExpiresAt,
+			"current":      claims != nil && claims.SessionID == session.SessionID,
+		})
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "sessions retrieved", map[string]interface{}{"sessions": listed}, nil))
+}
+
+// sign a single device out, users can only revoke their own sessions
+func RevokeSessionHandler(c *gin.Context) {
+	user := middleware.CurrentUser(c)
+	var req SessionRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+	sessionID := req.SessionID
+
+	revoked, err := auth_utils.RevokeOwnSession(user.ID, sessionID)
+	if err != nil {
+		log.Printf("Error occurred trying to revoke session:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to revoke session", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+	if !revoked {
+		c.JSON(http.StatusNotFound, utils.ReturnJsonResponse("failed", "session not found", nil, map[string]interface{}{"error": "no active session with that id"}))
+		return
+	}
+
+	middleware.Audit(c, auth_utils.AuditLogout, user.ID, auth_utils.AuditOutcomeSuccess, map[string]interface{}{"session_id": sessionID, "remote": true})
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "session revoked", map[string]interface{}{"session_id": sessionID}, nil))
+}
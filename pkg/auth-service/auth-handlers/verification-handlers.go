@@ -0,0 +1,102 @@
+package auth_handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	auth_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/auth-utils"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/middleware"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	mail_service "github.com/Brian-Mashavakure/smart-prop-server/pkg/mail-service"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// minimum gap between two verification emails and the daily cap per account
+	verificationResendInterval = time.Minute
+	verificationDailyLimit     = 5
+)
+
+func sendVerificationEmail(user models.User) error {
+	token, err := auth_utils.IssueSignedToken(user.ID, auth_utils.PurposeEmailVerification, auth_utils.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nPlease confirm your email address for Smart Prop using the link below. It expires in %v.\n\n%s\n",
+		user.NAME, auth_utils.EmailVerificationTTL, mail_service.AppLink("verify-email", token))
+	return mail_service.GetMailer().Send(user.EMAIL, "Confirm your email address", body)
+}
+
+func VerifyEmailHandler(c *gin.Context) {
+	var req TokenRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+	token := req.Token
+
+	record, err := auth_utils.ConsumeSignedToken(token, auth_utils.PurposeEmailVerification)
+	if errors.Is(err, auth_utils.ErrSignedTokenInvalid) {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "invalid verification token", nil, map[string]interface{}{"error": err.Error()}))
+		return
+	}
+	if err != nil {
+		log.Printf("Error occurred trying to consume verification token:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to verify email", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	update := connector.DB.Model(&models.User{}).Where("id = ? AND verified_at IS NULL", record.UserID).Update("verified_at", time.Now())
+	if update.Error != nil {
+		log.Printf("Error occurred trying to mark email verified:\n %v", update.Error)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to verify email", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	middleware.Audit(c, auth_utils.AuditEmailVerified, record.UserID, auth_utils.AuditOutcomeSuccess, nil)
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "email verified", map[string]interface{}{"user_id": record.UserID}, nil))
+}
+
+func ResendVerificationHandler(c *gin.Context) {
+	user := middleware.CurrentUser(c)
+
+	if user.VerifiedAt != nil {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "email already verified", nil, map[string]interface{}{"error": "this email address has already been verified"}))
+		return
+	}
+
+	lastSent, sentToday, err := auth_utils.RecentSignedTokens(user.ID, auth_utils.PurposeEmailVerification, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		log.Printf("Error occurred trying to look up verification emails:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to resend verification", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	if sentToday >= verificationDailyLimit {
+		c.JSON(http.StatusTooManyRequests, utils.ReturnJsonResponse("failed", "too many verification emails", nil, map[string]interface{}{"error": "daily verification email limit reached"}))
+		return
+	}
+
+	if wait := verificationResendInterval - time.Since(lastSent); wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		c.JSON(http.StatusTooManyRequests, utils.ReturnJsonResponse("failed", "too many verification emails", nil, map[string]interface{}{"error": "please wait before requesting another verification email"}))
+		return
+	}
+
+	if err := sendVerificationEmail(user); err != nil {
+		log.Printf("Error occurred trying to send verification email:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to resend verification", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "verification email sent", nil, nil))
+}
@@ -2,12 +2,58 @@ package auth_routes
 
 import (
 	auth_handlers "github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/auth-handlers"
+	auth_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/auth-utils"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/middleware"
 	"github.com/gin-gonic/gin"
 )
 
 func AuthRoutes(router *gin.Engine) {
+	router.GET("/.well-known/jwks.json", auth_handlers.JWKSHandler)
+
 	api := router.Group("/smart-prop-api/auth/")
 
+	// account and credential changes stay out of reach of support staff impersonating a user
+	noImpersonation := middleware.BlockImpersonation()
+
 	api.POST("register-user", auth_handlers.RegisterHandler)
 	api.POST("login-user", auth_handlers.LoginHandler)
+	api.POST("refresh-token", auth_handlers.RefreshTokenHandler)
+	api.POST("magic-link/request", auth_handlers.RequestMagicLinkHandler)
+	api.POST("magic-link/login", auth_handlers.MagicLinkLoginHandler)
+	api.POST("request-password-reset", auth_handlers.RequestPasswordResetHandler)
+	api.POST("confirm-password-reset", auth_handlers.ConfirmPasswordResetHandler)
+	api.POST("verify-email", auth_handlers.VerifyEmailHandler)
+	api.POST("resend-verification", middleware.JWTMiddleware(), auth_handlers.ResendVerificationHandler)
+	api.GET("me", middleware.JWTMiddleware(), auth_handlers.GetProfileHandler)
+	api.PATCH("me", middleware.JWTMiddleware(), auth_handlers.UpdateProfileHandler)
+	api.POST("change-password", middleware.JWTMiddleware(), noImpersonation, auth_handlers.ChangePasswordHandler)
+	api.POST("change-email", middleware.JWTMiddleware(), noImpersonation, auth_handlers.ChangeEmailHandler)
+	api.POST("confirm-email-change", auth_handlers.ConfirmEmailChangeHandler)
+	api.GET("account/export", middleware.JWTMiddleware(), noImpersonation, auth_handlers.ExportAccountHandler)
+	api.POST("account/delete", middleware.JWTMiddleware(), noImpersonation, auth_handlers.RequestAccountDeletionHandler)
+	api.POST("account/confirm-deletion", auth_handlers.ConfirmAccountDeletionHandler)
+	api.POST("account/cancel-deletion", middleware.JWTMiddleware(), noImpersonation, auth_handlers.CancelAccountDeletionHandler)
+	api.GET("oidc/:provider/login", auth_handlers.OIDCLoginHandler)
+	api.GET("oidc/:provider/callback", auth_handlers.OIDCCallbackHandler)
+	api.POST("mfa/verify-login", auth_handlers.VerifyMFALoginHandler)
+	api.POST("mfa/enroll", middleware.JWTMiddleware(), noImpersonation, auth_handlers.EnrollMFAHandler)
+	api.POST("mfa/confirm", middleware.JWTMiddleware(), noImpersonation, auth_handlers.ConfirmMFAHandler)
+	api.POST("mfa/disable", middleware.JWTMiddleware(), noImpersonation, auth_handlers.DisableMFAHandler)
+	api.POST("logout", middleware.JWTMiddleware(), auth_handlers.LogoutHandler)
+	api.POST("logout-all", middleware.JWTMiddleware(), noImpersonation, auth_handlers.LogoutAllHandler)
+	api.GET("sessions", middleware.JWTMiddleware(), auth_handlers.ListSessionsHandler)
+	api.POST("sessions/revoke", middleware.JWTMiddleware(), noImpersonation, auth_handlers.RevokeSessionHandler)
+	api.POST("api-keys", middleware.JWTMiddleware(), noImpersonation, auth_handlers.CreateAPIKeyHandler)
+	api.GET("api-keys", middleware.JWTMiddleware(), auth_handlers.ListAPIKeysHandler)
+	api.POST("api-keys/revoke", middleware.JWTMiddleware(), noImpersonation, auth_handlers.RevokeAPIKeyHandler)
+
+	admin := api.Group("admin/", middleware.JWTMiddleware(), middleware.RequireRole(auth_utils.RoleAdmin))
+	admin.POST("revoke-token", auth_handlers.AdminRevokeHandler)
+	admin.GET("audit-events", auth_handlers.AuditEventsHandler)
+	admin.POST("impersonate", auth_handlers.ImpersonateHandler)
+	admin.POST("grant-role", auth_handlers.GrantRoleHandler)
+	admin.POST("revoke-role", auth_handlers.RevokeRoleHandler)
+	admin.POST("api-keys", auth_handlers.AdminCreateAPIKeyHandler)
+	admin.GET("api-keys", auth_handlers.AdminListAPIKeysHandler)
+	admin.POST("api-keys/revoke", auth_handlers.AdminRevokeAPIKeyHandler)
 }
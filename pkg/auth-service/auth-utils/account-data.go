@@ -0,0 +1,224 @@
+package auth_utils
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const accountPurgeInterval = time.Hour
+
+var accountPurgerOnce sync.Once
+
+// days between a deletion request and the personal data being erased, ACCOUNT_DELETION_GRACE_DAYS overrides the default of 30
+func AccountDeletionGrace() time.Duration {
+	return time.Duration(envInt("ACCOUNT_DELETION_GRACE_DAYS", 30, 0)) * 24 * time.Hour
+}
+
+// everything stored about a user, keyed by the file name it gets in an export archive
+func ExportUserData(user models.User) (map[string]interface{}, error) {
+	roles, err := UserRoles(user)
+	if err != nil {
+		return nil, err
+	}
+
+	var preferences []models.Preferences
+	if err := connector.DB.Where("user_id = ?", user.ID).Find(&preferences).Error; err != nil {
+		return nil, err
+	}
+
+	var bookings []models.Booking
+	if err := connector.DB.Preload("Property").Where("user_id = ?", user.ID).Find(&bookings).Error; err != nil {
+		return nil, err
+	}
+
+	var sessions []models.Session
+	if err := connector.DB.Where("user_id = ?", user.ID).Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+
+	var identities []models.Identity
+	if err := connector.DB.Where("user_id = ?", user.ID).Find(&identities).Error; err != nil {
+		return nil, err
+	}
+
+	var listings []models.Property
+	if err := connector.DB.Where("owner_id = ?", user.ID).Find(&listings).Error; err != nil {
+		return nil, err
+	}
+
+	apiKeys, err := ListAPIKeys(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var securityEvents []models.AuditEvent
+	if err := connector.DB.Where("user_id = ?", user.ID).Order("id").Find(&securityEvents).Error; err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"user":            user,
+		"roles":           roles,
+		"preferences":     preferences,
+		"bookings":        bookings,
+		"sessions":        sessions,
+		"identities":      identities,
+		"listings":        listings,
+		"api_keys":        apiKeys,
+		"security_events": securityEvents,
+	}, nil
+}
+
+// schedule the account for erasure, sessions and api keys stop working straight away
+func RequestAccountDeletion(userID uint) (time.Time, error) {
+	now := time.Now()
+	update := connector.DB.Model(&models.User{}).Where("id = ? AND deletion_requested_at IS NULL", userID).Update("deletion_requested_at", now)
+	if update.Error != nil {
+		return time.Time{}, update.Error
+	}
+
+	if _, err := RevokeUserSessions(userID); err != nil {
+		return time.Time{}, err
+	}
+	revokeKeys := connector.DB.Model(&models.APIKey{}).Where("user_id = ? AND revoked_at IS NULL", userID).Update("revoked_at", now)
+	if revokeKeys.Error != nil {
+		return time.Time{}, revokeKeys.Error
+	}
+
+	var user models.User
+	if err := connector.DB.First(&user, userID).Error; err != nil {
+		return time.Time{}, err
+	}
+	return user.DeletionRequestedAt.Add(AccountDeletionGrace()), nil
+}
+
+// returns false when no deletion was pending
+func CancelAccountDeletion(userID uint) (bool, error) {
+	update := connector.DB.Model(&models.User{}).Where("id = ? AND deletion_requested_at IS NOT NULL", userID).Update("deletion_requested_at", nil)
+	return update.RowsAffected > 0, update.Error
+}
+
+// erase a user's personal data, the user row is kept as an anonymous tombstone so bookings stay
+// linked for reporting without pointing at anyone; returns false when the account was skipped because
+// another instance is purging it or the deletion was cancelled or already done meanwhile
+func purgeAccount(userID uint, cutoff time.Time) (bool, error) {
+	purged := false
+	err := connector.DB.Transaction(func(tx *gorm.DB) error {
+		// the row lock is held until the purge commits, SKIP LOCKED lets other instances move on
+		var pending []models.User
+		lock := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("id = ? AND deletion_requested_at IS NOT NULL AND deletion_requested_at < ?", userID, cutoff).
+			Limit(1).Find(&pending)
+		if lock.Error != nil || len(pending) == 0 {
+			return lock.Error
+		}
+
+		var sessionIDs []uint
+		if err := tx.Model(&models.Session{}).Unscoped().Where("user_id = ?", userID).Pluck("id", &sessionIDs).Error; err != nil {
+			return err
+		}
+		if len(sessionIDs) > 0 {
+			if err := tx.Unscoped().Where("session_id IN ?", sessionIDs).Delete(&models.RefreshToken{}).Error; err != nil {
+				return err
+			}
+		}
+
+		owned := []interface{}{
+			&models.Session{}, &models.Preferences{}, &models.UserToken{}, &models.RecoveryCode{},
+			&models.Identity{}, &models.APIKey{}, &models.UserRole{},
+		}
+		for _, model := range owned {
+			if err := tx.Unscoped().Where("user_id = ?", userID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := RedactAuditEvents(tx, userID); err != nil {
+			return err
+		}
+
+		// listings stay for booking history but nobody is left to manage them
+		if err := tx.Model(&models.Property{}).Where("owner_id = ?", userID).Update("published", false).Error; err != nil {
+			return err
+		}
+
+		scrub := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"name":               "Deleted user",
+			"email":              fmt.Sprintf("deleted-%d@deleted.invalid", userID),
+			"password":           "",
+			"verified_at":        nil,
+			"phone":              "",
+			"avatar_url":         "",
+			"preferred_currency": "",
+			"locale":             "",
+			"pending_email":      "",
+			"totp_secret":        "",
+			"totp_enabled_at":    nil,
+			"totp_last_step":     0,
+		})
+		if scrub.Error != nil {
+			return scrub.Error
+		}
+
+		// soft delete so the tombstone can never be logged into or looked up again
+		if err := tx.Delete(&models.User{}, userID).Error; err != nil {
+			return err
+		}
+		purged = true
+		return nil
+	})
+	return purged && err == nil, err
+}
+
+// erase every account whose grace period has run out, returns how many were purged
+func PurgeDeletedAccounts() (int, error) {
+	var userIDs []uint
+	cutoff := time.Now().Add(-AccountDeletionGrace())
+	result := connector.DB.Model(&models.User{}).Where("deletion_requested_at IS NOT NULL AND deletion_requested_at < ?", cutoff).Pluck("id", &userIDs)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	purgedCount := 0
+	for _, userID := range userIDs {
+		purged, err := purgeAccount(userID, cutoff)
+		if err != nil {
+			return purgedCount, err
+		}
+		if purged {
+			purgedCount++
+			RecordAuditEvent(models.AuditEvent{EventType: AuditAccountPurged, UserID: &userID, Outcome: AuditOutcomeSuccess}, nil)
+		}
+	}
+	return purgedCount, nil
+}
+
+// purge expired deletion requests and abandoned social logins in the background, safe to call more than once
+// and to run on every instance since each account is locked while it is purged
+func StartAccountPurger() {
+	accountPurgerOnce.Do(func() {
+		go func() {
+			for {
+				purged, err := PurgeDeletedAccounts()
+				if err != nil {
+					log.Printf("Error occurred trying to purge deleted accounts:\n %v", err)
+				} else if purged > 0 {
+					log.Printf("Purged %d deleted accounts\n", purged)
+				}
+				if states, err := PurgeExpiredOAuthStates(); err != nil {
+					log.Printf("Error occurred trying to purge expired login states:\n %v", err)
+				} else if states > 0 {
+					log.Printf("Purged %d expired login states\n", states)
+				}
+				time.Sleep(accountPurgeInterval)
+			}
+		}()
+	})
+}
@@ -0,0 +1,128 @@
+package auth_utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+)
+
+const (
+	ScopePropertiesRead   = "properties:read"
+	ScopePropertiesWrite  = "properties:write"
+	ScopePreferencesWrite = "preferences:write"
+	ScopeBookingsRead     = "bookings:read"
+	ScopeBookingsWrite    = "bookings:write"
+
+	apiKeyPrefix = "spk"
+	// last_used_at is only written when the stored value is older than this
+	apiKeyTouchInterval = time.Minute
+)
+
+var validScopes = map[string]bool{
+	ScopePropertiesRead:   true,
+	ScopePropertiesWrite:  true,
+	ScopePreferencesWrite: true,
+	ScopeBookingsRead:     true,
+	ScopeBookingsWrite:    true,
+}
+
+var (
+	ErrAPIKeyInvalid = errors.New("api key is invalid, expired or revoked")
+	ErrScopeInvalid  = errors.New("unknown scope")
+)
+
+// split a comma or space separated scope list, rejecting unknown scopes
+func ParseScopes(raw string) ([]string, error) {
+	seen := map[string]bool{}
+	scopes := []string{}
+	for _, scope := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if !validScopes[scope] {
+			return nil, ErrScopeInvalid
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	sort.Strings(scopes)
+	return scopes, nil
+}
+
+// create a key for the user, the plain key is only returned here
+func CreateAPIKey(userID uint, name string, scopes []string, expiresAt *time.Time) (string, models.APIKey, error) {
+	prefixBytes := make([]byte, 4)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", models.APIKey{}, err
+	}
+	secret, err := RandomToken(32)
+	if err != nil {
+		return "", models.APIKey{}, err
+	}
+
+	prefix := apiKeyPrefix + "_" + hex.EncodeToString(prefixBytes)
+	plainKey := prefix + "_" + secret
+
+	key := models.APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   HashToken(plainKey),
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: expiresAt,
+	}
+	if err := connector.DB.Create(&key).Error; err != nil {
+		return "", models.APIKey{}, err
+	}
+
+	return plainKey, key, nil
+}
+
+// look up an active key and its owner, recording when it was last used
+func AuthenticateAPIKey(plainKey string) (models.APIKey, models.User, error) {
+	var key models.APIKey
+	result := connector.DB.Preload("User").Where("key_hash = ?", HashToken(plainKey)).First(&key)
+	if result.Error != nil {
+		return models.APIKey{}, models.User{}, ErrAPIKeyInvalid
+	}
+
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && now.After(*key.ExpiresAt)) || key.User.ID == 0 {
+		return models.APIKey{}, models.User{}, ErrAPIKeyInvalid
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > apiKeyTouchInterval {
+		connector.DB.Model(&models.APIKey{}).Where("id = ?", key.ID).Update("last_used_at", now)
+	}
+
+	return key, key.User, nil
+}
+
+func APIKeyScopes(key models.APIKey) []string {
+	return strings.Fields(key.Scopes)
+}
+
+func ListAPIKeys(userID uint) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	result := connector.DB.Where("user_id = ?", userID).Order("created_at desc").Find(&keys)
+	return keys, result.Error
+}
+
+// revoke a key, ownerID of zero lets admins revoke any key; returns false when nothing matched
+func RevokeAPIKey(keyID uint, ownerID uint) (bool, error) {
+	query := connector.DB.Model(&models.APIKey{}).Where("id = ? AND revoked_at IS NULL", keyID)
+	if ownerID != 0 {
+		query = query.Where("user_id = ?", ownerID)
+	}
+
+	result := query.Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
@@ -0,0 +1,146 @@
+package auth_utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	"gorm.io/gorm"
+)
+
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+
+	AuditRegister                 = "register"
+	AuditLogin                    = "login"
+	AuditMFALogin                 = "mfa_login"
+	AuditTokenIssued              = "token_issued"
+	AuditTokenRefresh             = "token_refresh"
+	AuditLogout                   = "logout"
+	AuditPasswordResetRequested   = "password_reset_requested"
+	AuditPasswordReset            = "password_reset"
+	AuditPasswordChange           = "password_change"
+	AuditEmailVerified            = "email_verified"
+	AuditEmailChangeRequested     = "email_change_requested"
+	AuditEmailChange              = "email_change"
+	AuditMFAEnabled               = "mfa_enabled"
+	AuditMFADisabled              = "mfa_disabled"
+	AuditRoleGranted              = "role_granted"
+	AuditRoleRevoked              = "role_revoked"
+	AuditAdminRevoke              = "admin_revoke"
+	AuditAPIKeyCreated            = "api_key_created"
+	AuditAPIKeyRevoked            = "api_key_revoked"
+	AuditAccountDeletionRequested = "account_deletion_requested"
+	AuditAccountDeletionCancelled = "account_deletion_cancelled"
+	AuditAccountPurged            = "account_purged"
+	AuditAccessDenied             = "access_denied"
+	AuditImpersonationStarted     = "impersonation_started"
+	AuditImpersonatedRequest      = "impersonated_request"
+
+	maxAuditQueryLimit = 500
+)
+
+// filters for the admin audit query, zero values are ignored
+type AuditFilter struct {
+	UserID    uint
+	ActorID   uint
+	EventType string
+	Outcome   string
+	From      time.Time
+	To        time.Time
+	BeforeID  uint
+	Limit     int
+}
+
+// addresses never go into audit details as they are, the hash still lets attempts against one address be
+// correlated; it is keyed with AUDIT_HASH_KEY, or TOKEN_SIGNING_KEY when that is unset, so it cannot be
+// matched back to an address without the key. empty when neither is configured
+func AuditEmailHash(email string) string {
+	key := os.Getenv("AUDIT_HASH_KEY")
+	if key == "" {
+		key = os.Getenv("TOKEN_SIGNING_KEY")
+	}
+	if key == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// strips every *_email_hash key from a details object and leaves other details alone
+const redactedAuditDetailsSQL = `CASE WHEN details IS NULL OR jsonb_typeof(details) <> 'object' THEN details ELSE
+	(SELECT coalesce(jsonb_object_agg(key, value), '{}'::jsonb) FROM jsonb_each(details) WHERE key <> 'email_hash' AND key NOT LIKE '%\_email\_hash') END`
+
+// blank the client details and email hashes of a user's audit events when the account is erased, the one change
+// allowed to the otherwise append-only log; the events themselves stay as the security record
+func RedactAuditEvents(tx *gorm.DB, userID uint) error {
+	return tx.Session(&gorm.Session{SkipHooks: true}).Model(&models.AuditEvent{}).
+		Where("user_id = ?", userID).
+		Updates(map[string]interface{}{"ip": "", "user_agent": "", "details": gorm.Expr(redactedAuditDetailsSQL)}).Error
+}
+
+// write an audit event, failures are logged rather than returned so auditing never breaks a request
+func RecordAuditEvent(event models.AuditEvent, details map[string]interface{}) {
+	if len(details) > 0 {
+		encoded, err := json.Marshal(details)
+		if err != nil {
+			log.Printf("Error occurred trying to encode audit details:\n %v", err)
+		} else {
+			event.Details = encoded
+		}
+	}
+	if event.UserID != nil && *event.UserID == 0 {
+		event.UserID = nil
+	}
+	if event.ActorID != nil && *event.ActorID == 0 {
+		event.ActorID = nil
+	}
+
+	if err := connector.DB.Create(&event).Error; err != nil {
+		log.Printf("Error occurred trying to record %s audit event:\n %v", event.EventType, err)
+	}
+}
+
+// newest first, page with BeforeID set to the last id of the previous page
+func QueryAuditEvents(filter AuditFilter) ([]models.AuditEvent, error) {
+	if filter.Limit <= 0 || filter.Limit > maxAuditQueryLimit {
+		filter.Limit = maxAuditQueryLimit
+	}
+
+	query := connector.DB.Model(&models.AuditEvent{})
+	if filter.UserID != 0 {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if filter.ActorID != 0 {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.EventType != "" {
+		query = query.Where("event_type = ?", filter.EventType)
+	}
+	if filter.Outcome != "" {
+		query = query.Where("outcome = ?", filter.Outcome)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	if filter.BeforeID != 0 {
+		query = query.Where("id < ?", filter.BeforeID)
+	}
+
+	var events []models.AuditEvent
+	result := query.Order("id desc").Limit(filter.Limit).Find(&events)
+	return events, result.Error
+}
@@ -0,0 +1,29 @@
+package auth_utils
+
+import "testing"
+
+func TestAuditEmailHash(t *testing.T) {
+	t.Setenv("AUDIT_HASH_KEY", "")
+	t.Setenv("TOKEN_SIGNING_KEY", "")
+	if got := AuditEmailHash("tendai@example.com"); got != "" {
+		t.Errorf("hash without a key = %q, want none", got)
+	}
+
+	t.Setenv("TOKEN_SIGNING_KEY", "signing-key")
+	signingHash := AuditEmailHash("tendai@example.com")
+	if signingHash == "" || signingHash == HashToken("tendai@example.com") {
+		t.Errorf("hash keyed with TOKEN_SIGNING_KEY = %q, want a keyed hash", signingHash)
+	}
+	if got := AuditEmailHash(" Tendai@Example.com "); got != signingHash {
+		t.Errorf("case and spacing changed the hash: %q, want %q", got, signingHash)
+	}
+
+	t.Setenv("AUDIT_HASH_KEY", "audit-key")
+	auditHash := AuditEmailHash("tendai@example.com")
+	if auditHash == "" || auditHash == signingHash {
+		t.Errorf("AUDIT_HASH_KEY was not used, got %q", auditHash)
+	}
+	if AuditEmailHash("rudo@example.com") == auditHash {
+		t.Error("different addresses hashed the same")
+	}
+}
@@ -1,96 +1,119 @@
 package auth_utils
 
 import (
-	"crypto/md5"
-	"encoding/hex"
-	"fmt"
 	"log"
-	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	TokenIssuer = "smart-prop-server"
+
+	// token_use claim values, only access tokens are accepted by JWTMiddleware
+	TokenUseAccess = "access"
+	TokenUseMFA    = "mfa"
 )
 
 // claims struct for generating jwt tokens
 type JWTClaims struct {
-	DateTime  string `json:"date_time"`
-	UserEmail string `json:"user_email"`
+	DateTime  string   `json:"date_time"`
+	UserEmail string   `json:"user_email"`
+	SessionID string   `json:"sid"`
+	Roles     []string `json:"roles"`
+	TokenUse  string   `json:"token_use"`
+	// set on impersonation tokens, names the admin acting as the user (RFC 8693 actor claim)
+	Act *ActorClaim `json:"act,omitempty"`
 
 	jwt.RegisteredClaims
 }
 
-func HashPassword(pwd string) string {
-	//err := godotenv.Load()
-	//if err != nil {
-	//	log.Println("Error loading .env file")
-	//}
-
-	hashingCost := os.Getenv("HashCost")
-	intCost, intErr := strconv.Atoi(hashingCost)
-	if intErr != nil {
-		log.Println("Failed to convert string to int")
-	}
+type ActorClaim struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email"`
+}
 
-	pwdHash, hashErr := bcrypt.GenerateFromPassword([]byte(pwd), intCost)
-	if hashErr != nil {
-		fmt.Printf("Error trying to hash password: %s\n", hashErr)
+// the account a token was issued to, the email claim is informational only since the address can change
+func (c *JWTClaims) UserID() uint {
+	id, err := strconv.ParseUint(c.Subject, 10, 64)
+	if err != nil {
+		return 0
 	}
-	return string(pwdHash)
+	return uint(id)
 }
 
-// compare user password and stored hash
-func ComparePasswordAndHash(hashedPwd string, plainPwd string) bool {
-	byteHash := []byte(hashedPwd)
-	bytePwd := []byte(plainPwd)
+// how long a dummy hash is kept before the stored schemes are looked at again
+const dummyHashTTL = time.Hour
 
-	err := bcrypt.CompareHashAndPassword(byteHash, bytePwd)
-	if err != nil {
-		log.Println(err)
-		return false
-	}
+var (
+	dummyHash     string
+	dummyHashAt   time.Time
+	dummyHashLock sync.Mutex
+)
 
-	return true
+// compare against a throwaway hash so a missing account costs as much as a wrong password, the throwaway
+// uses the slowest scheme still stored so unknown accounts cannot be told apart from legacy bcrypt ones
+func DummyPasswordCompare(plainPwd string) error {
+	_, err := ComparePasswordAndHash(currentDummyHash(), plainPwd)
+	return err
 }
 
-// hash strings for custom token
-func HashString(input string) string {
-	//err := godotenv.Load()
-	//if err != nil {
-	//	log.Println("Error loading .env file")
-	//}
-	salt := os.Getenv("HASH_SALT")
-	byteInput := []byte(input + salt)
+func currentDummyHash() string {
+	dummyHashLock.Lock()
+	defer dummyHashLock.Unlock()
 
-	md5hash := md5.Sum(byteInput)
+	if dummyHash != "" && time.Since(dummyHashAt) < dummyHashTTL {
+		return dummyHash
+	}
 
-	return hex.EncodeToString(md5hash[:])
+	hash, err := slowestDummyHash()
+	if err != nil {
+		log.Printf("Error trying to pick dummy password hash: %v\n", err)
+		if dummyHash != "" {
+			return dummyHash
+		}
+		if hash, err = HashPassword(dummyPassword); err != nil {
+			log.Printf("Error trying to hash dummy password: %v\n", err)
+		}
+	}
+	dummyHash, dummyHashAt = hash, time.Now()
+	return dummyHash
 }
 
 // generate jwt token func
-func GenerateJWTToken(dateTime string, userEmail string) (string, error) {
+func GenerateJWTToken(dateTime string, userID uint, userEmail string, sessionID string, roles []string) (string, error) {
 	//err := godotenv.Load()
 	//if err != nil {
 	//	fmt.Printf("Error loading .env file")
 	//	return "", err
 	//}
 
-	jwtKey := []byte(os.Getenv("JWT_KEY"))
-	expirationTime := time.Now().Add(24 * time.Hour)
+	tokenID, idErr := randomID()
+	if idErr != nil {
+		return "", idErr
+	}
+
+	now := time.Now()
+	expirationTime := now.Add(AccessTokenTTL)
 
 	claims := &JWTClaims{
 		DateTime:  dateTime,
 		UserEmail: userEmail,
+		SessionID: sessionID,
+		Roles:     roles,
+		TokenUse:  TokenUseAccess,
 		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatUint(uint64(userID), 10),
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
-			Issuer:    "smart-prop-server",
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        tokenID,
+			Issuer:    TokenIssuer,
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	tokenString, err := token.SignedString(jwtKey)
+	tokenString, err := SignToken(claims)
 
 	if err != nil {
 		return "", err
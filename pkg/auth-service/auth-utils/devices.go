@@ -0,0 +1,119 @@
+package auth_utils
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+)
+
+// how often a session's last seen time and ip are written back while it is in use
+const sessionTouchInterval = 5 * time.Minute
+
+// where a request came from, recorded on the session it creates or uses
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+var sessionTouches = struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}{last: make(map[string]time.Time)}
+
+// order matters, edge and opera user agents also claim to be chrome and chrome claims to be safari
+var browserMarkers = []struct{ marker, name string }{
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"Firefox/", "Firefox"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+	{"okhttp", "Android app"},
+	{"Dart/", "Mobile app"},
+	{"curl/", "curl"},
+	{"python-requests", "Python script"},
+}
+
+var platformMarkers = []struct{ marker, name string }{
+	{"iPhone", "iPhone"},
+	{"iPad", "iPad"},
+	{"Android", "Android"},
+	{"Windows", "Windows"},
+	{"Mac OS X", "macOS"},
+	{"Linux", "Linux"},
+}
+
+// a short human readable label for a user agent such as "Chrome on Windows"
+func DeviceLabel(userAgent string) string {
+	browser, platform := "", ""
+	for _, candidate := range browserMarkers {
+		if strings.Contains(userAgent, candidate.marker) {
+			browser = candidate.name
+			break
+		}
+	}
+	for _, candidate := range platformMarkers {
+		if strings.Contains(userAgent, candidate.marker) {
+			platform = candidate.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	default:
+		return "Unknown device"
+	}
+}
+
+// note that a session is in use, the db is only written once per sessionTouchInterval per session
+func TouchSession(sessionID string, ip string) {
+	if sessionID == "" {
+		return
+	}
+
+	now := time.Now()
+	sessionTouches.mu.Lock()
+	if now.Sub(sessionTouches.last[sessionID]) < sessionTouchInterval {
+		sessionTouches.mu.Unlock()
+		return
+	}
+	sessionTouches.last[sessionID] = now
+	for id, seen := range sessionTouches.last {
+		if now.Sub(seen) > sessionTouchInterval {
+			delete(sessionTouches.last, id)
+		}
+	}
+	sessionTouches.mu.Unlock()
+
+	update := connector.DB.Model(&models.Session{}).Where("session_id = ?", sessionID).Updates(map[string]interface{}{"last_seen_at": now, "ip": ip})
+	if update.Error != nil {
+		log.Printf("Error occurred trying to update session last seen:\n %v", update.Error)
+	}
+}
+
+// sessions that can still be used, most recently active first; support impersonations are not the user's devices
+func ActiveSessions(userID uint) ([]models.Session, error) {
+	var sessions []models.Session
+	result := connector.DB.Where("user_id = ? AND actor_id = 0 AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at desc nulls last").Find(&sessions)
+	return sessions, result.Error
+}
+
+// revoke one of the user's own sessions, returns false when it does not exist or belongs to someone else
+func RevokeOwnSession(userID uint, sessionID string) (bool, error) {
+	var session models.Session
+	result := connector.DB.Where("session_id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).Limit(1).Find(&session)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	return true, RevokeSession(sessionID)
+}
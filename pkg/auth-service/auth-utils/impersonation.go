@@ -0,0 +1,85 @@
+package auth_utils
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// impersonation tokens are short lived and come without a refresh token, their session row is only there so
+// they are revoked along with the target's or the admin's sessions
+const ImpersonationTTL = 15 * time.Minute
+
+var ErrImpersonationNotAllowed = errors.New("admin accounts cannot be impersonated")
+
+// issue an access token for the target user carrying an act claim that names the admin
+func GenerateImpersonationToken(admin models.User, target models.User, client ClientInfo) (string, error) {
+	roles, err := UserRoles(target)
+	if err != nil {
+		return "", err
+	}
+	// acting as another admin would let support staff borrow their privileges
+	if HasAnyRole(roles, RoleAdmin) {
+		return "", ErrImpersonationNotAllowed
+	}
+
+	tokenID, err := randomID()
+	if err != nil {
+		return "", err
+	}
+	sessionID, err := randomID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	session := models.Session{
+		SessionID:  sessionID,
+		UserID:     target.ID,
+		ActorID:    admin.ID,
+		ExpiresAt:  now.Add(ImpersonationTTL),
+		UserAgent:  client.UserAgent,
+		Device:     DeviceLabel(client.UserAgent),
+		IP:         client.IP,
+		LastSeenAt: &now,
+	}
+	if err := connector.DB.Create(&session).Error; err != nil {
+		return "", err
+	}
+
+	claims := &JWTClaims{
+		DateTime:  now.Format("20060102150405"),
+		UserEmail: target.EMAIL,
+		SessionID: sessionID,
+		Roles:     roles,
+		TokenUse:  TokenUseAccess,
+		Act: &ActorClaim{
+			Subject: strconv.FormatUint(uint64(admin.ID), 10),
+			Email:   admin.EMAIL,
+		},
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatUint(uint64(target.ID), 10),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ImpersonationTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        tokenID,
+			Issuer:    TokenIssuer,
+		},
+	}
+	return SignToken(claims)
+}
+
+// the admin behind an impersonation token, zero when the claims are not an impersonation
+func (c *JWTClaims) ActorID() uint {
+	if c.Act == nil {
+		return 0
+	}
+	id, err := strconv.ParseUint(c.Act.Subject, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return uint(id)
+}
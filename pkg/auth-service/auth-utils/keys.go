@@ -0,0 +1,214 @@
+package auth_utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// a key from JWT_KEY_DIR, retired keys only carry the public half and are kept for verification
+type jwtKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+// signing key plus every key tokens may still be verified with
+type keyRing struct {
+	signing *jwtKey
+	verify  map[string]*jwtKey
+	// legacy shared secret used when no key directory is configured
+	hmacSecret []byte
+}
+
+var (
+	keys     *keyRing
+	keysErr  error
+	keysOnce sync.Once
+)
+
+// load the key ring once, JWT_KEY_DIR holds one <kid>.pem per key and JWT_SIGNING_KID
+// picks the active one (defaults to the last private key by name)
+func loadKeys() (*keyRing, error) {
+	keysOnce.Do(func() {
+		dir := os.Getenv("JWT_KEY_DIR")
+		if dir == "" {
+			secret := os.Getenv("JWT_KEY")
+			if secret == "" {
+				keysErr = errors.New("neither JWT_KEY_DIR nor JWT_KEY is configured")
+				return
+			}
+			keys = &keyRing{verify: map[string]*jwtKey{}, hmacSecret: []byte(secret)}
+			return
+		}
+
+		keys, keysErr = loadKeyDir(dir, os.Getenv("JWT_SIGNING_KID"))
+	})
+	return keys, keysErr
+}
+
+func loadKeyDir(dir string, signingKid string) (*keyRing, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	ring := &keyRing{verify: map[string]*jwtKey{}}
+	for _, file := range files {
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+		key, err := parseKeyFile(file, kid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key %s: %w", kid, err)
+		}
+		ring.verify[kid] = key
+
+		if key.Private != nil && (signingKid == "" || signingKid == kid) {
+			ring.signing = key
+		}
+	}
+
+	if ring.signing == nil {
+		return nil, fmt.Errorf("no private signing key found in %s", dir)
+	}
+	return ring, nil
+}
+
+func parseKeyFile(file string, kid string) (*jwtKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &jwtKey{ID: kid}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.Private, key.Public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.Public = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.Private, key.Public = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.Public = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	return key, nil
+}
+
+// sign claims with the active key, the kid header tells verifiers which key to use
+func SignToken(claims jwt.Claims) (string, error) {
+	ring, err := loadKeys()
+	if err != nil {
+		return "", err
+	}
+
+	if ring.signing == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(ring.hmacSecret)
+	}
+
+	token := jwt.NewWithClaims(ring.signing.Method, claims)
+	token.Header["kid"] = ring.signing.ID
+	return token.SignedString(ring.signing.Private)
+}
+
+// parse and verify a token against any key in the ring
+func ParseToken(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	ring, err := loadKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if ring.signing == nil {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return ring.hmacSecret, nil
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		key, ok := ring.verify[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.Public, nil
+	}, jwt.WithIssuer(TokenIssuer))
+}
+
+// public keys in JWK Set format so other services can verify our tokens
+func JWKS() (map[string]interface{}, error) {
+	ring, err := loadKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	kids := make([]string, 0, len(ring.verify))
+	for kid := range ring.verify {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	jwks := make([]map[string]interface{}, 0, len(kids))
+	for _, kid := range kids {
+		key := ring.verify[kid]
+		jwk := map[string]interface{}{
+			"kid": key.ID,
+			"use": "sig",
+			"alg": key.Method.Alg(),
+		}
+
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["n"] = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk["kty"] = "OKP"
+			jwk["crv"] = "Ed25519"
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(pub)
+		}
+
+		jwks = append(jwks, jwk)
+	}
+
+	return map[string]interface{}{"keys": jwks}, nil
+}
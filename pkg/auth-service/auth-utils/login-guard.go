@@ -0,0 +1,127 @@
+package auth_utils
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// failures allowed before each further attempt has to wait
+	loginFreeAttempts = 3
+	maxLoginDelay     = time.Minute
+
+	accountLockoutThreshold = 10
+	// ips are shared behind NATs so they get more room before a lockout
+	ipLockoutThreshold = 50
+	loginLockoutPeriod = 15 * time.Minute
+
+	// failures older than this are forgotten
+	loginAttemptWindow = time.Hour
+)
+
+type loginAttempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// in-memory failed login tracking keyed by account and by client ip; counters are per instance and reset on
+// restart, so behind a load balancer with n instances a caller can get up to n times the attempts before a
+// lockout. client ips come from gin's ClientIP, see TRUSTED_PROXIES in main
+type loginGuard struct {
+	mu       sync.Mutex
+	attempts map[string]*loginAttempts
+}
+
+var logins = &loginGuard{attempts: make(map[string]*loginAttempts)}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// how long the caller has to wait before this key may try again
+func (g *loginGuard) wait(key string, now time.Time) time.Duration {
+	entry, ok := g.attempts[key]
+	if !ok {
+		return 0
+	}
+
+	if now.Before(entry.lockedUntil) {
+		return entry.lockedUntil.Sub(now)
+	}
+
+	if entry.failures < loginFreeAttempts {
+		return 0
+	}
+
+	// 1s, 2s, 4s ... after the free attempts, capped at maxLoginDelay
+	delay := time.Second << uint(entry.failures-loginFreeAttempts)
+	if delay > maxLoginDelay || delay <= 0 {
+		delay = maxLoginDelay
+	}
+	if remaining := entry.lastFailure.Add(delay).Sub(now); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+func (g *loginGuard) fail(key string, threshold int, now time.Time) {
+	entry, ok := g.attempts[key]
+	if !ok {
+		entry = &loginAttempts{}
+		g.attempts[key] = entry
+	}
+
+	entry.failures++
+	entry.lastFailure = now
+	if entry.failures >= threshold {
+		entry.lockedUntil = now.Add(loginLockoutPeriod)
+		entry.failures = 0
+	}
+}
+
+func (g *loginGuard) prune(now time.Time) {
+	for key, entry := range g.attempts {
+		if now.Sub(entry.lastFailure) > loginAttemptWindow && now.After(entry.lockedUntil) {
+			delete(g.attempts, key)
+		}
+	}
+}
+
+// returns how long to wait before a login for this email and ip may be attempted, zero when allowed
+func LoginRetryAfter(email string, ip string) time.Duration {
+	logins.mu.Lock()
+	defer logins.mu.Unlock()
+
+	now := time.Now()
+	logins.prune(now)
+
+	accountWait := logins.wait(accountKey(email), now)
+	if ipWait := logins.wait(ipKey(ip), now); ipWait > accountWait {
+		return ipWait
+	}
+	return accountWait
+}
+
+func RecordLoginFailure(email string, ip string) {
+	logins.mu.Lock()
+	defer logins.mu.Unlock()
+
+	now := time.Now()
+	logins.fail(accountKey(email), accountLockoutThreshold, now)
+	logins.fail(ipKey(ip), ipLockoutThreshold, now)
+}
+
+// a successful login clears the account counter, the ip counter is left alone so one
+// valid account cannot be used to reset guessing against others
+func RecordLoginSuccess(email string) {
+	logins.mu.Lock()
+	defer logins.mu.Unlock()
+
+	delete(logins.attempts, accountKey(email))
+}
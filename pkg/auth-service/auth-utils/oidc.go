@@ -0,0 +1,464 @@
+package auth_utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	OAuthStateTTL = 10 * time.Minute
+	// cookie tying a pending social login to the browser that started it
+	OIDCBindingCookie = "smart_prop_oidc"
+
+	oidcDiscoveryTTL = time.Hour
+	// unknown kids trigger a jwks refetch at most this often
+	oidcJWKSRefetchInterval = time.Minute
+)
+
+var (
+	ErrOIDCProviderUnknown = errors.New("unknown identity provider")
+	ErrOIDCStateInvalid    = errors.New("login request is invalid or has expired")
+	ErrOIDCTokenInvalid    = errors.New("identity provider returned an invalid id token")
+)
+
+// an openid connect provider configured through OIDC_<NAME>_* env vars
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	client *http.Client
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	discoveredAt  time.Time
+	jwks          map[string]interface{}
+	jwksFetchedAt time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// claims we read from a provider id token
+type OIDCClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Nonce         string `json:"nonce"`
+
+	jwt.RegisteredClaims
+}
+
+var (
+	oidcProviders     map[string]*OIDCProvider
+	oidcProvidersOnce sync.Once
+)
+
+// providers listed in OIDC_PROVIDERS, e.g. "google,microsoft" reads OIDC_GOOGLE_ISSUER, OIDC_GOOGLE_CLIENT_ID,
+// OIDC_GOOGLE_CLIENT_SECRET, OIDC_GOOGLE_REDIRECT_URL and optionally OIDC_GOOGLE_SCOPES
+func GetOIDCProvider(name string) (*OIDCProvider, error) {
+	oidcProvidersOnce.Do(func() {
+		oidcProviders = make(map[string]*OIDCProvider)
+		for _, configured := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+			configured = strings.ToLower(strings.TrimSpace(configured))
+			if configured == "" {
+				continue
+			}
+
+			prefix := "OIDC_" + strings.ToUpper(configured) + "_"
+			scopes := strings.Fields(os.Getenv(prefix + "SCOPES"))
+			if len(scopes) == 0 {
+				scopes = []string{"openid", "email", "profile"}
+			}
+
+			oidcProviders[configured] = &OIDCProvider{
+				Name:         configured,
+				Issuer:       strings.TrimRight(os.Getenv(prefix+"ISSUER"), "/"),
+				ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+				ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+				RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+				Scopes:       scopes,
+				client:       &http.Client{Timeout: 10 * time.Second},
+			}
+		}
+	})
+
+	provider, ok := oidcProviders[strings.ToLower(name)]
+	if !ok || provider.Issuer == "" || provider.ClientID == "" || provider.RedirectURL == "" {
+		return nil, ErrOIDCProviderUnknown
+	}
+	return provider, nil
+}
+
+func (p *OIDCProvider) getJSON(endpoint string, target interface{}) error {
+	resp, err := p.client.Get(endpoint)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, endpoint)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(target)
+}
+
+// the provider's discovery document, cached for oidcDiscoveryTTL
+func (p *OIDCProvider) discover() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil && time.Since(p.discoveredAt) < oidcDiscoveryTTL {
+		return p.discovery, nil
+	}
+
+	var doc oidcDiscovery
+	if err := p.getJSON(p.Issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(doc.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("discovery issuer %q does not match configured issuer %q", doc.Issuer, p.Issuer)
+	}
+
+	p.discovery = &doc
+	p.discoveredAt = time.Now()
+	return p.discovery, nil
+}
+
+func decodeJWKNumber(value string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
+
+// public key for a kid, refetching the provider jwks when the kid is new (the provider rotated keys)
+func (p *OIDCProvider) verificationKey(kid string) (interface{}, error) {
+	doc, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.jwks[kid]; ok {
+		return key, nil
+	}
+	if p.jwks != nil && time.Since(p.jwksFetchedAt) < oidcJWKSRefetchInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(doc.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, jwk := range set.Keys {
+		switch jwk.Kty {
+		case "RSA":
+			n, nErr := decodeJWKNumber(jwk.N)
+			e, eErr := decodeJWKNumber(jwk.E)
+			if nErr != nil || eErr != nil {
+				continue
+			}
+			keys[jwk.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			if jwk.Crv != "P-256" {
+				continue
+			}
+			x, xErr := decodeJWKNumber(jwk.X)
+			y, yErr := decodeJWKNumber(jwk.Y)
+			if xErr != nil || yErr != nil {
+				continue
+			}
+			keys[jwk.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		}
+	}
+
+	p.jwks = keys
+	p.jwksFetchedAt = time.Now()
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// build the authorization url for a new login, state, nonce and the pkce verifier are kept server side;
+// the returned binding has to be set as OIDCBindingCookie on the browser starting the login and the
+// callback is refused without it, so a state cannot be replayed in someone else's browser
+func (p *OIDCProvider) AuthorizationURL() (string, string, error) {
+	doc, err := p.discover()
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := RandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := RandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := RandomToken(48)
+	if err != nil {
+		return "", "", err
+	}
+	binding, err := RandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	record := models.OAuthState{
+		StateHash:    HashToken(state),
+		BindingHash:  HashToken(binding),
+		Provider:     p.Name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(OAuthStateTTL),
+	}
+	if err := connector.DB.Create(&record).Error; err != nil {
+		return "", "", err
+	}
+
+	return p.authorizationURL(doc, state, nonce, verifier), binding, nil
+}
+
+func (p *OIDCProvider) authorizationURL(doc *oidcDiscovery, state string, nonce string, verifier string) string {
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.ClientID)
+	query.Set("redirect_uri", p.RedirectURL)
+	query.Set("scope", strings.Join(p.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(doc.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return doc.AuthorizationEndpoint + separator + query.Encode()
+}
+
+// load and delete the pending request for a state, each state can only be redeemed once and only by the
+// browser holding its binding cookie
+func consumeOAuthState(provider string, state string, binding string) (models.OAuthState, error) {
+	var record models.OAuthState
+	result := connector.DB.Where("state_hash = ? AND provider = ?", HashToken(state), provider).First(&record)
+	if result.Error != nil {
+		return models.OAuthState{}, ErrOIDCStateInvalid
+	}
+
+	deleted := connector.DB.Unscoped().Where("id = ?", record.ID).Delete(&models.OAuthState{})
+	if deleted.Error != nil {
+		return models.OAuthState{}, deleted.Error
+	}
+	if deleted.RowsAffected != 1 || time.Now().After(record.ExpiresAt) {
+		return models.OAuthState{}, ErrOIDCStateInvalid
+	}
+	if !boundTo(record, binding) {
+		return models.OAuthState{}, ErrOIDCStateInvalid
+	}
+	return record, nil
+}
+
+// delete pending requests that can no longer be redeemed, logins that were abandoned are never consumed
+func PurgeExpiredOAuthStates() (int64, error) {
+	result := connector.DB.Unscoped().Where("expires_at < ?", time.Now()).Delete(&models.OAuthState{})
+	return result.RowsAffected, result.Error
+}
+
+// true when the binding cookie is the one issued with the pending request
+func boundTo(record models.OAuthState, binding string) bool {
+	return binding != "" && record.BindingHash != "" &&
+		subtle.ConstantTimeCompare([]byte(record.BindingHash), []byte(HashToken(binding))) == 1
+}
+
+// redeem the authorization code and return the verified id token claims, binding is the value of the
+// browser's OIDCBindingCookie
+func (p *OIDCProvider) Exchange(code string, state string, binding string) (*OIDCClaims, error) {
+	pending, err := consumeOAuthState(p.Name, state, binding)
+	if err != nil {
+		return nil, err
+	}
+	return p.redeem(code, pending)
+}
+
+// trade the code for tokens using the pending request's pkce verifier and check the id token against its nonce
+func (p *OIDCProvider) redeem(code string, pending models.OAuthState) (*OIDCClaims, error) {
+	doc, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientID)
+	form.Set("code_verifier", pending.CodeVerifier)
+	if p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+
+	resp, err := p.client.PostForm(doc.TokenEndpoint, form)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute token request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tokenResp struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokenResp); err != nil {
+		return nil, fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || tokenResp.IDToken == "" {
+		return nil, fmt.Errorf("token request failed: %s %s", tokenResp.Error, tokenResp.ErrorDescription)
+	}
+
+	claims := &OIDCClaims{}
+	token, err := jwt.ParseWithClaims(tokenResp.IDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.verificationKey(kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "ES256"}),
+		jwt.WithIssuer(doc.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCTokenInvalid, err)
+	}
+
+	if claims.Nonce != pending.Nonce || claims.Subject == "" {
+		return nil, ErrOIDCTokenInvalid
+	}
+
+	return claims, nil
+}
+
+// find or create the user behind an external identity, existing accounts are only linked by email
+// when the provider vouches for the address
+func LinkOIDCIdentity(provider string, claims *OIDCClaims) (models.User, error) {
+	var identity models.Identity
+	result := connector.DB.Preload("User").Where("provider = ? AND subject = ?", provider, claims.Subject).First(&identity)
+	if result.Error == nil {
+		return identity.User, nil
+	}
+
+	if !claims.EmailVerified || claims.Email == "" {
+		return models.User{}, errors.New("identity provider did not return a verified email address")
+	}
+
+	var user models.User
+	if connector.DB.Where("email = ?", claims.Email).First(&user).Error != nil {
+		now := time.Now()
+		user = models.User{
+			NAME:       claims.Name,
+			EMAIL:      claims.Email,
+			VerifiedAt: &now,
+		}
+		if err := connector.DB.Create(&user).Error; err != nil {
+			return models.User{}, err
+		}
+		if err := GrantRole(user.ID, RoleTenant); err != nil {
+			return models.User{}, err
+		}
+	} else if user.VerifiedAt == nil {
+		// the provider just proved ownership of the address, whoever registered it first never did
+		if err := claimUnverifiedAccount(&user); err != nil {
+			return models.User{}, err
+		}
+	}
+
+	identity = models.Identity{
+		UserID:   user.ID,
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+	if err := connector.DB.Create(&identity).Error; err != nil {
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// hand an unverified account to the verified owner of its address, anything the registrant set up to get
+// back in is dropped: the password, second factor, pending email change, sessions and api keys
+func claimUnverifiedAccount(user *models.User) error {
+	now := time.Now()
+	err := connector.DB.Model(user).Select("verified_at", "password", "totp_secret", "totp_enabled_at", "totp_last_step", "pending_email").
+		Updates(map[string]interface{}{
+			"verified_at":     now,
+			"password":        "",
+			"totp_secret":     "",
+			"totp_enabled_at": nil,
+			"totp_last_step":  0,
+			"pending_email":   "",
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	if _, err := RevokeUserSessions(user.ID); err != nil {
+		return err
+	}
+	revokeKeys := connector.DB.Model(&models.APIKey{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).Update("revoked_at", now)
+	if revokeKeys.Error != nil {
+		return revokeKeys.Error
+	}
+
+	user.VerifiedAt = &now
+	user.PASSWORD = ""
+	user.TOTPSecret, user.TOTPEnabledAt, user.TOTPLastStep = "", nil, 0
+	user.PendingEmail = ""
+	return nil
+}
@@ -0,0 +1,442 @@
+package auth_utils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	fakeClientID    = "smart-prop-test"
+	fakeRedirectURL = "https://app.example.test/oidc/fake/callback"
+)
+
+// what the fake provider remembers about an authorization request until its code is redeemed
+type fakeAuthorization struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+}
+
+// a minimal openid connect provider: discovery, jwks, an authorize endpoint that approves every request
+// and a token endpoint that enforces pkce and single use codes
+type fakeOIDCProvider struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu        sync.Mutex
+	key       *rsa.PrivateKey
+	kid       string
+	codes     map[string]fakeAuthorization
+	jwksFetch int
+	// issuer put in the discovery document, the server url when empty
+	advertisedIssuer string
+	// lets a test change the id token claims or signing before it is issued
+	tamper func(claims jwt.MapClaims) (jwt.SigningMethod, interface{})
+}
+
+func newFakeOIDCProvider(t *testing.T) *fakeOIDCProvider {
+	t.Helper()
+
+	fake := &fakeOIDCProvider{t: t, codes: map[string]fakeAuthorization{}}
+	fake.rotateKey("key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", fake.discovery)
+	mux.HandleFunc("/jwks", fake.jwks)
+	mux.HandleFunc("/authorize", fake.authorize)
+	mux.HandleFunc("/token", fake.token)
+	fake.server = httptest.NewServer(mux)
+	t.Cleanup(fake.server.Close)
+	return fake
+}
+
+func (f *fakeOIDCProvider) rotateKey(kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		f.t.Fatalf("generating provider key: %v", err)
+	}
+	f.mu.Lock()
+	f.key, f.kid = key, kid
+	f.mu.Unlock()
+}
+
+// a provider configured the way GetOIDCProvider would configure it, talking to the fake
+func (f *fakeOIDCProvider) provider() *OIDCProvider {
+	return &OIDCProvider{
+		Name:        "fake",
+		Issuer:      f.server.URL,
+		ClientID:    fakeClientID,
+		RedirectURL: fakeRedirectURL,
+		Scopes:      []string{"openid", "email", "profile"},
+		client:      f.server.Client(),
+	}
+}
+
+func (f *fakeOIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	issuer := f.advertisedIssuer
+	if issuer == "" {
+		issuer = f.server.URL
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 issuer,
+		"authorization_endpoint": f.server.URL + "/authorize",
+		"token_endpoint":         f.server.URL + "/token",
+		"jwks_uri":               f.server.URL + "/jwks",
+	})
+}
+
+func (f *fakeOIDCProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.jwksFetch++
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": f.kid,
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(f.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(f.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (f *fakeOIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "unsupported authorization request", http.StatusBadRequest)
+		return
+	}
+
+	// handlers run off the test goroutine so they report with Errorf rather than Fatalf
+	code, err := RandomToken(16)
+	if err != nil {
+		f.t.Errorf("generating code: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f.mu.Lock()
+	f.codes[code] = fakeAuthorization{
+		clientID:    query.Get("client_id"),
+		redirectURI: query.Get("redirect_uri"),
+		challenge:   query.Get("code_challenge"),
+		nonce:       query.Get("nonce"),
+	}
+	f.mu.Unlock()
+
+	callback := url.Values{}
+	callback.Set("code", code)
+	callback.Set("state", query.Get("state"))
+	http.Redirect(w, r, query.Get("redirect_uri")+"?"+callback.Encode(), http.StatusFound)
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+func (f *fakeOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type")
+		return
+	}
+
+	f.mu.Lock()
+	auth, ok := f.codes[r.PostForm.Get("code")]
+	delete(f.codes, r.PostForm.Get("code"))
+	key, kid := f.key, f.kid
+	f.mu.Unlock()
+
+	if !ok || auth.clientID != r.PostForm.Get("client_id") || auth.redirectURI != r.PostForm.Get("redirect_uri") {
+		tokenError(w, "invalid_grant")
+		return
+	}
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(challenge[:]) != auth.challenge {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            f.server.URL,
+		"aud":            auth.clientID,
+		"sub":            "fake-user-1",
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          auth.nonce,
+		"email":          "tenant@example.test",
+		"email_verified": true,
+		"name":           "Test Tenant",
+	}
+
+	var method jwt.SigningMethod = jwt.SigningMethodRS256
+	var signingKey interface{} = key
+	if f.tamper != nil {
+		if tamperedMethod, tamperedKey := f.tamper(claims); tamperedMethod != nil {
+			method, signingKey = tamperedMethod, tamperedKey
+		}
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	idToken, err := token.SignedString(signingKey)
+	if err != nil {
+		f.t.Errorf("signing id token: %v", err)
+		tokenError(w, "server_error")
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "fake-access-token", "token_type": "Bearer", "id_token": idToken})
+}
+
+// run the browser leg of a login against the fake and return the code it hands back
+func authorizeAtFake(t *testing.T, fake *fakeOIDCProvider, provider *OIDCProvider, state string, nonce string, verifier string) string {
+	t.Helper()
+
+	doc, err := provider.discover()
+	if err != nil {
+		t.Fatalf("discovery failed: %v", err)
+	}
+
+	client := fake.server.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := client.Get(provider.authorizationURL(doc, state, nonce, verifier))
+	if err != nil {
+		t.Fatalf("authorize request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize returned %d, want a redirect", resp.StatusCode)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("bad redirect: %v", err)
+	}
+	if got := location.Scheme + "://" + location.Host + location.Path; got != fakeRedirectURL {
+		t.Fatalf("redirected to %q, want %q", got, fakeRedirectURL)
+	}
+	if location.Query().Get("state") != state {
+		t.Fatalf("state was not echoed back")
+	}
+	return location.Query().Get("code")
+}
+
+func pendingLogin(nonce string, verifier string) models.OAuthState {
+	return models.OAuthState{Provider: "fake", Nonce: nonce, CodeVerifier: verifier, ExpiresAt: time.Now().Add(OAuthStateTTL)}
+}
+
+func TestOIDCAuthorizationURL(t *testing.T) {
+	fake := newFakeOIDCProvider(t)
+	provider := fake.provider()
+	doc, err := provider.discover()
+	if err != nil {
+		t.Fatalf("discovery failed: %v", err)
+	}
+
+	authURL, err := url.Parse(provider.authorizationURL(doc, "the-state", "the-nonce", "the-verifier"))
+	if err != nil {
+		t.Fatalf("bad authorization url: %v", err)
+	}
+	query := authURL.Query()
+	challenge := sha256.Sum256([]byte("the-verifier"))
+
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             fakeClientID,
+		"redirect_uri":          fakeRedirectURL,
+		"scope":                 "openid email profile",
+		"state":                 "the-state",
+		"nonce":                 "the-nonce",
+		"code_challenge":        base64.RawURLEncoding.EncodeToString(challenge[:]),
+		"code_challenge_method": "S256",
+	}
+	for name, value := range want {
+		if got := query.Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+	if query.Get("code_verifier") != "" {
+		t.Errorf("the pkce verifier must never leave the server")
+	}
+}
+
+func TestOAuthStateBinding(t *testing.T) {
+	record := models.OAuthState{BindingHash: HashToken("browser-a")}
+
+	tests := []struct {
+		name    string
+		record  models.OAuthState
+		binding string
+		want    bool
+	}{
+		{"same browser", record, "browser-a", true},
+		{"another browser", record, "browser-b", false},
+		{"no cookie", record, "", false},
+		{"state from before binding existed", models.OAuthState{}, "", false},
+	}
+	for _, tt := range tests {
+		if got := boundTo(tt.record, tt.binding); got != tt.want {
+			t.Errorf("%s: boundTo = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	fake := newFakeOIDCProvider(t)
+	fake.advertisedIssuer = "https://evil.example.test"
+
+	if _, err := fake.provider().discover(); err == nil {
+		t.Fatalf("discovery accepted a document for a different issuer")
+	}
+}
+
+func TestOIDCCodeExchange(t *testing.T) {
+	fake := newFakeOIDCProvider(t)
+	provider := fake.provider()
+
+	code := authorizeAtFake(t, fake, provider, "state-1", "nonce-1", "verifier-1")
+	claims, err := provider.redeem(code, pendingLogin("nonce-1", "verifier-1"))
+	if err != nil {
+		t.Fatalf("exchange failed: %v", err)
+	}
+	if claims.Subject != "fake-user-1" || claims.Email != "tenant@example.test" || !claims.EmailVerified || claims.Name != "Test Tenant" {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+
+	// codes are single use at the provider
+	if _, err := provider.redeem(code, pendingLogin("nonce-1", "verifier-1")); err == nil {
+		t.Fatalf("a redeemed code was accepted twice")
+	}
+}
+
+func TestOIDCPKCEVerifierMismatch(t *testing.T) {
+	fake := newFakeOIDCProvider(t)
+	provider := fake.provider()
+
+	code := authorizeAtFake(t, fake, provider, "state-1", "nonce-1", "verifier-1")
+	_, err := provider.redeem(code, pendingLogin("nonce-1", "someone-elses-verifier"))
+	if err == nil {
+		t.Fatalf("token endpoint accepted the wrong pkce verifier")
+	}
+	if errors.Is(err, ErrOIDCTokenInvalid) {
+		t.Fatalf("pkce failure should be the provider refusing the code, got %v", err)
+	}
+}
+
+func TestOIDCNonceMismatch(t *testing.T) {
+	fake := newFakeOIDCProvider(t)
+	provider := fake.provider()
+
+	code := authorizeAtFake(t, fake, provider, "state-1", "nonce-from-another-login", "verifier-1")
+	if _, err := provider.redeem(code, pendingLogin("nonce-1", "verifier-1")); !errors.Is(err, ErrOIDCTokenInvalid) {
+		t.Fatalf("nonce mismatch gave %v, want ErrOIDCTokenInvalid", err)
+	}
+}
+
+func TestOIDCIDTokenVerification(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(fake *fakeOIDCProvider, claims jwt.MapClaims) (jwt.SigningMethod, interface{})
+	}{
+		{"wrong audience", func(fake *fakeOIDCProvider, claims jwt.MapClaims) (jwt.SigningMethod, interface{}) {
+			claims["aud"] = "another-client"
+			return nil, nil
+		}},
+		{"wrong issuer", func(fake *fakeOIDCProvider, claims jwt.MapClaims) (jwt.SigningMethod, interface{}) {
+			claims["iss"] = "https://evil.example.test"
+			return nil, nil
+		}},
+		{"expired", func(fake *fakeOIDCProvider, claims jwt.MapClaims) (jwt.SigningMethod, interface{}) {
+			claims["exp"] = time.Now().Add(-time.Hour).Unix()
+			return nil, nil
+		}},
+		{"no expiry", func(fake *fakeOIDCProvider, claims jwt.MapClaims) (jwt.SigningMethod, interface{}) {
+			delete(claims, "exp")
+			return nil, nil
+		}},
+		{"no subject", func(fake *fakeOIDCProvider, claims jwt.MapClaims) (jwt.SigningMethod, interface{}) {
+			delete(claims, "sub")
+			return nil, nil
+		}},
+		{"signed by another key", func(fake *fakeOIDCProvider, claims jwt.MapClaims) (jwt.SigningMethod, interface{}) {
+			return jwt.SigningMethodRS256, otherKey
+		}},
+		{"unsigned", func(fake *fakeOIDCProvider, claims jwt.MapClaims) (jwt.SigningMethod, interface{}) {
+			return jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType
+		}},
+		{"hmac with the public key", func(fake *fakeOIDCProvider, claims jwt.MapClaims) (jwt.SigningMethod, interface{}) {
+			public, err := x509.MarshalPKIXPublicKey(&fake.key.PublicKey)
+			if err != nil {
+				t.Errorf("encoding public key: %v", err)
+			}
+			return jwt.SigningMethodHS256, public
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeOIDCProvider(t)
+			provider := fake.provider()
+			fake.tamper = func(claims jwt.MapClaims) (jwt.SigningMethod, interface{}) {
+				return tt.tamper(fake, claims)
+			}
+
+			code := authorizeAtFake(t, fake, provider, "state-1", "nonce-1", "verifier-1")
+			if _, err := provider.redeem(code, pendingLogin("nonce-1", "verifier-1")); !errors.Is(err, ErrOIDCTokenInvalid) {
+				t.Fatalf("got %v, want ErrOIDCTokenInvalid", err)
+			}
+		})
+	}
+}
+
+func TestOIDCSigningKeyRotation(t *testing.T) {
+	fake := newFakeOIDCProvider(t)
+	provider := fake.provider()
+
+	code := authorizeAtFake(t, fake, provider, "state-1", "nonce-1", "verifier-1")
+	if _, err := provider.redeem(code, pendingLogin("nonce-1", "verifier-1")); err != nil {
+		t.Fatalf("first login failed: %v", err)
+	}
+
+	fake.rotateKey("key-2")
+
+	// a new kid right after a fetch is refused rather than hammering the provider
+	code = authorizeAtFake(t, fake, provider, "state-2", "nonce-2", "verifier-2")
+	if _, err := provider.redeem(code, pendingLogin("nonce-2", "verifier-2")); !errors.Is(err, ErrOIDCTokenInvalid) {
+		t.Fatalf("unknown kid within the refetch interval gave %v, want ErrOIDCTokenInvalid", err)
+	}
+
+	provider.jwksFetchedAt = time.Now().Add(-oidcJWKSRefetchInterval - time.Second)
+	code = authorizeAtFake(t, fake, provider, "state-3", "nonce-3", "verifier-3")
+	if _, err := provider.redeem(code, pendingLogin("nonce-3", "verifier-3")); err != nil {
+		t.Fatalf("login after key rotation failed: %v", err)
+	}
+	if fake.jwksFetch != 2 {
+		t.Fatalf("jwks fetched %d times, want 2", fake.jwksFetch)
+	}
+}
@@ -0,0 +1,323 @@
+package auth_utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrUnknownHashFormat  = errors.New("unrecognised password hash format")
+	ErrPasswordHasherBusy = errors.New("too many passwords are being checked, try again shortly")
+)
+
+// how long a request waits for an argon2 slot before giving up with ErrPasswordHasherBusy
+const argon2SlotWait = 2 * time.Second
+
+const dummyPassword = "smart-prop-dummy-password"
+
+// a password hashing scheme, Verify and NeedsRehash are only called with hashes the hasher Handles
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(encoded string, password string) (bool, error)
+	// true when the hash was produced with weaker parameters than this hasher uses
+	NeedsRehash(encoded string) bool
+	Handles(encoded string) bool
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h BcryptHasher) Verify(encoded string, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < h.Cost
+}
+
+func (h BcryptHasher) Handles(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// argon2id hashes are stored in the PHC string format: $argon2id$v=19$m=65536,t=3,p=2$salt$hash
+type Argon2idHasher struct {
+	MemoryKiB   uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	release, err := acquireArgon2Slot()
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.MemoryKiB, h.Parallelism, h.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.MemoryKiB, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func decodeArgon2id(encoded string) (argon2Params, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return argon2Params{}, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return argon2Params{}, ErrUnknownHashFormat
+	}
+
+	var params argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return argon2Params{}, ErrUnknownHashFormat
+	}
+
+	var err error
+	if params.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return argon2Params{}, ErrUnknownHashFormat
+	}
+	if params.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return argon2Params{}, ErrUnknownHashFormat
+	}
+	return params, nil
+}
+
+func (h Argon2idHasher) Verify(encoded string, password string) (bool, error) {
+	params, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	release, err := acquireArgon2Slot()
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	key := argon2.IDKey([]byte(password), params.salt, params.iterations, params.memory, params.parallelism, uint32(len(params.key)))
+	return subtle.ConstantTimeCompare(key, params.key) == 1, nil
+}
+
+func (h Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.memory < h.MemoryKiB || params.iterations < h.Iterations ||
+		params.parallelism < h.Parallelism || uint32(len(params.key)) < h.KeyLength
+}
+
+func (h Argon2idHasher) Handles(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+var (
+	passwordHasher     PasswordHasher
+	fallbackHashers    []PasswordHasher
+	passwordHasherOnce sync.Once
+
+	// every argon2 hash holds its whole memory cost until it finishes, so only this many run at once
+	argon2Slots     chan struct{}
+	argon2SlotsOnce sync.Once
+)
+
+// ARGON2_MAX_CONCURRENT bounds parallel argon2 work, by default one per cpu the scheduler uses,
+// its memory ceiling is that many times ARGON2_MEMORY_KIB
+func acquireArgon2Slot() (func(), error) {
+	argon2SlotsOnce.Do(func() {
+		argon2Slots = make(chan struct{}, envInt("ARGON2_MAX_CONCURRENT", runtime.GOMAXPROCS(0), 1))
+	})
+
+	timer := time.NewTimer(argon2SlotWait)
+	defer timer.Stop()
+	select {
+	case argon2Slots <- struct{}{}:
+		return func() { <-argon2Slots }, nil
+	case <-timer.C:
+		return nil, ErrPasswordHasherBusy
+	}
+}
+
+func envInt(name string, fallback int, minimum int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q, using %d\n", name, raw, fallback)
+		return fallback
+	}
+	if value < minimum {
+		log.Printf("%s=%d is below the minimum policy, using %d\n", name, value, minimum)
+		return minimum
+	}
+	return value
+}
+
+// hasher policy from the environment, PASSWORD_HASHER picks argon2id (default) or bcrypt and
+// the cost variables can only raise the built in minimums
+func hashers() (PasswordHasher, []PasswordHasher) {
+	passwordHasherOnce.Do(func() {
+		// HashCost is the original name of the bcrypt cost variable and is still honoured
+		bcryptCost := envInt("BCRYPT_COST", envInt("HashCost", 12, 12), 12)
+		bcryptHasher := BcryptHasher{Cost: bcryptCost}
+
+		argonHasher := Argon2idHasher{
+			MemoryKiB:   uint32(envInt("ARGON2_MEMORY_KIB", 64*1024, 19*1024)),
+			Iterations:  uint32(envInt("ARGON2_ITERATIONS", 3, 2)),
+			Parallelism: uint8(envInt("ARGON2_PARALLELISM", 2, 1)),
+			SaltLength:  16,
+			KeyLength:   32,
+		}
+
+		switch os.Getenv("PASSWORD_HASHER") {
+		case "bcrypt":
+			passwordHasher = bcryptHasher
+		default:
+			passwordHasher = argonHasher
+		}
+		fallbackHashers = []PasswordHasher{argonHasher, bcryptHasher}
+	})
+	return passwordHasher, fallbackHashers
+}
+
+func hasherFor(encoded string) (PasswordHasher, error) {
+	_, known := hashers()
+	for _, hasher := range known {
+		if hasher.Handles(encoded) {
+			return hasher, nil
+		}
+	}
+	return nil, ErrUnknownHashFormat
+}
+
+// hash a password with the configured hasher
+func HashPassword(pwd string) (string, error) {
+	hasher, _ := hashers()
+	return hasher.Hash(pwd)
+}
+
+// compare user password and stored hash, whichever scheme produced it
+// the only error returned is ErrPasswordHasherBusy, anything else is logged and treated as a mismatch
+func ComparePasswordAndHash(hashedPwd string, plainPwd string) (bool, error) {
+	hasher, err := hasherFor(hashedPwd)
+	if err != nil {
+		return false, nil
+	}
+
+	match, err := hasher.Verify(hashedPwd, plainPwd)
+	if errors.Is(err, ErrPasswordHasherBusy) {
+		return false, err
+	}
+	if err != nil {
+		log.Println(err)
+		return false, nil
+	}
+	return match, nil
+}
+
+// true when a stored hash should be replaced, either a different scheme or weaker parameters than policy
+func PasswordNeedsRehash(hashedPwd string) bool {
+	hasher, _ := hashers()
+	if !hasher.Handles(hashedPwd) {
+		return true
+	}
+	return hasher.NeedsRehash(hashedPwd)
+}
+
+// hash the dummy password with every scheme still found among stored passwords, bcrypt at the highest stored
+// cost, and keep whichever takes longest to verify
+func slowestDummyHash() (string, error) {
+	current, known := hashers()
+	candidates := []PasswordHasher{current}
+
+	// "$2a$12$" or "$argon2id$" is enough to tell the scheme and the bcrypt cost apart
+	var prefixes []string
+	result := connector.DB.Model(&models.User{}).Where("password <> ''").Distinct().Pluck("left(password, 10)", &prefixes)
+	if result.Error != nil {
+		return "", result.Error
+	}
+
+	bcryptCost, storedArgon := 0, false
+	for _, prefix := range prefixes {
+		if len(prefix) >= 7 && (BcryptHasher{}).Handles(prefix) {
+			if cost, err := strconv.Atoi(prefix[4:6]); err == nil && cost > bcryptCost {
+				bcryptCost = cost
+			}
+		}
+		storedArgon = storedArgon || (Argon2idHasher{}).Handles(prefix)
+	}
+	if bcryptCost > 0 {
+		candidates = append(candidates, BcryptHasher{Cost: min(bcryptCost, bcrypt.MaxCost)})
+	}
+	for _, hasher := range known {
+		if argonHasher, ok := hasher.(Argon2idHasher); ok && storedArgon {
+			candidates = append(candidates, argonHasher)
+		}
+	}
+
+	slowest, slowestTime := "", time.Duration(-1)
+	for _, hasher := range candidates {
+		hash, err := hasher.Hash(dummyPassword)
+		if err != nil {
+			return "", err
+		}
+		started := time.Now()
+		hasher.Verify(hash, dummyPassword+"!")
+		if elapsed := time.Since(started); elapsed > slowestTime {
+			slowest, slowestTime = hash, elapsed
+		}
+	}
+	return slowest, nil
+}
@@ -0,0 +1,61 @@
+package auth_utils
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+var cheapArgon2 = Argon2idHasher{MemoryKiB: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+// once every argon2 slot is taken further work waits, then gives up instead of allocating more memory
+func TestArgon2WorkIsBounded(t *testing.T) {
+	hash, err := cheapArgon2.Hash("correct horse 7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var held []func()
+	for range cap(argon2Slots) {
+		release, err := acquireArgon2Slot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		held = append(held, release)
+	}
+
+	if _, err := cheapArgon2.Hash("correct horse 7"); !errors.Is(err, ErrPasswordHasherBusy) {
+		t.Errorf("Hash with every slot taken returned %v", err)
+	}
+	useHasher(t, cheapArgon2)
+	if match, err := ComparePasswordAndHash(hash, "correct horse 7"); match || !errors.Is(err, ErrPasswordHasherBusy) {
+		t.Errorf("ComparePasswordAndHash with every slot taken returned %v, %v", match, err)
+	}
+
+	// a slot freed while waiting is picked up
+	done := make(chan error)
+	go func() {
+		_, err := cheapArgon2.Verify(hash, "correct horse 7")
+		done <- err
+	}()
+	held[0]()
+	if err := <-done; err != nil {
+		t.Errorf("Verify after a slot was freed: %v", err)
+	}
+
+	for _, release := range held[1:] {
+		release()
+	}
+	var wg sync.WaitGroup
+	for range 2 * cap(argon2Slots) {
+		wg.Go(func() {
+			if match, err := ComparePasswordAndHash(hash, "correct horse 7"); !match || err != nil {
+				t.Errorf("concurrent compare returned %v, %v", match, err)
+			}
+		})
+	}
+	wg.Wait()
+	if len(argon2Slots) != 0 {
+		t.Errorf("%d slots were never released", len(argon2Slots))
+	}
+}
@@ -0,0 +1,62 @@
+package auth_utils
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 128
+	// bcrypt refuses longer input, multibyte passwords reach this before MaxPasswordLength
+	MaxBcryptPasswordBytes = 72
+)
+
+var (
+	ErrPasswordTooShort         = errors.New("password must be at least 8 characters")
+	ErrPasswordTooLong          = errors.New("password must be at most 128 characters")
+	ErrPasswordTooLongForBcrypt = errors.New("password must be at most 72 bytes")
+	ErrPasswordTooWeak          = errors.New("password must contain a letter and a number or symbol")
+	ErrPasswordCommon           = errors.New("password is too common")
+)
+
+// a few of the passwords that show up first in every breach list, lower cased
+var commonPasswords = map[string]bool{
+	"password": true, "password1": true, "password123": true, "12345678": true, "123456789": true,
+	"1234567890": true, "qwerty123": true, "qwertyuiop": true, "iloveyou1": true, "welcome1": true,
+	"letmein1": true, "admin123": true, "abc12345": true, "passw0rd": true, "smartprop1": true,
+}
+
+// check a new password against the policy, existing passwords are not re-checked at login
+func CheckPasswordPolicy(password string) error {
+	length := len([]rune(password))
+	if length < MinPasswordLength {
+		return ErrPasswordTooShort
+	}
+	if length > MaxPasswordLength {
+		return ErrPasswordTooLong
+	}
+	if hasher, _ := hashers(); len(password) > MaxBcryptPasswordBytes {
+		if _, ok := hasher.(BcryptHasher); ok {
+			return ErrPasswordTooLongForBcrypt
+		}
+	}
+
+	hasLetter, hasOther := false, false
+	for _, r := range password {
+		if unicode.IsLetter(r) {
+			hasLetter = true
+		} else if !unicode.IsSpace(r) {
+			hasOther = true
+		}
+	}
+	if !hasLetter || !hasOther {
+		return ErrPasswordTooWeak
+	}
+
+	if commonPasswords[strings.ToLower(password)] {
+		return ErrPasswordCommon
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package auth_utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// swap the configured hasher for the length of a test
+func useHasher(t *testing.T, hasher PasswordHasher) {
+	t.Helper()
+	hashers()
+	saved := passwordHasher
+	passwordHasher = hasher
+	t.Cleanup(func() { passwordHasher = saved })
+}
+
+func TestCheckPasswordPolicy(t *testing.T) {
+	useHasher(t, Argon2idHasher{MemoryKiB: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+
+	tests := map[string]error{
+		"short1!":                      ErrPasswordTooShort,
+		"longenough":                   ErrPasswordTooWeak,
+		"12345678!":                    ErrPasswordTooWeak,
+		"Password123":                  ErrPasswordCommon,
+		"correct horse 7":              nil,
+		strings.Repeat("a1", 64):       nil,
+		strings.Repeat("a1", 64) + "b": ErrPasswordTooLong,
+		// 100 characters but 200 bytes, only bcrypt cares about bytes
+		strings.Repeat("é1", 50): nil,
+	}
+	for password, want := range tests {
+		if err := CheckPasswordPolicy(password); !errors.Is(err, want) {
+			t.Errorf("CheckPasswordPolicy(%q) = %v, want %v", password, err, want)
+		}
+	}
+}
+
+// every password the policy lets through has to hash, bcrypt rejects anything over 72 bytes
+func TestCheckPasswordPolicyWithBcrypt(t *testing.T) {
+	hasher := BcryptHasher{Cost: bcrypt.MinCost}
+	useHasher(t, hasher)
+
+	tests := map[string]error{
+		strings.Repeat("a1", 36):       nil,
+		strings.Repeat("a1", 36) + "b": ErrPasswordTooLongForBcrypt,
+		strings.Repeat("a1", 64):       ErrPasswordTooLongForBcrypt,
+		strings.Repeat("é1", 24):       nil,
+		strings.Repeat("é1", 24) + "é": ErrPasswordTooLongForBcrypt,
+		strings.Repeat("€", 25) + "1":  ErrPasswordTooLongForBcrypt,
+	}
+	for password, want := range tests {
+		err := CheckPasswordPolicy(password)
+		if !errors.Is(err, want) {
+			t.Errorf("CheckPasswordPolicy(%d bytes) = %v, want %v", len(password), err, want)
+		}
+		if err != nil {
+			continue
+		}
+		hash, err := HashPassword(password)
+		if err != nil {
+			t.Errorf("accepted %d byte password failed to hash: %v", len(password), err)
+			continue
+		}
+		if match, err := ComparePasswordAndHash(hash, password); !match || err != nil {
+			t.Errorf("accepted %d byte password does not verify", len(password))
+		}
+	}
+}
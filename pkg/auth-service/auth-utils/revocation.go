@@ -0,0 +1,156 @@
+package auth_utils
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	RevokeKindToken   = "jti"
+	RevokeKindSession = "session"
+
+	// how often the in-memory list picks up revocations made by other instances
+	revocationSyncInterval = 30 * time.Second
+)
+
+// in-memory copy of the revocation table so the middleware does not hit the db on every request
+type revocationCache struct {
+	mu       sync.RWMutex
+	entries  map[string]time.Time
+	lastSync time.Time
+}
+
+var revocations = &revocationCache{entries: make(map[string]time.Time)}
+
+func revocationKey(kind string, value string) string {
+	return kind + ":" + value
+}
+
+func (r *revocationCache) add(kind string, value string, expiresAt time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[revocationKey(kind, value)] = expiresAt
+}
+
+func (r *revocationCache) contains(kind string, value string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	expiresAt, ok := r.entries[revocationKey(kind, value)]
+	return ok && time.Now().Before(expiresAt)
+}
+
+// pull revocations written since the last sync and drop the expired ones
+func (r *revocationCache) sync() {
+	r.mu.RLock()
+	stale := time.Since(r.lastSync) > revocationSyncInterval
+	since := r.lastSync
+	r.mu.RUnlock()
+	if !stale {
+		return
+	}
+
+	now := time.Now()
+	var rows []models.TokenRevocation
+	query := connector.DB.Where("expires_at > ?", now)
+	if !since.IsZero() {
+		// overlap a little so rows committed during the previous sync are not missed
+		query = query.Where("created_at > ?", since.Add(-revocationSyncInterval))
+	}
+	if err := query.Find(&rows).Error; err != nil {
+		log.Printf("Error occurred trying to sync token revocations:\n %v", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, expiresAt := range r.entries {
+		if now.After(expiresAt) {
+			delete(r.entries, key)
+		}
+	}
+	for _, row := range rows {
+		r.entries[revocationKey(row.Kind, row.Value)] = row.ExpiresAt
+	}
+	r.lastSync = now
+}
+
+func storeRevocation(kind string, value string, expiresAt time.Time) error {
+	revocation := models.TokenRevocation{
+		Kind:      kind,
+		Value:     value,
+		ExpiresAt: expiresAt,
+	}
+	result := connector.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&revocation)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	revocations.add(kind, value, expiresAt)
+	return nil
+}
+
+// check an access token against the revocation list
+func IsTokenRevoked(claims *JWTClaims) bool {
+	revocations.sync()
+
+	if claims.ID != "" && revocations.contains(RevokeKindToken, claims.ID) {
+		return true
+	}
+	if claims.SessionID != "" && revocations.contains(RevokeKindSession, claims.SessionID) {
+		return true
+	}
+	return false
+}
+
+// when a token stops being valid, used to size its revocation entry
+func (c *JWTClaims) Expiry() time.Time {
+	if c.ExpiresAt == nil {
+		return time.Now().Add(AccessTokenTTL)
+	}
+	return c.ExpiresAt.Time
+}
+
+// revoke a single access token, the entry only has to outlive the token itself
+func RevokeAccessToken(tokenID string, expiresAt time.Time) error {
+	return storeRevocation(RevokeKindToken, tokenID, expiresAt)
+}
+
+// end a session, its refresh tokens stop working and outstanding access tokens are rejected
+func RevokeSession(sessionID string) error {
+	now := time.Now()
+	result := connector.DB.Model(&models.Session{}).
+		Where("session_id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return storeRevocation(RevokeKindSession, sessionID, now.Add(max(AccessTokenTTL, ImpersonationTTL)))
+}
+
+// end every active session a user has, including impersonations of or by them, returns how many were revoked
+func RevokeUserSessions(userID uint) (int, error) {
+	return RevokeOtherSessions(userID, "")
+}
+
+// end every active session a user has apart from keepSessionID, returns how many were revoked
+func RevokeOtherSessions(userID uint, keepSessionID string) (int, error) {
+	var sessions []models.Session
+	result := connector.DB.Where("(user_id = ? OR actor_id = ?) AND revoked_at IS NULL AND expires_at > ? AND session_id <> ?", userID, userID, time.Now(), keepSessionID).Find(&sessions)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	for _, session := range sessions {
+		if err := RevokeSession(session.SessionID); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(sessions), nil
+}
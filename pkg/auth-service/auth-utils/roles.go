@@ -0,0 +1,121 @@
+package auth_utils
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrLastRole           = errors.New("an account must keep at least one role, grant another role before revoking this one")
+	ErrBootstrapAdminRole = errors.New("admin role comes from ADMIN_EMAILS and cannot be revoked here, remove the address from ADMIN_EMAILS instead")
+)
+
+const (
+	RoleTenant   = "tenant"
+	RoleLandlord = "landlord"
+	RoleAgent    = "agent"
+	RoleAdmin    = "admin"
+)
+
+var validRoles = map[string]bool{
+	RoleTenant:   true,
+	RoleLandlord: true,
+	RoleAgent:    true,
+	RoleAdmin:    true,
+}
+
+func IsValidRole(role string) bool {
+	return validRoles[role]
+}
+
+// accounts listed in ADMIN_EMAILS are always admins, this is how the first admin gets in
+func isBootstrapAdmin(email string) bool {
+	for _, admin := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if admin = strings.TrimSpace(admin); admin != "" && strings.EqualFold(admin, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// roles held by a user, accounts created before roles existed count as tenants
+func UserRoles(user models.User) ([]string, error) {
+	var rows []models.UserRole
+	result := connector.DB.Where("user_id = ?", user.ID).Order("role").Find(&rows)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	roles := make([]string, 0, len(rows)+1)
+	hasAdmin := false
+	for _, row := range rows {
+		roles = append(roles, row.Role)
+		hasAdmin = hasAdmin || row.Role == RoleAdmin
+	}
+	if len(roles) == 0 {
+		roles = append(roles, RoleTenant)
+	}
+	if !hasAdmin && isBootstrapAdmin(user.EMAIL) {
+		roles = append(roles, RoleAdmin)
+	}
+
+	return roles, nil
+}
+
+func GrantRole(userID uint, role string) error {
+	userRole := models.UserRole{UserID: userID, Role: role}
+	return connector.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&userRole).Error
+}
+
+// returns false when the user did not hold the role, a user's last role cannot be revoked since an account
+// without roles falls back to tenant and the revoke would silently do nothing; the same goes for admin on
+// an ADMIN_EMAILS account, which keeps it whatever rows it holds
+func RevokeRole(user models.User, role string) (bool, error) {
+	if role == RoleAdmin && isBootstrapAdmin(user.EMAIL) {
+		return false, ErrBootstrapAdminRole
+	}
+
+	userID := user.ID
+	removed := false
+	err := connector.DB.Transaction(func(tx *gorm.DB) error {
+		var held []string
+		if err := tx.Model(&models.UserRole{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ?", userID).Pluck("role", &held).Error; err != nil {
+			return err
+		}
+
+		holds := false
+		for _, h := range held {
+			holds = holds || h == role
+		}
+		if (holds && len(held) == 1) || (len(held) == 0 && role == RoleTenant) {
+			return ErrLastRole
+		}
+		if !holds {
+			return nil
+		}
+
+		result := tx.Unscoped().Where("user_id = ? AND role = ?", userID, role).Delete(&models.UserRole{})
+		removed = result.RowsAffected > 0
+		return result.Error
+	})
+	return removed, err
+}
+
+// true when any of the held roles is one of the allowed ones
+func HasAnyRole(held []string, allowed ...string) bool {
+	for _, h := range held {
+		for _, a := range allowed {
+			if h == a {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,20 @@
+package auth_utils
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+)
+
+// admin from ADMIN_EMAILS is refused before the database is touched, whichever spelling the address has
+func TestRevokeRoleBootstrapAdmin(t *testing.T) {
+	t.Setenv("ADMIN_EMAILS", "ops@example.com, Owner@Example.com")
+
+	for _, email := range []string{"owner@example.com", "OPS@example.com"} {
+		removed, err := RevokeRole(models.User{EMAIL: email}, RoleAdmin)
+		if !errors.Is(err, ErrBootstrapAdminRole) || removed {
+			t.Errorf("revoking admin from %s: removed %v, err %v, want ErrBootstrapAdminRole", email, removed, err)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package auth_utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid or expired")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used")
+)
+
+// access and refresh token handed back to clients after login
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int64
+	SessionID    string
+}
+
+// response payload shared by every handler that logs a user in
+func (p TokenPair) ResponseData(userID uint) map[string]interface{} {
+	return map[string]interface{}{
+		"user_id":       userID,
+		"token":         p.AccessToken,
+		"refresh_token": p.RefreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    p.ExpiresIn,
+	}
+}
+
+// random url safe string for opaque tokens
+func RandomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// sha256 of an opaque token, only the digest is ever stored
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// random hex identifier used for session ids and jti claims
+func randomID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// start a new session for the user and issue its first token pair
+func CreateSession(user models.User, client ClientInfo) (TokenPair, error) {
+	sessionID, err := randomID()
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	now := time.Now()
+	session := models.Session{
+		SessionID:  sessionID,
+		UserID:     user.ID,
+		ExpiresAt:  now.Add(RefreshTokenTTL),
+		UserAgent:  client.UserAgent,
+		Device:     DeviceLabel(client.UserAgent),
+		IP:         client.IP,
+		LastSeenAt: &now,
+	}
+
+	var pair TokenPair
+	txErr := connector.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+
+		refreshToken, err := issueRefreshToken(tx, session)
+		if err != nil {
+			return err
+		}
+
+		pair, err = buildTokenPair(user, session, refreshToken)
+		return err
+	})
+	if txErr != nil {
+		return TokenPair{}, txErr
+	}
+
+	return pair, nil
+}
+
+// exchange a refresh token for a new pair, presenting an already rotated token
+// revokes the whole session since it means the token family has leaked
+func RotateRefreshToken(refreshToken string, client ClientInfo) (TokenPair, models.User, error) {
+	var pair TokenPair
+	var user models.User
+	var reusedSession uint
+
+	txErr := connector.DB.Transaction(func(tx *gorm.DB) error {
+		var stored models.RefreshToken
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Preload("Session").
+			Where("token_hash = ?", HashToken(refreshToken)).
+			First(&stored)
+		if result.Error != nil {
+			return ErrRefreshTokenInvalid
+		}
+
+		now := time.Now()
+		session := stored.Session
+		if session.RevokedAt != nil || now.After(session.ExpiresAt) || now.After(stored.ExpiresAt) {
+			return ErrRefreshTokenInvalid
+		}
+
+		if stored.UsedAt != nil {
+			reusedSession = stored.SessionID
+			return nil
+		}
+
+		if err := tx.Model(&stored).Update("used_at", now).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&session).Updates(map[string]interface{}{"last_seen_at": now, "ip": client.IP}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.First(&user, session.UserID).Error; err != nil {
+			return ErrRefreshTokenInvalid
+		}
+
+		newToken, err := issueRefreshToken(tx, session)
+		if err != nil {
+			return err
+		}
+
+		pair, err = buildTokenPair(user, session, newToken)
+		return err
+	})
+	if txErr != nil {
+		return TokenPair{}, models.User{}, txErr
+	}
+
+	if reusedSession != 0 {
+		var session models.Session
+		if err := connector.DB.First(&session, reusedSession).Error; err != nil {
+			return TokenPair{}, models.User{}, err
+		}
+		if err := RevokeSession(session.SessionID); err != nil {
+			return TokenPair{}, models.User{}, err
+		}
+		// the owner is still handed back so callers can tell who the replayed token belonged to
+		connector.DB.First(&user, session.UserID)
+		return TokenPair{}, user, ErrRefreshTokenReused
+	}
+
+	return pair, user, nil
+}
+
+func issueRefreshToken(tx *gorm.DB, session models.Session) (string, error) {
+	token, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	record := models.RefreshToken{
+		SessionID: session.ID,
+		TokenHash: HashToken(token),
+		ExpiresAt: session.ExpiresAt,
+	}
+	if err := tx.Create(&record).Error; err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func buildTokenPair(user models.User, session models.Session, refreshToken string) (TokenPair, error) {
+	roles, err := UserRoles(user)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	currentDateTime := time.Now().Format("20060102150405")
+	accessToken, err := GenerateJWTToken(currentDateTime, user.ID, user.EMAIL, session.SessionID, roles)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(AccessTokenTTL.Seconds()),
+		SessionID:    session.SessionID,
+	}, nil
+}
@@ -0,0 +1,194 @@
+package auth_utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	"gorm.io/gorm"
+)
+
+const (
+	PurposePasswordReset     = "password_reset"
+	PurposeEmailVerification = "email_verification"
+	PurposeEmailChange       = "email_change"
+	PurposeMagicLogin        = "magic_login"
+	PurposeAccountDeletion   = "account_deletion"
+
+	PasswordResetTTL     = time.Hour
+	EmailVerificationTTL = 48 * time.Hour
+	EmailChangeTTL       = 24 * time.Hour
+	MagicLoginTTL        = 15 * time.Minute
+	AccountDeletionTTL   = time.Hour
+)
+
+var (
+	ErrSignedTokenInvalid = errors.New("token is invalid or has expired")
+	ErrSigningKeyMissing  = errors.New("TOKEN_SIGNING_KEY is not configured")
+)
+
+// what a signed token carries, the nonce points at its models.UserToken row
+type signedTokenPayload struct {
+	Purpose string `json:"p"`
+	UserID  uint   `json:"u"`
+	Nonce   string `json:"n"`
+	Expiry  int64  `json:"e"`
+}
+
+func tokenSigningKey() ([]byte, error) {
+	key := os.Getenv("TOKEN_SIGNING_KEY")
+	if key == "" {
+		return nil, ErrSigningKeyMissing
+	}
+	return []byte(key), nil
+}
+
+func signPayload(key []byte, encodedPayload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(encodedPayload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// issue an hmac signed, single use token for the user, earlier unused tokens for the same purpose are invalidated
+func IssueSignedToken(userID uint, purpose string, ttl time.Duration) (string, error) {
+	return issueSignedToken(models.UserToken{UserID: userID, Purpose: purpose}, ttl)
+}
+
+// an email change token is tied to the address it is mailed to, so a link cannot confirm a different
+// address requested after it was sent; sessionID is the session that asked and stays signed in
+func IssueEmailChangeToken(userID uint, newEmail string, sessionID string) (string, error) {
+	return issueSignedToken(models.UserToken{
+		UserID:     userID,
+		Purpose:    PurposeEmailChange,
+		TargetHash: emailChangeTargetHash(newEmail),
+		SessionID:  sessionID,
+	}, EmailChangeTTL)
+}
+
+func emailChangeTargetHash(email string) string {
+	return HashToken(strings.ToLower(strings.TrimSpace(email)))
+}
+
+// true when a consumed email change token was sent to the address now pending
+func EmailChangeTokenMatches(record models.UserToken, pendingEmail string) bool {
+	return record.TargetHash != "" && pendingEmail != "" && hmac.Equal([]byte(record.TargetHash), []byte(emailChangeTargetHash(pendingEmail)))
+}
+
+func issueSignedToken(record models.UserToken, ttl time.Duration) (string, error) {
+	userID, purpose := record.UserID, record.Purpose
+	key, err := tokenSigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	nonce, err := RandomToken(24)
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	payload, err := json.Marshal(signedTokenPayload{
+		Purpose: purpose,
+		UserID:  userID,
+		Nonce:   nonce,
+		Expiry:  expiresAt.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	record.NonceHash = HashToken(nonce)
+	record.ExpiresAt = expiresAt
+
+	txErr := connector.DB.Transaction(func(tx *gorm.DB) error {
+		invalidate := tx.Model(&models.UserToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+			Update("used_at", time.Now())
+		if invalidate.Error != nil {
+			return invalidate.Error
+		}
+		return tx.Create(&record).Error
+	})
+	if txErr != nil {
+		return "", txErr
+	}
+
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+	return encodedPayload + "." + signPayload(key, encodedPayload), nil
+}
+
+// verify a signed token and burn it, a token can only be consumed once
+func ConsumeSignedToken(token string, purpose string) (models.UserToken, error) {
+	key, err := tokenSigningKey()
+	if err != nil {
+		return models.UserToken{}, err
+	}
+
+	encodedPayload, signature, found := strings.Cut(token, ".")
+	if !found {
+		return models.UserToken{}, ErrSignedTokenInvalid
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(signPayload(key, encodedPayload))) {
+		return models.UserToken{}, ErrSignedTokenInvalid
+	}
+
+	rawPayload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return models.UserToken{}, ErrSignedTokenInvalid
+	}
+
+	var payload signedTokenPayload
+	if err := json.Unmarshal(rawPayload, &payload); err != nil {
+		return models.UserToken{}, ErrSignedTokenInvalid
+	}
+
+	now := time.Now()
+	if payload.Purpose != purpose || now.Unix() > payload.Expiry {
+		return models.UserToken{}, ErrSignedTokenInvalid
+	}
+
+	var record models.UserToken
+	result := connector.DB.Where("nonce_hash = ? AND purpose = ? AND user_id = ?", HashToken(payload.Nonce), purpose, payload.UserID).First(&record)
+	if result.Error != nil {
+		return models.UserToken{}, ErrSignedTokenInvalid
+	}
+
+	// conditional update so two concurrent requests cannot both consume the token
+	burn := connector.DB.Model(&models.UserToken{}).
+		Where("id = ? AND used_at IS NULL AND expires_at > ?", record.ID, now).
+		Update("used_at", now)
+	if burn.Error != nil {
+		return models.UserToken{}, burn.Error
+	}
+	if burn.RowsAffected != 1 {
+		return models.UserToken{}, ErrSignedTokenInvalid
+	}
+
+	record.UsedAt = &now
+	return record, nil
+}
+
+// when the most recent token for a purpose was issued and how many were issued since a point in time
+func RecentSignedTokens(userID uint, purpose string, since time.Time) (time.Time, int64, error) {
+	var latest models.UserToken
+	result := connector.DB.Where("user_id = ? AND purpose = ?", userID, purpose).Order("created_at desc").Limit(1).Find(&latest)
+	if result.Error != nil {
+		return time.Time{}, 0, result.Error
+	}
+
+	var count int64
+	countResult := connector.DB.Model(&models.UserToken{}).Where("user_id = ? AND purpose = ? AND created_at > ?", userID, purpose, since).Count(&count)
+	if countResult.Error != nil {
+		return time.Time{}, 0, countResult.Error
+	}
+
+	return latest.CreatedAt, count, nil
+}
@@ -0,0 +1,29 @@
+package auth_utils
+
+import (
+	"testing"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+)
+
+func TestEmailChangeTokenMatches(t *testing.T) {
+	record := models.UserToken{Purpose: PurposeEmailChange, TargetHash: emailChangeTargetHash("b@example.com")}
+
+	tests := []struct {
+		name    string
+		record  models.UserToken
+		pending string
+		want    bool
+	}{
+		{name: "address the link was sent to", record: record, pending: "b@example.com", want: true},
+		{name: "case and spacing", record: record, pending: " B@Example.com", want: true},
+		{name: "address requested after the link was sent", record: record, pending: "c@example.com", want: false},
+		{name: "nothing pending", record: record, pending: "", want: false},
+		{name: "token without a target", record: models.UserToken{Purpose: PurposeEmailChange}, pending: "b@example.com", want: false},
+	}
+	for _, tt := range tests {
+		if got := EmailChangeTokenMatches(tt.record, tt.pending); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
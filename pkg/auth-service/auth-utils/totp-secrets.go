@@ -0,0 +1,102 @@
+package auth_utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+)
+
+// prefix of an encrypted totp secret, anything without it is a plaintext secret stored before encryption
+const sealedSecretPrefix = "enc:v1:"
+
+var (
+	ErrTOTPKeyMissing    = errors.New("TOTP_ENCRYPTION_KEY is not configured")
+	ErrTOTPSecretInvalid = errors.New("stored totp secret could not be decrypted")
+)
+
+// aes-256-gcm over TOTP_ENCRYPTION_KEY, hashed so any length of key can be configured
+func totpCipher() (cipher.AEAD, error) {
+	key := os.Getenv("TOTP_ENCRYPTION_KEY")
+	if key == "" {
+		return nil, ErrTOTPKeyMissing
+	}
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt a totp secret for storage in users.totp_secret
+func SealTOTPSecret(secret string) (string, error) {
+	aead, err := totpCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(secret), nil)
+	return sealedSecretPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// the plain totp secret from users.totp_secret, secrets stored before encryption are returned as they are
+func OpenTOTPSecret(stored string) (string, error) {
+	if !strings.HasPrefix(stored, sealedSecretPrefix) {
+		return stored, nil
+	}
+	aead, err := totpCipher()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(stored, sealedSecretPrefix))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", ErrTOTPSecretInvalid
+	}
+	secret, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", ErrTOTPSecretInvalid
+	}
+	return string(secret), nil
+}
+
+// encrypt totp secrets stored in plaintext before encryption existed, returns how many were rewritten
+func EncryptStoredTOTPSecrets() (int, error) {
+	var users []models.User
+	result := connector.DB.Select("id", "totp_secret").
+		Where("totp_secret <> '' AND totp_secret NOT LIKE ?", sealedSecretPrefix+"%").Find(&users)
+	if result.Error != nil || len(users) == 0 {
+		return 0, result.Error
+	}
+
+	if _, err := totpCipher(); err != nil {
+		return 0, err
+	}
+
+	encrypted := 0
+	for _, user := range users {
+		sealed, err := SealTOTPSecret(user.TOTPSecret)
+		if err != nil {
+			return encrypted, err
+		}
+		// conditional so a secret replaced by a new enrollment meanwhile is left alone
+		update := connector.DB.Model(&models.User{}).
+			Where("id = ? AND totp_secret = ?", user.ID, user.TOTPSecret).
+			Update("totp_secret", sealed)
+		if update.Error != nil {
+			return encrypted, update.Error
+		}
+		encrypted += int(update.RowsAffected)
+	}
+	return encrypted, nil
+}
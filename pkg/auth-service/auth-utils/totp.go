@@ -0,0 +1,289 @@
+package auth_utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	totpIssuer = "Smart Prop"
+	totpPeriod = 30
+	totpDigits = 6
+	// accept codes one step either side of now to allow for clock drift
+	totpSkew = 1
+
+	RecoveryCodeCount = 10
+
+	MFATokenTTL = 5 * time.Minute
+	// wrong codes allowed against a single mfa challenge before it is burned
+	MFAMaxAttempts = 5
+	// wrong codes allowed against an account across all challenges before codes are refused for a while
+	MFAMaxFailures   = 10
+	MFALockoutPeriod = 15 * time.Minute
+)
+
+var ErrMFATokenInvalid = errors.New("mfa challenge is invalid or has expired")
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// new random totp secret, base32 encoded as authenticator apps expect
+func GenerateTOTPSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(buf), nil
+}
+
+// otpauth uri for rendering as a qr code during enrollment
+func TOTPURI(secret string, accountName string) string {
+	label := url.PathEscape(totpIssuer + ":" + accountName)
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", strconv.Itoa(totpDigits))
+	query.Set("period", strconv.Itoa(totpPeriod))
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// rfc 6238 code for a time step
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod), nil
+}
+
+// check a code against the secret, steps at or before lastStep are rejected so a code cannot be replayed
+func ValidateTOTP(secret string, code string, lastStep int64) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := time.Now().Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// verify a totp code for the user and remember its step
+func VerifyUserTOTP(user models.User, code string) (bool, error) {
+	secret, err := OpenTOTPSecret(user.TOTPSecret)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := ValidateTOTP(secret, code, user.TOTPLastStep)
+	if !ok {
+		return false, nil
+	}
+
+	// conditional update so the same code cannot be used by two concurrent requests
+	result := connector.DB.Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// replace the user's recovery codes with a fresh set, the plain codes are only ever returned here
+func GenerateRecoveryCodes(userID uint) ([]string, error) {
+	codes := make([]string, 0, RecoveryCodeCount)
+	rows := make([]models.RecoveryCode, 0, RecoveryCodeCount)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(totpEncoding.EncodeToString(buf))
+		code := raw[:4] + "-" + raw[4:]
+
+		codes = append(codes, code)
+		rows = append(rows, models.RecoveryCode{UserID: userID, CodeHash: HashToken(normalizeRecoveryCode(code))})
+	}
+
+	txErr := connector.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&rows).Error
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+
+	return codes, nil
+}
+
+// burn a recovery code, false when it does not exist or was already used
+func UseRecoveryCode(userID uint, code string) (bool, error) {
+	result := connector.DB.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, HashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// short lived token proving the password step of a login passed, exchanged for real tokens once the totp code checks out
+func GenerateMFAToken(user models.User) (string, error) {
+	tokenID, err := randomID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := &JWTClaims{
+		UserEmail: user.EMAIL,
+		TokenUse:  TokenUseMFA,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			ExpiresAt: jwt.NewNumericDate(now.Add(MFATokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    TokenIssuer,
+			ID:        tokenID,
+		},
+	}
+	return SignToken(claims)
+}
+
+func ParseMFAToken(tokenString string) (*JWTClaims, error) {
+	claims := &JWTClaims{}
+	token, err := ParseToken(tokenString, claims)
+	if err != nil || !token.Valid || claims.TokenUse != TokenUseMFA || IsTokenRevoked(claims) {
+		return nil, ErrMFATokenInvalid
+	}
+	return claims, nil
+}
+
+type mfaFailure struct {
+	count     int
+	expiresAt time.Time
+}
+
+var (
+	mfaFailuresMu sync.Mutex
+	mfaFailures   = map[string]*mfaFailure{}
+)
+
+// count a wrong code against an mfa challenge, returns true once the challenge has been burned
+func RecordMFAFailure(claims *JWTClaims) bool {
+	mfaFailuresMu.Lock()
+	now := time.Now()
+	for id, failure := range mfaFailures {
+		if now.After(failure.expiresAt) {
+			delete(mfaFailures, id)
+		}
+	}
+
+	failure, ok := mfaFailures[claims.ID]
+	if !ok {
+		failure = &mfaFailure{expiresAt: claims.Expiry()}
+		mfaFailures[claims.ID] = failure
+	}
+	failure.count++
+	burned := failure.count >= MFAMaxAttempts
+	if burned {
+		delete(mfaFailures, claims.ID)
+	}
+	mfaFailuresMu.Unlock()
+
+	if burned {
+		_ = RevokeAccessToken(claims.ID, claims.Expiry())
+	}
+	return burned
+}
+
+// how long the user has to wait before second factor codes are checked again, zero when allowed
+func MFARetryAfter(user models.User) time.Duration {
+	if user.MFALockedUntil == nil {
+		return 0
+	}
+	if wait := time.Until(*user.MFALockedUntil); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// count a wrong second factor against the account, kept in the database so issuing a new challenge or
+// reaching another instance does not reset it; returns true when this failure locked the account's codes
+func RecordUserMFAFailure(userID uint) (bool, error) {
+	var failures int
+	result := connector.DB.Raw("UPDATE users SET mfa_failures = mfa_failures + 1 WHERE id = ? RETURNING mfa_failures", userID).Scan(&failures)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if failures < MFAMaxFailures {
+		return false, nil
+	}
+
+	lock := connector.DB.Model(&models.User{}).Where("id = ?", userID).
+		Updates(map[string]interface{}{"mfa_failures": 0, "mfa_locked_until": time.Now().Add(MFALockoutPeriod)})
+	return true, lock.Error
+}
+
+// forget the account's wrong codes once a second factor has checked out
+func ClearMFAFailures(userID uint) error {
+	return connector.DB.Model(&models.User{}).
+		Where("id = ? AND (mfa_failures > 0 OR mfa_locked_until IS NOT NULL)", userID).
+		Updates(map[string]interface{}{"mfa_failures": 0, "mfa_locked_until": nil}).Error
+}
+
+// burn a challenge once it has been exchanged for a session
+func CompleteMFAChallenge(claims *JWTClaims) error {
+	mfaFailuresMu.Lock()
+	delete(mfaFailures, claims.ID)
+	mfaFailuresMu.Unlock()
+
+	return RevokeAccessToken(claims.ID, claims.Expiry())
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	auth_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/auth-utils"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxUserAgentLength = 500
+
+	deniedAuditWindow     = time.Minute
+	deniedAuditsPerIP     = 20
+	deniedAuditsPerWindow = 500
+)
+
+// ip and user agent of the request, the user agent is cut to what the db columns hold
+func Client(c *gin.Context) auth_utils.ClientInfo {
+	userAgent := c.Request.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	return auth_utils.ClientInfo{IP: c.ClientIP(), UserAgent: userAgent}
+}
+
+// record a security event for the request, a userID of zero means the account is unknown
+func Audit(c *gin.Context, eventType string, userID uint, outcome string, details map[string]interface{}) {
+	client := Client(c)
+	actorID := c.GetUint("impersonatorID")
+	auth_utils.RecordAuditEvent(models.AuditEvent{
+		EventType: eventType,
+		UserID:    &userID,
+		ActorID:   &actorID,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		Outcome:   outcome,
+	}, details)
+}
+
+// denied requests need no valid credentials, so their audit writes are capped per client ip and overall
+// to stop anyone from forcing an insert per request
+type deniedAuditLimiter struct {
+	mu          sync.Mutex
+	windowStart time.Time
+	total       int
+	perIP       map[string]int
+	suppressed  int
+}
+
+var deniedAudits = &deniedAuditLimiter{perIP: map[string]int{}}
+
+func (l *deniedAuditLimiter) allow(ip string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.windowStart) >= deniedAuditWindow {
+		if l.suppressed > 0 {
+			log.Printf("Skipped auditing %d denied requests over the rate limit\n", l.suppressed)
+		}
+		l.windowStart, l.total, l.suppressed = now, 0, 0
+		l.perIP = map[string]int{}
+	}
+
+	if l.total >= deniedAuditsPerWindow || l.perIP[ip] >= deniedAuditsPerIP {
+		l.suppressed++
+		return false
+	}
+	l.total++
+	l.perIP[ip]++
+	return true
+}
+
+// a request that failed authentication or authorization
+func auditDenied(c *gin.Context, userID uint, reason string) {
+	if !deniedAudits.allow(c.ClientIP(), time.Now()) {
+		return
+	}
+	Audit(c, auth_utils.AuditAccessDenied, userID, auth_utils.AuditOutcomeFailure, map[string]interface{}{
+		"reason": reason,
+		"method": c.Request.Method,
+		"path":   c.FullPath(),
+	})
+}
@@ -1,20 +1,53 @@
 package middleware
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
-	"os"
 	"strings"
 
 	auth_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/auth-utils"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// optional checks layered on top of token validation
+type Option func(*options)
+
+type options struct {
+	requireVerified bool
+	allowAPIKeys    bool
+}
+
+// reject accounts whose email address has not been confirmed yet
+func RequireVerifiedEmail() Option {
+	return func(o *options) {
+		o.requireVerified = true
+	}
+}
+
+// also accept an X-API-Key header in place of a bearer token, pair with RequireScope
+func AllowAPIKeys() Option {
+	return func(o *options) {
+		o.allowAPIKeys = true
+	}
+}
+
 // middleware function for handling jwt tokens in routes
-func JWTMiddleware() gin.HandlerFunc {
+func JWTMiddleware(opts ...Option) gin.HandlerFunc {
+	config := &options{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
+		if apiKey := c.GetHeader("X-API-Key"); authHeader == "" && apiKey != "" && config.allowAPIKeys {
+			apiKeyAuth(c, apiKey, config)
+			return
+		}
+
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
 			c.Abort()
@@ -28,38 +61,180 @@ func JWTMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		//err := godotenv.Load()
-		//if err != nil {
-		//	c.JSON(http.StatusInternalServerError, gin.H{"error": "Server configuration error"})
-		//	c.Abort()
-		//	return
-		//}
-		jwtKey := []byte(os.Getenv("JWT_KEY"))
-
 		claims := &auth_utils.JWTClaims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return jwtKey, nil
-		})
+		token, err := auth_utils.ParseToken(tokenString, claims)
 
 		if err != nil {
+			// expired tokens are routine, anything else points at a forged or mangled token
+			if !errors.Is(err, jwt.ErrTokenExpired) {
+				auditDenied(c, 0, "invalid_token")
+			}
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
 			c.Abort()
 			return
 		}
 
-		if !token.Valid {
+		if !token.Valid || claims.TokenUse != auth_utils.TokenUseAccess {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
 		}
 
-		c.Set("userEmail", claims.UserEmail)
+		if auth_utils.IsTokenRevoked(claims) {
+			auditDenied(c, 0, "revoked_token")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			c.Abort()
+			return
+		}
+
+		// looked up by id, the email claim goes stale once the address is changed or reused
+		var user models.User
+		userID := claims.UserID()
+		if userID == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			c.Abort()
+			return
+		}
+		result := connector.DB.First(&user, userID)
+		if result.Error != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			c.Abort()
+			return
+		}
+
+		if config.requireVerified && user.VerifiedAt == nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Email address has not been verified"})
+			c.Abort()
+			return
+		}
+
+		auth_utils.TouchSession(claims.SessionID, c.ClientIP())
+
+		c.Set("user", user)
+		c.Set("userID", user.ID)
+		c.Set("userEmail", user.EMAIL)
 		c.Set("dateTime", claims.DateTime)
+		c.Set("roles", claims.Roles)
 		c.Set("claims", claims)
 
+		if claims.Act != nil {
+			c.Set("impersonatorID", claims.ActorID())
+			c.Next()
+			// everything done while impersonating is recorded against both accounts
+			Audit(c, auth_utils.AuditImpersonatedRequest, user.ID, auth_utils.AuditOutcomeSuccess, map[string]interface{}{
+				"method": c.Request.Method,
+				"path":   c.FullPath(),
+				"status": c.Writer.Status(),
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// authenticate a request by api key, the key acts as its owner limited to the key's scopes
+func apiKeyAuth(c *gin.Context, apiKey string, config *options) {
+	key, user, err := auth_utils.AuthenticateAPIKey(apiKey)
+	if err != nil {
+		auditDenied(c, 0, "invalid_api_key")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired API key"})
+		c.Abort()
+		return
+	}
+
+	if config.requireVerified && user.VerifiedAt == nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Email address has not been verified"})
+		c.Abort()
+		return
+	}
+
+	roles, err := auth_utils.UserRoles(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load account roles"})
+		c.Abort()
+		return
+	}
+
+	c.Set("user", user)
+	c.Set("userID", user.ID)
+	c.Set("userEmail", user.EMAIL)
+	c.Set("roles", roles)
+	c.Set("apiKeyID", key.ID)
+	c.Set("apiKeyScopes", auth_utils.APIKeyScopes(key))
+
+	c.Next()
+}
+
+// claims of the bearer token, nil when the request was authenticated with an api key
+func CurrentClaims(c *gin.Context) *auth_utils.JWTClaims {
+	claims, ok := c.Get("claims")
+	if !ok {
+		return nil
+	}
+	return claims.(*auth_utils.JWTClaims)
+}
+
+// the account the request was authenticated as, only valid after JWTMiddleware
+func CurrentUser(c *gin.Context) models.User {
+	return c.MustGet("user").(models.User)
+}
+
+// id of the authenticated account, only valid after JWTMiddleware
+func CurrentUserID(c *gin.Context) uint {
+	return c.GetUint("userID")
+}
+
+// restricts a route to users holding at least one of the given roles, must run after JWTMiddleware
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !auth_utils.HasAnyRole(c.GetStringSlice("roles"), roles...) {
+			auditDenied(c, c.GetUint("userID"), "missing_role")
+			c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to access this resource"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// reject requests made with an impersonation token, for account changes support staff must never make
+func BlockImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if Impersonating(c) {
+			auditDenied(c, c.GetUint("userID"), "impersonation_blocked")
+			c.JSON(http.StatusForbidden, gin.H{"error": "This action is not available while impersonating a user"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// true when the request was made by an admin through an impersonation token
+func Impersonating(c *gin.Context) bool {
+	_, ok := c.Get("impersonatorID")
+	return ok
+}
+
+// api key requests must carry the scope, bearer token requests act with the user's full rights
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, viaAPIKey := c.Get("apiKeyID"); viaAPIKey {
+			allowed := false
+			for _, granted := range c.GetStringSlice("apiKeyScopes") {
+				allowed = allowed || granted == scope
+			}
+			if !allowed {
+				auditDenied(c, c.GetUint("userID"), "missing_scope")
+				c.JSON(http.StatusForbidden, gin.H{"error": "API key is missing the " + scope + " scope"})
+				c.Abort()
+				return
+			}
+		}
+
 		c.Next()
 	}
 }
@@ -2,6 +2,7 @@ package models
 
 import (
 	"encoding/json"
+	"errors"
 	"time"
 
 	"gorm.io/gorm"
@@ -9,9 +10,37 @@ import (
 
 type User struct {
 	gorm.Model
-	NAME     string `json:"name"`
-	EMAIL    string `json:"email"`
-	PASSWORD string `json:"password"`
+	NAME       string     `json:"name"`
+	EMAIL      string     `json:"email"`
+	PASSWORD   string     `json:"-"`
+	VerifiedAt *time.Time `json:"verified_at"`
+
+	// profile details, PendingEmail holds a requested new address until it has been confirmed
+	Phone             string `gorm:"size:20" json:"phone"`
+	AvatarURL         string `gorm:"size:500" json:"avatar_url"`
+	PreferredCurrency string `gorm:"size:3" json:"preferred_currency"`
+	Locale            string `gorm:"size:20" json:"locale"`
+	PendingEmail      string `json:"pending_email,omitempty"`
+
+	// set while the account is waiting out the grace period before its personal data is erased
+	DeletionRequestedAt *time.Time `json:"deletion_requested_at,omitempty"`
+
+	// totp secret is stored on enrollment, TOTPEnabledAt is only set once a code has been confirmed
+	// the secret is encrypted with TOTP_ENCRYPTION_KEY
+	TOTPSecret    string     `gorm:"size:128" json:"-"`
+	TOTPEnabledAt *time.Time `json:"totp_enabled_at"`
+	TOTPLastStep  int64      `json:"-"`
+	// wrong second factor codes across all challenges, MFALockedUntil is set once there are too many
+	MFAFailures    int        `gorm:"not null;default:0" json:"-"`
+	MFALockedUntil *time.Time `json:"-"`
+
+	Roles []UserRole `gorm:"foreignKey:UserID" json:"roles,omitempty"`
+}
+
+type UserRole struct {
+	gorm.Model
+	UserID uint   `gorm:"uniqueIndex:idx_user_role" json:"user_id"`
+	Role   string `gorm:"size:20;uniqueIndex:idx_user_role;not null" json:"role"`
 }
 
 type Preferences struct {
@@ -62,4 +91,169 @@ type Property struct {
 	UpdatedAt     time.Time       `json:"updated_at"`
 	LastScrapedAt time.Time       `json:"last_scraped_at"`
 	DeletedAt     gorm.DeletedAt  `gorm:"index" json:"-"`
+
+	// listings created through the api belong to a landlord or agent, scraped listings have no owner
+	OwnerID   *uint `gorm:"index" json:"owner_id"`
+	Published bool  `gorm:"not null;default:true;index" json:"published"`
+
+	// coordinates are nil until the listing has been geocoded, GeocodeFailedAt marks addresses the geocoder could not place
+	Latitude        *float64   `gorm:"index:idx_property_location" json:"latitude"`
+	Longitude       *float64   `gorm:"index:idx_property_location" json:"longitude"`
+	GeocodeFailedAt *time.Time `json:"-"`
+
+	Owner  *User           `gorm:"foreignKey:OwnerID" json:"-"`
+	Images []PropertyImage `gorm:"foreignKey:PropertyID" json:"images,omitempty"`
+
+	// catalogue entries the free form Amenities resolved to, this is what searches match on; AmenitiesLinkedAt is
+	// nil for listings the scraper wrote straight to the table until the amenity linker has resolved them
+	CatalogueAmenities []Amenity  `gorm:"many2many:property_amenities;" json:"catalogue_amenities,omitempty"`
+	AmenitiesLinkedAt  *time.Time `gorm:"index" json:"-"`
+}
+
+// an entry of the amenities catalogue, free form amenity names are matched to Key through the name and Synonyms
+type Amenity struct {
+	gorm.Model
+	Key      string          `gorm:"size:50;uniqueIndex;not null" json:"key"`
+	Name     string          `gorm:"size:100;not null" json:"name"`
+	Category string          `gorm:"size:50;index" json:"category"`
+	Synonyms json.RawMessage `gorm:"type:jsonb" json:"synonyms"`
+}
+
+// an uploaded listing photo, StorageKey is the prefix the original and its thumbnails are stored under
+type PropertyImage struct {
+	gorm.Model
+	PropertyID  uint            `gorm:"index" json:"property_id"`
+	Position    int             `json:"position"`
+	IsCover     bool            `json:"is_cover"`
+	ContentType string          `gorm:"size:50" json:"content_type"`
+	Width       int             `json:"width"`
+	Height      int             `json:"height"`
+	SizeBytes   int64           `json:"size_bytes"`
+	StorageKey  string          `gorm:"size:200;not null" json:"-"`
+	URL         string          `gorm:"size:1000" json:"url"`
+	Thumbnails  json.RawMessage `gorm:"type:jsonb" json:"thumbnails"`
+}
+
+// a login session, every refresh token rotated out of the same login shares it
+type Session struct {
+	gorm.Model
+	SessionID string     `gorm:"size:64;uniqueIndex;not null" json:"session_id"`
+	UserID    uint       `gorm:"index" json:"user_id"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	// the admin when the session backs an impersonation token, revoking either account's sessions ends it
+	ActorID uint `gorm:"index;not null;default:0" json:"-"`
+
+	// where the session was started from, IP and LastSeenAt follow the session as it is used
+	UserAgent  string     `gorm:"size:500" json:"user_agent"`
+	Device     string     `gorm:"size:100" json:"device"`
+	IP         string     `gorm:"size:45" json:"ip"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// refresh tokens are stored hashed, UsedAt is set once a token has been rotated
+type RefreshToken struct {
+	gorm.Model
+	SessionID uint       `gorm:"index" json:"session_id"`
+	TokenHash string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+
+	Session Session `gorm:"foreignKey:SessionID" json:"-"`
+}
+
+// revoked access tokens, Kind is "jti" for a single token or "session" for every token of a session
+type TokenRevocation struct {
+	gorm.Model
+	Kind      string    `gorm:"size:20;uniqueIndex:idx_revocation_kind_value;not null" json:"kind"`
+	Value     string    `gorm:"size:64;uniqueIndex:idx_revocation_kind_value;not null" json:"value"`
+	ExpiresAt time.Time `gorm:"index" json:"expires_at"`
+}
+
+// single use tokens handed out by email (password reset and the like), NonceHash ties a signed token to its row
+type UserToken struct {
+	gorm.Model
+	UserID    uint       `gorm:"index" json:"user_id"`
+	Purpose   string     `gorm:"size:50;index;not null" json:"purpose"`
+	NonceHash string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	// email change tokens only: the hash of the address the link was sent to and the session that asked
+	TargetHash string `gorm:"size:64" json:"-"`
+	SessionID  string `gorm:"size:64" json:"-"`
+
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// one time codes for getting past totp when the authenticator is lost, stored hashed
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint       `gorm:"index" json:"user_id"`
+	CodeHash string     `gorm:"size:64;not null" json:"-"`
+	UsedAt   *time.Time `json:"used_at"`
+
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// external accounts (google, microsoft, ...) linked to a user, Subject is the provider's stable user id
+type Identity struct {
+	gorm.Model
+	UserID   uint   `gorm:"index" json:"user_id"`
+	Provider string `gorm:"size:50;uniqueIndex:idx_identity_provider_subject;not null" json:"provider"`
+	Subject  string `gorm:"size:255;uniqueIndex:idx_identity_provider_subject;not null" json:"subject"`
+	Email    string `gorm:"size:255" json:"email"`
+
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// pending oidc authorization requests, removed once the callback consumes them
+type OAuthState struct {
+	gorm.Model
+	StateHash    string    `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	BindingHash  string    `gorm:"size:64;not null;default:''" json:"-"`
+	Provider     string    `gorm:"size:50;not null" json:"provider"`
+	Nonce        string    `gorm:"size:100;not null" json:"-"`
+	CodeVerifier string    `gorm:"size:100;not null" json:"-"`
+	ExpiresAt    time.Time `gorm:"index" json:"expires_at"`
+}
+
+// long lived keys for integrations, only the sha256 of the key is stored and Prefix lets users tell keys apart
+type APIKey struct {
+	gorm.Model
+	UserID     uint       `gorm:"index" json:"user_id"`
+	Name       string     `gorm:"size:100" json:"name"`
+	Prefix     string     `gorm:"size:20;index" json:"prefix"`
+	KeyHash    string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	Scopes     string     `gorm:"size:500" json:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+var ErrAuditAppendOnly = errors.New("audit events are append only")
+
+// security audit trail, rows are only ever inserted so there is no UpdatedAt or DeletedAt
+type AuditEvent struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+	EventType string    `gorm:"size:50;index;not null" json:"event_type"`
+	UserID    *uint     `gorm:"index" json:"user_id"`
+	// the admin behind the request when it was made with an impersonation token
+	ActorID   *uint           `gorm:"index" json:"actor_id,omitempty"`
+	IP        string          `gorm:"size:45" json:"ip"`
+	UserAgent string          `gorm:"size:500" json:"user_agent"`
+	Outcome   string          `gorm:"size:20;not null" json:"outcome"`
+	Details   json.RawMessage `gorm:"type:jsonb" json:"details,omitempty"`
+}
+
+func (e *AuditEvent) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAuditAppendOnly
+}
+
+func (e *AuditEvent) BeforeDelete(tx *gorm.DB) error {
+	return ErrAuditAppendOnly
 }
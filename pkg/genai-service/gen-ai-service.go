@@ -15,7 +15,7 @@ func GenAiClient(prompt string) (string, error) {
 	ctx := context.Background()
 	aiClient, err := genai.NewClient(ctx, nil)
 	if err != nil {
-		fmt.Printf("Gen Ai Setup Client Error: %v\n", err.Error)
+		fmt.Printf("Gen Ai Setup Client Error: %v\n", err)
 		return "", err
 	}
 
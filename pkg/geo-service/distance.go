@@ -0,0 +1,46 @@
+package geo_service
+
+import "math"
+
+const EarthRadiusKm = 6371.0
+
+// a map viewport, MinLng is greater than MaxLng when the box crosses the antimeridian
+type Bounds struct {
+	MinLat float64 `json:"min_lat"`
+	MinLng float64 `json:"min_lng"`
+	MaxLat float64 `json:"max_lat"`
+	MaxLng float64 `json:"max_lng"`
+}
+
+// smallest box holding every point within radiusKm of center, used to narrow a radius query before measuring
+func BoundsAround(center Point, radiusKm float64) Bounds {
+	latDelta := radiusKm / EarthRadiusKm * 180 / math.Pi
+	bounds := Bounds{
+		MinLat: math.Max(-90, center.Lat-latDelta),
+		MaxLat: math.Min(90, center.Lat+latDelta),
+		MinLng: -180,
+		MaxLng: 180,
+	}
+
+	// near the poles the circle wraps every longitude
+	if bounds.MinLat == -90 || bounds.MaxLat == 90 {
+		return bounds
+	}
+	lngDelta := math.Asin(math.Min(1, math.Sin(radiusKm/EarthRadiusKm)/math.Cos(center.Lat*math.Pi/180))) * 180 / math.Pi
+	if lngDelta >= 180 {
+		return bounds
+	}
+	bounds.MinLng = wrapLng(center.Lng - lngDelta)
+	bounds.MaxLng = wrapLng(center.Lng + lngDelta)
+	return bounds
+}
+
+func wrapLng(lng float64) float64 {
+	if lng < -180 {
+		return lng + 360
+	}
+	if lng > 180 {
+		return lng - 360
+	}
+	return lng
+}
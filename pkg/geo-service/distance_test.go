@@ -0,0 +1,114 @@
+package geo_service
+
+import (
+	"math"
+	"testing"
+)
+
+func radians(deg float64) float64 { return deg * math.Pi / 180 }
+func degrees(rad float64) float64 { return rad * 180 / math.Pi }
+
+// the point reached by travelling distanceKm from start along the bearing, in degrees clockwise from north
+func destination(start Point, bearing float64, distanceKm float64) Point {
+	lat1, lng1 := radians(start.Lat), radians(start.Lng)
+	angle := distanceKm / EarthRadiusKm
+	lat2 := math.Asin(math.Sin(lat1)*math.Cos(angle) + math.Cos(lat1)*math.Sin(angle)*math.Cos(radians(bearing)))
+	lng2 := lng1 + math.Atan2(math.Sin(radians(bearing))*math.Sin(angle)*math.Cos(lat1), math.Cos(angle)-math.Sin(lat1)*math.Sin(lat2))
+	return Point{Lat: degrees(lat2), Lng: wrapLng(degrees(lng2))}
+}
+
+// same test the property search runs in sql, MinLng > MaxLng meaning the box crosses the antimeridian
+func (b Bounds) contains(p Point) bool {
+	const slack = 1e-9
+	if p.Lat < b.MinLat-slack || p.Lat > b.MaxLat+slack {
+		return false
+	}
+	if b.MinLng > b.MaxLng {
+		return p.Lng >= b.MinLng-slack || p.Lng <= b.MaxLng+slack
+	}
+	return p.Lng >= b.MinLng-slack && p.Lng <= b.MaxLng+slack
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 0.01
+}
+
+func TestBoundsAround(t *testing.T) {
+	// one degree of latitude
+	degreeKm := EarthRadiusKm * math.Pi / 180
+
+	tests := []struct {
+		name     string
+		center   Point
+		radiusKm float64
+		want     Bounds
+		crosses  bool
+	}{
+		{name: "equator", center: Point{0, 30}, radiusKm: degreeKm, want: Bounds{MinLat: -1, MinLng: 29, MaxLat: 1, MaxLng: 31}},
+		{name: "southern hemisphere", center: Point{-17.8252, 31.0335}, radiusKm: 10, want: Bounds{MinLat: -17.915, MinLng: 30.939, MaxLat: -17.735, MaxLng: 31.128}},
+		{name: "east of the antimeridian", center: Point{-18, 179.5}, radiusKm: 200, want: Bounds{MinLat: -19.799, MinLng: 177.609, MaxLat: -16.201, MaxLng: -178.609}, crosses: true},
+		{name: "west of the antimeridian", center: Point{-13.8, -179.8}, radiusKm: 100, want: Bounds{MinLat: -14.699, MinLng: 179.273, MaxLat: -12.901, MaxLng: -178.873}, crosses: true},
+		{name: "on the antimeridian", center: Point{0, 180}, radiusKm: degreeKm, want: Bounds{MinLat: -1, MinLng: 179, MaxLat: 1, MaxLng: -179}, crosses: true},
+		{name: "reaching the north pole", center: Point{89.5, 10}, radiusKm: 100, want: Bounds{MinLat: 88.601, MinLng: -180, MaxLat: 90, MaxLng: 180}},
+		{name: "reaching the south pole", center: Point{-89, -120}, radiusKm: 200, want: Bounds{MinLat: -90, MinLng: -180, MaxLat: -87.201, MaxLng: 180}},
+		{name: "zero radius", center: Point{10, 20}, radiusKm: 0, want: Bounds{MinLat: 10, MinLng: 20, MaxLat: 10, MaxLng: 20}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := BoundsAround(tt.center, tt.radiusKm)
+			if !near(got.MinLat, tt.want.MinLat) || !near(got.MaxLat, tt.want.MaxLat) ||
+				!near(got.MinLng, tt.want.MinLng) || !near(got.MaxLng, tt.want.MaxLng) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if crosses := got.MinLng > got.MaxLng; crosses != tt.crosses {
+				t.Errorf("crosses the antimeridian = %v, want %v", crosses, tt.crosses)
+			}
+		})
+	}
+}
+
+// every point on the circle must fall inside the box, otherwise the radius search would miss it
+func TestBoundsAroundHoldsTheCircle(t *testing.T) {
+	centers := []Point{
+		{0, 0}, {51.5, -0.12}, {-33.9, 18.4}, {-17.8, 31.0},
+		{-18, 179.9}, {65, -179.99}, {0, 180}, {0, -180},
+		{85, 45}, {-85, -170}, {89.9, 0},
+	}
+	radii := []float64{0.5, 5, 50, 250, 500}
+
+	for _, center := range centers {
+		for _, radiusKm := range radii {
+			bounds := BoundsAround(center, radiusKm)
+			for bearing := 0.0; bearing < 360; bearing += 7.5 {
+				point := destination(center, bearing, radiusKm)
+				if !bounds.contains(point) {
+					t.Fatalf("%+v is %.1fkm from %+v but outside %+v", point, radiusKm, center, bounds)
+				}
+			}
+			// the opposite side of the world never is
+			if radiusKm < 1000 && bounds.contains(Point{Lat: -center.Lat, Lng: wrapLng(center.Lng + 180)}) && math.Abs(center.Lat) < 80 {
+				t.Errorf("bounds %+v around %+v hold the antipode", bounds, center)
+			}
+		}
+	}
+}
+
+func TestWrapLng(t *testing.T) {
+	tests := []struct {
+		in, want float64
+	}{
+		{0, 0},
+		{180, 180},
+		{-180, -180},
+		{181.5, -178.5},
+		{-181.5, 178.5},
+		{359, -1},
+		{-359, 1},
+	}
+	for _, tt := range tests {
+		if got := wrapLng(tt.in); !near(got, tt.want) {
+			t.Errorf("wrapLng(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
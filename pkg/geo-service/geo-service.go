@@ -0,0 +1,129 @@
+package geo_service
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ErrLocationNotFound = errors.New("location could not be geocoded")
+
+type Point struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// anything that can turn an address into coordinates
+type Geocoder interface {
+	Geocode(address string, city string) (Point, error)
+}
+
+// never finds anything, used when no geocoder could be set up
+type NoopGeocoder struct{}
+
+func (NoopGeocoder) Geocode(address string, city string) (Point, error) {
+	return Point{}, ErrLocationNotFound
+}
+
+// offline geocoder backed by a csv of address,city,latitude,longitude rows, a row with an empty
+// address gives the centre of the city and is used when the exact address is not listed
+type CSVGeocoder struct {
+	addresses map[string]Point
+	cities    map[string]Point
+}
+
+func normalizePlace(value string) string {
+	return strings.ToLower(strings.Join(strings.Fields(strings.ReplaceAll(value, ",", " ")), " "))
+}
+
+func LoadCSVGeocoder(path string) (*CSVGeocoder, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	geocoder := &CSVGeocoder{addresses: map[string]Point{}, cities: map[string]Point{}}
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 4
+	reader.TrimLeadingSpace = true
+
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		lat, latErr := strconv.ParseFloat(record[2], 64)
+		lng, lngErr := strconv.ParseFloat(record[3], 64)
+		if latErr != nil || lngErr != nil {
+			// a header row is allowed, anything else unreadable is a broken file
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("line %d of %s has invalid coordinates", line, path)
+		}
+		if lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+			return nil, fmt.Errorf("line %d of %s has coordinates out of range", line, path)
+		}
+
+		address, city := normalizePlace(record[0]), normalizePlace(record[1])
+		if city == "" {
+			return nil, fmt.Errorf("line %d of %s has no city", line, path)
+		}
+		if address == "" {
+			geocoder.cities[city] = Point{Lat: lat, Lng: lng}
+		} else {
+			geocoder.addresses[address+"|"+city] = Point{Lat: lat, Lng: lng}
+		}
+	}
+	return geocoder, nil
+}
+
+func (g *CSVGeocoder) Geocode(address string, city string) (Point, error) {
+	city = normalizePlace(city)
+	if point, ok := g.addresses[normalizePlace(address)+"|"+city]; ok {
+		return point, nil
+	}
+	if point, ok := g.cities[city]; ok {
+		return point, nil
+	}
+	return Point{}, ErrLocationNotFound
+}
+
+var (
+	geocoder     Geocoder
+	geocoderOnce sync.Once
+)
+
+// geocoder picked by the GEOCODER env var, "csv" (the default) reads GEOCODER_CSV
+func GetGeocoder() Geocoder {
+	geocoderOnce.Do(func() {
+		switch os.Getenv("GEOCODER") {
+		case "none":
+			geocoder = NoopGeocoder{}
+		default:
+			path := os.Getenv("GEOCODER_CSV")
+			if path == "" {
+				path = "geocoder.csv"
+			}
+			csvGeocoder, err := LoadCSVGeocoder(path)
+			if err != nil {
+				log.Printf("Error occurred trying to load geocoder csv, listings will not be geocoded:\n %v", err)
+				geocoder = NoopGeocoder{}
+				return
+			}
+			geocoder = csvGeocoder
+		}
+	})
+	return geocoder
+}
@@ -0,0 +1,106 @@
+package geo_service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeCSV(t *testing.T, rows ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "geocoder.csv")
+	if err := os.WriteFile(path, []byte(strings.Join(rows, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCSVGeocoderGeocode(t *testing.T) {
+	geocoder, err := LoadCSVGeocoder(writeCSV(t,
+		"address,city,latitude,longitude",
+		`12 Samora Machel Ave,Harare,-17.8292,31.0522`,
+		`"4 Borrowdale Rd, Borrowdale",Harare,-17.7800,31.0900`,
+		`,Harare,-17.8252,31.0335`,
+		`,  Bulawayo ,-20.1325,28.6265`,
+		`1 Main St,Bulawayo,-20.1500,28.5800`,
+	))
+	if err != nil {
+		t.Fatalf("LoadCSVGeocoder: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		address string
+		city    string
+		want    Point
+		wantErr error
+	}{
+		{name: "exact address", address: "12 Samora Machel Ave", city: "Harare", want: Point{Lat: -17.8292, Lng: 31.0522}},
+		{name: "case and spacing", address: "  12 samora  MACHEL ave ", city: "HARARE", want: Point{Lat: -17.8292, Lng: 31.0522}},
+		{name: "commas are spacing", address: "4 Borrowdale Rd Borrowdale", city: "harare", want: Point{Lat: -17.78, Lng: 31.09}},
+		{name: "unknown address falls back to the city", address: "99 Nowhere St", city: "Harare", want: Point{Lat: -17.8252, Lng: 31.0335}},
+		{name: "empty address gives the city", address: "", city: "bulawayo", want: Point{Lat: -20.1325, Lng: 28.6265}},
+		{name: "address is matched within its city", address: "1 Main St", city: "Harare", want: Point{Lat: -17.8252, Lng: 31.0335}},
+		{name: "address in another city", address: "1 Main St", city: "Bulawayo", want: Point{Lat: -20.15, Lng: 28.58}},
+		{name: "unknown city", address: "12 Samora Machel Ave", city: "Mutare", wantErr: ErrLocationNotFound},
+		{name: "no city", address: "12 Samora Machel Ave", city: "", wantErr: ErrLocationNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := geocoder.Geocode(tt.address, tt.city)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadCSVGeocoderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		rows    []string
+		wantErr string
+	}{
+		{name: "bad coordinates after the header", rows: []string{"a,b,lat,lng", "1 Main St,Harare,north,31"}, wantErr: "line 2"},
+		{name: "only the first line may be a header", rows: []string{"1 Main St,Harare,-17.8,31.0", "a,b,lat,lng"}, wantErr: "line 2"},
+		{name: "latitude out of range", rows: []string{"1 Main St,Harare,-91,31.0"}, wantErr: "out of range"},
+		{name: "longitude out of range", rows: []string{"1 Main St,Harare,-17.8,180.5"}, wantErr: "out of range"},
+		{name: "missing city", rows: []string{"1 Main St, ,-17.8,31.0"}, wantErr: "no city"},
+		{name: "wrong number of fields", rows: []string{"1 Main St,Harare,-17.8"}, wantErr: "wrong number of fields"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadCSVGeocoder(writeCSV(t, tt.rows...))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := LoadCSVGeocoder(filepath.Join(t.TempDir(), "missing.csv")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file returned %v", err)
+	}
+}
+
+func TestCSVGeocoderEdgeCoordinates(t *testing.T) {
+	geocoder, err := LoadCSVGeocoder(writeCSV(t,
+		",Suva,-18.1416,178.4419",
+		",Pole,90,180",
+		",Apia,-13.8333,-180",
+	))
+	if err != nil {
+		t.Fatalf("LoadCSVGeocoder: %v", err)
+	}
+	for city, want := range map[string]Point{"suva": {-18.1416, 178.4419}, "pole": {90, 180}, "apia": {-13.8333, -180}} {
+		if got, err := geocoder.Geocode("", city); err != nil || got != want {
+			t.Errorf("Geocode(%q) = %+v, %v, want %+v", city, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package mail_service
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// anything that can deliver a plain text email
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// writes emails to the application log, the default for local development
+type LogMailer struct{}
+
+func (LogMailer) Send(to string, subject string, body string) error {
+	log.Printf("Mail to %s\nSubject: %s\n\n%s\n", to, subject, body)
+	return nil
+}
+
+// drops each email into a directory as a .eml file so it can be opened by hand
+type FileMailer struct {
+	Dir string
+}
+
+func (m FileMailer) Send(to string, subject string, body string) error {
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create mail directory: %w", err)
+	}
+
+	recipient := strings.NewReplacer("@", "_at_", "/", "_", "\\", "_").Replace(to)
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102150405.000000000"), recipient)
+
+	var message strings.Builder
+	message.WriteString("To: " + to + "\r\n")
+	message.WriteString("Subject: " + subject + "\r\n")
+	message.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	message.WriteString(body)
+
+	if err := os.WriteFile(filepath.Join(m.Dir, name), []byte(message.String()), 0o600); err != nil {
+		return fmt.Errorf("failed to write mail file: %w", err)
+	}
+	return nil
+}
+
+var (
+	mailer     Mailer
+	mailerLock sync.RWMutex
+)
+
+// mailer picked by the MAILER env var ("log" or "file"), can be swapped with SetMailer
+func GetMailer() Mailer {
+	mailerLock.RLock()
+	current := mailer
+	mailerLock.RUnlock()
+	if current != nil {
+		return current
+	}
+
+	mailerLock.Lock()
+	defer mailerLock.Unlock()
+	if mailer == nil {
+		switch os.Getenv("MAILER") {
+		case "file":
+			dir := os.Getenv("MAIL_DIR")
+			if dir == "" {
+				dir = "mail-outbox"
+			}
+			mailer = FileMailer{Dir: dir}
+		default:
+			mailer = LogMailer{}
+		}
+	}
+	return mailer
+}
+
+// replace the configured mailer, e.g. with a real provider; safe to call while requests are being served
+func SetMailer(m Mailer) {
+	mailerLock.Lock()
+	mailer = m
+	mailerLock.Unlock()
+}
+
+// absolute link into the client app for emailed tokens
+func AppLink(path string, token string) string {
+	base := strings.TrimRight(os.Getenv("APP_BASE_URL"), "/")
+	if base == "" {
+		base = "http://localhost:8090"
+	}
+	return fmt.Sprintf("%s/%s?token=%s", base, strings.TrimLeft(path, "/"), url.QueryEscape(token))
+}
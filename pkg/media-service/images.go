@@ -0,0 +1,163 @@
+package media_service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+const (
+	MaxImageBytes = 10 << 20
+	// larger images are refused before decoding so a small file cannot expand into gigabytes of pixels
+	MaxImagePixels = 40_000_000
+
+	thumbnailQuality = 82
+)
+
+// longest edge of each generated thumbnail
+var ThumbnailSizes = []int{160, 480, 1024}
+
+var (
+	ErrUnsupportedImage = errors.New("image must be a jpeg, png or webp")
+	ErrImageTooLarge    = errors.New("image is too large")
+)
+
+// file extension for each accepted type, the type is sniffed from the bytes rather than trusted from the upload
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// an upload that has been checked and resized, thumbnails are jpegs keyed by their size
+type ProcessedImage struct {
+	ContentType string
+	Extension   string
+	Width       int
+	Height      int
+	Original    []byte
+	Thumbnails  map[int][]byte
+}
+
+func decodeImage(data []byte, contentType string) (image.Image, error) {
+	switch contentType {
+	case "image/jpeg":
+		return jpeg.Decode(bytes.NewReader(data))
+	case "image/png":
+		return png.Decode(bytes.NewReader(data))
+	case "image/webp":
+		return webp.Decode(bytes.NewReader(data))
+	}
+	return nil, ErrUnsupportedImage
+}
+
+func decodeImageConfig(data []byte, contentType string) (image.Config, error) {
+	switch contentType {
+	case "image/jpeg":
+		return jpeg.DecodeConfig(bytes.NewReader(data))
+	case "image/png":
+		return png.DecodeConfig(bytes.NewReader(data))
+	case "image/webp":
+		return webp.DecodeConfig(bytes.NewReader(data))
+	}
+	return image.Config{}, ErrUnsupportedImage
+}
+
+// scale so the longest edge is at most size, never enlarging; transparent areas become white since thumbnails are jpeg
+func thumbnail(src image.Image, size int) ([]byte, error) {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, max(1, height*size/bounds.Dx())
+		} else {
+			width, height = max(1, width*size/bounds.Dy()), size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// check an uploaded image, strip its metadata and build its thumbnails
+func ProcessImage(data []byte) (ProcessedImage, error) {
+	if len(data) > MaxImageBytes {
+		return ProcessedImage{}, ErrImageTooLarge
+	}
+
+	contentType := http.DetectContentType(data)
+	extension, ok := imageExtensions[contentType]
+	if !ok {
+		return ProcessedImage{}, ErrUnsupportedImage
+	}
+
+	config, err := decodeImageConfig(data, contentType)
+	if err != nil {
+		return ProcessedImage{}, ErrUnsupportedImage
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return ProcessedImage{}, ErrUnsupportedImage
+	}
+	if config.Width*config.Height > MaxImagePixels {
+		return ProcessedImage{}, ErrImageTooLarge
+	}
+
+	img, err := decodeImage(data, contentType)
+	if err != nil {
+		return ProcessedImage{}, ErrUnsupportedImage
+	}
+
+	original, err := stripMetadata(data, contentType)
+	if err != nil {
+		return ProcessedImage{}, ErrUnsupportedImage
+	}
+
+	processed := ProcessedImage{
+		ContentType: contentType,
+		Extension:   extension,
+		Width:       config.Width,
+		Height:      config.Height,
+		Original:    original,
+		Thumbnails:  map[int][]byte{},
+	}
+	for _, size := range ThumbnailSizes {
+		thumb, err := thumbnail(img, size)
+		if err != nil {
+			return ProcessedImage{}, fmt.Errorf("failed to build %dpx thumbnail: %w", size, err)
+		}
+		processed.Thumbnails[size] = thumb
+	}
+	return processed, nil
+}
+
+// extension the original of an image with this content type is stored under
+func ExtensionFor(contentType string) string {
+	if extension, ok := imageExtensions[contentType]; ok {
+		return extension
+	}
+	return ".jpg"
+}
+
+// storage keys of an uploaded image, everything for one upload lives under the same prefix
+func OriginalKey(prefix string, extension string) string {
+	return prefix + "/original" + extension
+}
+
+func ThumbnailKey(prefix string, size int) string {
+	return fmt.Sprintf("%s/%d.jpg", prefix, size)
+}
@@ -0,0 +1,128 @@
+package media_service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// somewhere uploaded files can be kept and served from
+type Storage interface {
+	Put(key string, data []byte, contentType string) error
+	Delete(key string) error
+	// public url of a stored object
+	URL(key string) string
+}
+
+// keeps files on local disk, they are served by the api under URLPrefix
+type LocalStorage struct {
+	Dir       string
+	URLPrefix string
+}
+
+// keys are slash separated paths below the storage root, anything that could climb out of it is refused
+func cleanKey(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", ErrInvalidKey
+		}
+	}
+	return key, nil
+}
+
+func (s LocalStorage) Put(key string, data []byte, contentType string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.Dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create media directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write media file: %w", err)
+	}
+	return nil
+}
+
+func (s LocalStorage) Delete(key string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(s.Dir, filepath.FromSlash(key))); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete media file: %w", err)
+	}
+	return nil
+}
+
+func (s LocalStorage) URL(key string) string {
+	return strings.TrimRight(s.URLPrefix, "/") + "/" + key
+}
+
+// random path segment so uploads never overwrite each other and their urls cannot be guessed
+func NewObjectID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+var (
+	storage     Storage
+	storageLock sync.RWMutex
+)
+
+func envDefault(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// storage picked by the MEDIA_STORAGE env var ("local" or "s3"), can be swapped with SetStorage
+func GetStorage() Storage {
+	storageLock.RLock()
+	current := storage
+	storageLock.RUnlock()
+	if current != nil {
+		return current
+	}
+
+	storageLock.Lock()
+	defer storageLock.Unlock()
+	if storage == nil {
+		switch os.Getenv("MEDIA_STORAGE") {
+		case "s3":
+			storage = &S3Storage{
+				Endpoint:  envDefault("S3_ENDPOINT", "https://s3.amazonaws.com"),
+				Region:    envDefault("S3_REGION", "us-east-1"),
+				Bucket:    os.Getenv("S3_BUCKET"),
+				AccessKey: os.Getenv("S3_ACCESS_KEY"),
+				SecretKey: os.Getenv("S3_SECRET_KEY"),
+				PublicURL: os.Getenv("S3_PUBLIC_URL"),
+			}
+		default:
+			storage = LocalStorage{Dir: envDefault("MEDIA_DIR", "media"), URLPrefix: envDefault("MEDIA_URL_PREFIX", "/media")}
+		}
+	}
+	return storage
+}
+
+// replace the configured storage, e.g. with a different bucket; safe to call while requests are being served
+func SetStorage(s Storage) {
+	storageLock.Lock()
+	storage = s
+	storageLock.Unlock()
+}
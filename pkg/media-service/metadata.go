@@ -0,0 +1,264 @@
+package media_service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var errMalformedImage = errors.New("malformed image structure")
+
+const exifOrientationTag = 0x0112
+
+// originals are kept byte for byte apart from their metadata, phone photos carry gps coordinates, device details
+// and capture times in exif/xmp which must not end up on a public url
+func stripMetadata(data []byte, contentType string) ([]byte, error) {
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEGMetadata(data)
+	case "image/png":
+		return stripPNGMetadata(data)
+	case "image/webp":
+		return stripWebPMetadata(data)
+	}
+	return nil, ErrUnsupportedImage
+}
+
+// keeps the segments that affect how the image is decoded (jfif, icc profile, adobe colour transform) and drops
+// every other app segment and comment along with anything after the end of the image, phones append trailers
+// there; the exif orientation is carried over on its own so photos stay upright
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errMalformedImage
+	}
+
+	var out bytes.Buffer
+	out.Write(data[:2])
+
+	// segments ahead of the first scan are held back so the orientation can be placed after the jfif header
+	var jfif, kept [][]byte
+	orientation := 0
+	scanning := false
+
+	pos := 2
+	for {
+		// markers may be preceded by any number of 0xff fill bytes
+		for pos+1 < len(data) && data[pos] == 0xFF && data[pos+1] == 0xFF {
+			pos++
+		}
+		if pos+2 > len(data) || data[pos] != 0xFF {
+			return nil, errMalformedImage
+		}
+		marker := data[pos+1]
+		if marker == 0xD9 {
+			if !scanning {
+				return nil, errMalformedImage
+			}
+			out.Write(data[pos : pos+2])
+			return out.Bytes(), nil
+		}
+
+		if pos+4 > len(data) {
+			return nil, errMalformedImage
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil, errMalformedImage
+		}
+		segment := data[pos : pos+2+length]
+		payload := segment[4:]
+		pos += 2 + length
+
+		if marker == 0xDA && !scanning {
+			scanning = true
+			for _, s := range jfif {
+				out.Write(s)
+			}
+			if orientation > 1 {
+				out.Write(orientationSegment(orientation))
+			}
+			for _, s := range kept {
+				out.Write(s)
+			}
+		}
+
+		switch {
+		case marker == 0xE0 && !scanning:
+			jfif = append(jfif, segment)
+		case marker == 0xE1 && !scanning:
+			if bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+				orientation = exifOrientation(payload[6:])
+			}
+		case marker == 0xE2 && bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00")), marker == 0xEE:
+			if scanning {
+				out.Write(segment)
+			} else {
+				kept = append(kept, segment)
+			}
+		case marker >= 0xE0 && marker <= 0xEF, marker == 0xFE:
+			// other app segments and comments
+		case scanning:
+			out.Write(segment)
+		default:
+			kept = append(kept, segment)
+		}
+
+		// compressed data follows a scan header up to the next marker, a 0xff inside it is always followed by
+		// a zero byte or a restart marker
+		if marker == 0xDA {
+			start := pos
+			for pos+1 < len(data) && (data[pos] != 0xFF || data[pos+1] == 0x00 || data[pos+1] >= 0xD0 && data[pos+1] <= 0xD7) {
+				if data[pos] == 0xFF {
+					pos++
+				}
+				pos++
+			}
+			out.Write(data[start:pos])
+		}
+	}
+}
+
+// orientation value from the first ifd of an exif tiff block, 0 when it is missing or unreadable
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[offset : offset+2]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:entry+2]) != exifOrientationTag {
+			continue
+		}
+		// a single short stored inline
+		if order.Uint16(tiff[entry+2:entry+4]) != 3 {
+			return 0
+		}
+		value := int(order.Uint16(tiff[entry+8 : entry+10]))
+		if value < 1 || value > 8 {
+			return 0
+		}
+		return value
+	}
+	return 0
+}
+
+// an app1 segment holding nothing but the orientation tag
+func orientationSegment(orientation int) []byte {
+	tiff := []byte{
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08,
+		0x00, 0x01,
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, byte(orientation), 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+
+	segment := []byte{0xFF, 0xE1, 0x00, 0x00}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// png ancillary chunks that only carry text, exif or timestamps
+var pngMetadataChunks = map[string]bool{
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"eXIf": true,
+	"tIME": true,
+}
+
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(signature)) {
+		return nil, errMalformedImage
+	}
+
+	var out bytes.Buffer
+	out.WriteString(signature)
+	pos := len(signature)
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return nil, errMalformedImage
+		}
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		end := pos + 12 + length
+		if end > len(data) {
+			return nil, errMalformedImage
+		}
+		chunkType := string(data[pos+4 : pos+8])
+		if !pngMetadataChunks[chunkType] {
+			out.Write(data[pos:end])
+		}
+		// anything after the end chunk is not part of the image, phones and editors append trailers there
+		if chunkType == "IEND" {
+			break
+		}
+		pos = end
+	}
+	return out.Bytes(), nil
+}
+
+// vp8x feature flags for the chunks removed below
+const (
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+func stripWebPMetadata(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errMalformedImage
+	}
+
+	// anything after the riff container is not part of the image
+	size := min(8+int(binary.LittleEndian.Uint32(data[4:8])), len(data))
+
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	pos := 12
+	for pos < size {
+		if pos+8 > size {
+			return nil, errMalformedImage
+		}
+		fourCC := string(data[pos : pos+4])
+		length := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		if pos+8+length > size {
+			return nil, errMalformedImage
+		}
+		// chunks are padded to an even size
+		end := min(pos+8+length+length%2, size)
+
+		switch fourCC {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte(nil), data[pos:end]...)
+			if length > 0 {
+				chunk[8] &^= webpFlagEXIF | webpFlagXMP
+			}
+			body.Write(chunk)
+		default:
+			body.Write(data[pos:end])
+		}
+		pos = end
+	}
+
+	out := make([]byte, 8, 8+body.Len())
+	copy(out, "RIFF")
+	binary.LittleEndian.PutUint32(out[4:8], uint32(body.Len()))
+	return append(out, body.Bytes()...), nil
+}
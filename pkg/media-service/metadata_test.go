@@ -0,0 +1,285 @@
+package media_service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// a gps tag and a camera comment, neither may survive into the stored original
+const secretMarker = "GPS 51.5007N 0.1246W"
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y * 5), B: 120, A: 255})
+		}
+	}
+	return img
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// a little endian exif block with the orientation followed by a gps ifd pointer and some text
+func exifPayload(orientation uint16) []byte {
+	tiff := []byte{'I', 'I', 0x2A, 0x00, 0x08, 0x00, 0x00, 0x00}
+	tiff = binary.LittleEndian.AppendUint16(tiff, 2)
+	// orientation, short, count 1
+	tiff = binary.LittleEndian.AppendUint16(tiff, 0x0112)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 3)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0)
+	// gps ifd pointer, long, count 1
+	tiff = binary.LittleEndian.AppendUint16(tiff, 0x8825)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 4)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 38)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 0)
+	tiff = append(tiff, secretMarker...)
+	return append([]byte("Exif\x00\x00"), tiff...)
+}
+
+// a jpeg as a phone would upload it: jfif, exif with gps, xmp and a comment ahead of the image data and a
+// trailer after it
+func phoneJPEG(t *testing.T, orientation uint16) []byte {
+	t.Helper()
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, testImage(), &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	data := encoded.Bytes()
+
+	var out bytes.Buffer
+	out.Write(data[:2])
+	out.Write(jpegSegment(0xE0, []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00")))
+	out.Write(jpegSegment(0xE1, exifPayload(orientation)))
+	out.Write(jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta>"+secretMarker+"</x:xmpmeta>")))
+	out.Write(jpegSegment(0xE2, []byte("ICC_PROFILE\x00\x01\x01profile")))
+	out.Write(jpegSegment(0xED, []byte("Photoshop 3.0\x00"+secretMarker)))
+	out.Write(jpegSegment(0xFE, []byte(secretMarker)))
+	out.Write(data[2:])
+	out.WriteString("MotionPhoto_Data " + secretMarker)
+	return out.Bytes()
+}
+
+func pngChunk(kind string, payload []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	chunk = append(chunk, kind...)
+	chunk = append(chunk, payload...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func phonePNG(t *testing.T) []byte {
+	t.Helper()
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	data := encoded.Bytes()
+
+	// signature and ihdr come first, the metadata chunks go straight after them
+	headerEnd := 8 + 12 + int(binary.BigEndian.Uint32(data[8:12]))
+	var out bytes.Buffer
+	out.Write(data[:headerEnd])
+	out.Write(pngChunk("tEXt", []byte("Comment\x00"+secretMarker)))
+	out.Write(pngChunk("eXIf", exifPayload(1)[6:]))
+	out.Write(pngChunk("tIME", []byte{0x07, 0xEA, 1, 2, 3, 4, 5}))
+	out.Write(pngChunk("gAMA", []byte{0, 0, 0xB1, 0x8F}))
+	out.Write(data[headerEnd:])
+	return out.Bytes()
+}
+
+func riffChunk(fourCC string, payload []byte) []byte {
+	chunk := append([]byte(fourCC), binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))...)
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func riff(chunks ...[]byte) []byte {
+	body := []byte("WEBP")
+	for _, chunk := range chunks {
+		body = append(body, chunk...)
+	}
+	return append(append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...), body...)
+}
+
+func TestProcessImageStripsJPEGMetadata(t *testing.T) {
+	tests := []struct {
+		name            string
+		orientation     uint16
+		wantOrientation int
+	}{
+		{name: "upright", orientation: 1, wantOrientation: 0},
+		{name: "rotated", orientation: 6, wantOrientation: 6},
+		{name: "mirrored", orientation: 2, wantOrientation: 2},
+		{name: "invalid orientation", orientation: 42, wantOrientation: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upload := phoneJPEG(t, tt.orientation)
+			processed, err := ProcessImage(upload)
+			if err != nil {
+				t.Fatalf("ProcessImage: %v", err)
+			}
+			original := processed.Original
+
+			if bytes.Contains(original, []byte(secretMarker)) {
+				t.Fatal("stored original still carries the metadata")
+			}
+			if bytes.Contains(original, []byte("http://ns.adobe.com/xap")) {
+				t.Error("xmp segment was kept")
+			}
+			if !bytes.Contains(original, []byte("ICC_PROFILE\x00")) {
+				t.Error("icc profile was dropped")
+			}
+			if !bytes.Contains(original, []byte("JFIF\x00")) {
+				t.Error("jfif header was dropped")
+			}
+
+			orientation := 0
+			if i := bytes.Index(original, []byte("Exif\x00\x00")); i >= 0 {
+				orientation = exifOrientation(original[i+6:])
+			}
+			if orientation != tt.wantOrientation {
+				t.Errorf("orientation %d, want %d", orientation, tt.wantOrientation)
+			}
+
+			// the compressed image data is copied as is
+			scan := bytes.Index(upload, []byte{0xFF, 0xDA})
+			end := bytes.Index(upload, []byte("MotionPhoto_Data"))
+			if !bytes.HasSuffix(original, upload[scan:end]) {
+				t.Error("image data was altered")
+			}
+			if _, err := jpeg.Decode(bytes.NewReader(original)); err != nil {
+				t.Errorf("stored original no longer decodes: %v", err)
+			}
+		})
+	}
+}
+
+func TestProcessImageStripsPNGMetadata(t *testing.T) {
+	processed, err := ProcessImage(phonePNG(t))
+	if err != nil {
+		t.Fatalf("ProcessImage: %v", err)
+	}
+	original := processed.Original
+
+	for _, kind := range []string{"tEXt", "eXIf", "tIME"} {
+		if bytes.Contains(original, []byte(kind)) {
+			t.Errorf("%s chunk was kept", kind)
+		}
+	}
+	if bytes.Contains(original, []byte(secretMarker)) {
+		t.Fatal("stored original still carries the metadata")
+	}
+	if !bytes.Contains(original, []byte("gAMA")) {
+		t.Error("gamma chunk was dropped")
+	}
+
+	decoded, err := png.Decode(bytes.NewReader(original))
+	if err != nil {
+		t.Fatalf("stored original no longer decodes: %v", err)
+	}
+	want := testImage()
+	for _, p := range []image.Point{{0, 0}, {10, 20}, {63, 47}} {
+		if !sameColor(decoded.At(p.X, p.Y), want.At(p.X, p.Y)) {
+			t.Errorf("pixel at %v changed", p)
+		}
+	}
+}
+
+// bytes after the end chunk are dropped rather than refused, as they are after a jpeg's end of image
+func TestStripPNGMetadataTrailer(t *testing.T) {
+	upload := phonePNG(t)
+	stripped, err := stripPNGMetadata(upload)
+	if err != nil {
+		t.Fatalf("stripPNGMetadata: %v", err)
+	}
+
+	for _, trailer := range []string{"trailing " + secretMarker, "\x00", "\x00\x00\x00\x00tEXtcut"} {
+		withTrailer := append(append([]byte(nil), upload...), trailer...)
+		got, err := stripPNGMetadata(withTrailer)
+		if err != nil {
+			t.Fatalf("trailer %q: %v", trailer, err)
+		}
+		if !bytes.Equal(got, stripped) {
+			t.Errorf("trailer %q was kept", trailer)
+		}
+	}
+}
+
+func sameColor(a, b color.Color) bool {
+	ar, ag, ab, aa := a.RGBA()
+	br, bg, bb, ba := b.RGBA()
+	return ar == br && ag == bg && ab == bb && aa == ba
+}
+
+func TestStripWebPMetadata(t *testing.T) {
+	vp8x := []byte{webpFlagEXIF | webpFlagXMP | 0x10, 0, 0, 0, 63, 0, 0, 47, 0, 0}
+	imageData := []byte("VP8L image data")
+	upload := riff(
+		riffChunk("VP8X", vp8x),
+		riffChunk("ICCP", []byte("profile")),
+		riffChunk("VP8L", imageData),
+		riffChunk("EXIF", exifPayload(6)[6:]),
+		riffChunk("XMP ", []byte("<x:xmpmeta>"+secretMarker+"</x:xmpmeta>")),
+	)
+	// trailing bytes after the container are dropped along with the metadata
+	upload = append(upload, []byte("trailing "+secretMarker)...)
+
+	stripped, err := stripWebPMetadata(upload)
+	if err != nil {
+		t.Fatalf("stripWebPMetadata: %v", err)
+	}
+
+	wantVP8X := append([]byte(nil), vp8x...)
+	wantVP8X[0] = 0x10
+	want := riff(riffChunk("VP8X", wantVP8X), riffChunk("ICCP", []byte("profile")), riffChunk("VP8L", imageData))
+	if !bytes.Equal(stripped, want) {
+		t.Fatalf("stripped to\n%q\nwant\n%q", stripped, want)
+	}
+}
+
+func TestStripMetadataMalformed(t *testing.T) {
+	jpegData := phoneJPEG(t, 1)
+	pngData := phonePNG(t)
+	webpData := riff(riffChunk("VP8L", []byte("data")))
+
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+	}{
+		{name: "jpeg without soi", data: jpegData[2:], contentType: "image/jpeg"},
+		{name: "jpeg cut inside a segment", data: jpegData[:30], contentType: "image/jpeg"},
+		{name: "jpeg without image data", data: jpegData[:bytes.Index(jpegData, []byte{0xFF, 0xDA})], contentType: "image/jpeg"},
+		{name: "png without signature", data: pngData[8:], contentType: "image/png"},
+		{name: "png cut inside a chunk", data: pngData[:len(pngData)-5], contentType: "image/png"},
+		{name: "webp chunk longer than the file", data: webpData[:len(webpData)-2], contentType: "image/webp"},
+		{name: "not riff", data: append([]byte("RIFX"), webpData[4:]...), contentType: "image/webp"},
+		{name: "gif", data: []byte("GIF89a"), contentType: "image/gif"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := stripMetadata(tt.data, tt.contentType); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package media_service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	fakeS3Region    = "af-south-1"
+	fakeS3Bucket    = "listing-media"
+	fakeS3AccessKey = "AKIDTESTSTANDIN"
+	fakeS3SecretKey = "stand-in/secret+key"
+)
+
+// a stand-in for an s3 bucket: it checks every request's signature v4 from what actually arrived on the wire
+// and keeps objects in memory
+type fakeS3 struct {
+	t       *testing.T
+	server  *httptest.Server
+	mu      sync.Mutex
+	objects map[string]fakeS3Object
+	// set to make the next request fail with this status
+	failWith int
+}
+
+type fakeS3Object struct {
+	data        []byte
+	contentType string
+}
+
+func newFakeS3(t *testing.T) *fakeS3 {
+	t.Helper()
+	f := &fakeS3{t: t, objects: map[string]fakeS3Object{}}
+	f.server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeS3) storage(secretKey string) *S3Storage {
+	return &S3Storage{
+		Endpoint:  f.server.URL + "/",
+		Region:    fakeS3Region,
+		Bucket:    fakeS3Bucket,
+		AccessKey: fakeS3AccessKey,
+		SecretKey: secretKey,
+		Client:    f.server.Client(),
+	}
+}
+
+func (f *fakeS3) object(key string) (fakeS3Object, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	object, ok := f.objects[key]
+	return object, ok
+}
+
+func (f *fakeS3) handle(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := verifySignature(r, body, fakeS3SecretKey); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "<Error><Code>SignatureDoesNotMatch</Code><Message>"+err.Error()+"</Message></Error>")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failWith != 0 {
+		w.WriteHeader(f.failWith)
+		io.WriteString(w, "<Error><Code>SlowDown</Code></Error>")
+		f.failWith = 0
+		return
+	}
+
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+fakeS3Bucket+"/")
+	if !ok {
+		http.Error(w, "no such bucket", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		f.objects[key] = fakeS3Object{data: body, contentType: r.Header.Get("Content-Type")}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// recompute the signature the way s3 does on its side, from the received method, raw path and headers
+func verifySignature(r *http.Request, body []byte, secretKey string) error {
+	auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return errors.New("missing signature v4 authorization")
+	}
+	fields := map[string]string{}
+	for _, part := range strings.Split(auth, ", ") {
+		name, value, _ := strings.Cut(part, "=")
+		fields[name] = value
+	}
+
+	amzDate := r.Header.Get("x-amz-date")
+	signedAt, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil {
+		return errors.New("bad x-amz-date")
+	}
+	if time.Since(signedAt).Abs() > 15*time.Minute {
+		return errors.New("request time too skewed")
+	}
+
+	scope := amzDate[:8] + "/" + fakeS3Region + "/s3/aws4_request"
+	if fields["Credential"] != fakeS3AccessKey+"/"+scope {
+		return errors.New("unexpected credential " + fields["Credential"])
+	}
+
+	bodySum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(bodySum[:])
+	if r.Header.Get("x-amz-content-sha256") != payloadHash {
+		return errors.New("payload hash does not match the body")
+	}
+
+	signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+	if !sort.StringsAreSorted(signedHeaders) {
+		return errors.New("signed headers are not sorted")
+	}
+	required := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if r.Header.Get("Content-Type") != "" {
+		required = append(required, "content-type")
+	}
+	for _, name := range required {
+		if !strings.Contains(";"+fields["SignedHeaders"]+";", ";"+name+";") {
+			return errors.New(name + " is not signed")
+		}
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	path, query, _ := strings.Cut(r.RequestURI, "?")
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return err
+	}
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		path,
+		values.Encode(),
+		canonicalHeaders.String(),
+		fields["SignedHeaders"],
+		payloadHash,
+	}, "\n")
+	requestSum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestSum[:])
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{amzDate[:8], fakeS3Region, "s3", "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	if !hmac.Equal([]byte(hex.EncodeToString(key)), []byte(fields["Signature"])) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
+
+func TestS3StoragePut(t *testing.T) {
+	tests := []struct {
+		name        string
+		key         string
+		contentType string
+		data        []byte
+	}{
+		{name: "image", key: "listings/12/abc/original.jpg", contentType: "image/jpeg", data: []byte("\xff\xd8jpeg bytes")},
+		{name: "no content type", key: "listings/12/abc/480.jpg", data: []byte("thumbnail")},
+		{name: "escaped key", key: "listings/12/a b+c/ünïcode (1).png", contentType: "image/png", data: []byte("png")},
+		{name: "empty object", key: "listings/12/abc/empty.webp", contentType: "image/webp", data: []byte{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeS3(t)
+			if err := fake.storage(fakeS3SecretKey).Put(tt.key, tt.data, tt.contentType); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+
+			object, ok := fake.object(tt.key)
+			if !ok {
+				t.Fatalf("object %q was not stored", tt.key)
+			}
+			if string(object.data) != string(tt.data) {
+				t.Errorf("stored %q, want %q", object.data, tt.data)
+			}
+			if object.contentType != tt.contentType {
+				t.Errorf("stored content type %q, want %q", object.contentType, tt.contentType)
+			}
+		})
+	}
+}
+
+func TestS3StorageDelete(t *testing.T) {
+	fake := newFakeS3(t)
+	storage := fake.storage(fakeS3SecretKey)
+
+	key := "listings/7/def/original.png"
+	if err := storage.Put(key, []byte("png"), "image/png"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := storage.Delete(key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, ok := fake.object(key); ok {
+		t.Fatal("object still stored after Delete")
+	}
+
+	// deleting again is not an error
+	if err := storage.Delete(key); err != nil {
+		t.Fatalf("repeated Delete: %v", err)
+	}
+}
+
+func TestS3StorageRejectedRequests(t *testing.T) {
+	fake := newFakeS3(t)
+
+	err := fake.storage("not-the-secret").Put("listings/1/a/original.jpg", []byte("x"), "image/jpeg")
+	if err == nil || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Fatalf("Put with the wrong secret returned %v, want a 403 signature error", err)
+	}
+
+	fake.mu.Lock()
+	fake.failWith = http.StatusServiceUnavailable
+	fake.mu.Unlock()
+	err = fake.storage(fakeS3SecretKey).Delete("listings/1/a/original.jpg")
+	if err == nil || !strings.Contains(err.Error(), "503") || !strings.Contains(err.Error(), "SlowDown") {
+		t.Fatalf("Delete during an outage returned %v, want a 503 error", err)
+	}
+
+	for _, key := range []string{"", "/abs/key", "listings/../secrets", "listings//a", `listings\a`} {
+		if err := fake.storage(fakeS3SecretKey).Put(key, []byte("x"), ""); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q) returned %v, want ErrInvalidKey", key, err)
+		}
+	}
+	if len(fake.objects) != 0 {
+		t.Errorf("rejected requests stored %d objects", len(fake.objects))
+	}
+}
+
+// signing is checked against fixed inputs so changes to the canonical request show up here and not only against s3
+func TestS3StorageSign(t *testing.T) {
+	storage := &S3Storage{
+		Endpoint:  "https://s3.example.test",
+		Region:    "us-east-1",
+		Bucket:    "media",
+		AccessKey: "AKIDEXAMPLE",
+		SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	payload := []byte("hello")
+	req, err := http.NewRequest(http.MethodPut, storage.objectURL("listings/1/a b/original.jpg"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "image/jpeg")
+	storage.sign(req, payload, time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC))
+
+	if got := req.URL.EscapedPath(); got != "/media/listings/1/a%20b/original.jpg" {
+		t.Errorf("signed path %q", got)
+	}
+	if got := req.Header.Get("x-amz-date"); got != "20260301T123000Z" {
+		t.Errorf("x-amz-date %q", got)
+	}
+	if got := req.Header.Get("x-amz-content-sha256"); got != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("x-amz-content-sha256 %q", got)
+	}
+
+	auth := req.Header.Get("Authorization")
+	wantPrefix := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20260301/us-east-1/s3/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date, Signature="
+	if !strings.HasPrefix(auth, wantPrefix) {
+		t.Fatalf("Authorization %q, want prefix %q", auth, wantPrefix)
+	}
+	if signature := strings.TrimPrefix(auth, wantPrefix); len(signature) != 64 {
+		t.Errorf("signature %q is not a hex sha256", signature)
+	}
+
+	// the same inputs always sign the same way, and any change to them changes the signature
+	again, _ := http.NewRequest(http.MethodPut, req.URL.String(), nil)
+	again.Header.Set("Content-Type", "image/jpeg")
+	storage.sign(again, payload, time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC))
+	if again.Header.Get("Authorization") != auth {
+		t.Error("signing is not deterministic")
+	}
+	again.Header.Set("Content-Type", "image/png")
+	storage.sign(again, payload, time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC))
+	if again.Header.Get("Authorization") == auth {
+		t.Error("content type is not covered by the signature")
+	}
+}
+
+func TestS3StorageURL(t *testing.T) {
+	storage := &S3Storage{Endpoint: "https://s3.example.test/", Bucket: "media"}
+	if got := storage.URL("listings/1/a b/480.jpg"); got != "https://s3.example.test/media/listings/1/a%20b/480.jpg" {
+		t.Errorf("bucket url %q", got)
+	}
+
+	storage.PublicURL = "https://cdn.example.test/"
+	if got := storage.URL("listings/1/a+b/480.jpg"); got != "https://cdn.example.test/listings/1/a%2Bb/480.jpg" {
+		t.Errorf("public url %q", got)
+	}
+}
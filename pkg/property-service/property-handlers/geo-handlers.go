@@ -0,0 +1,72 @@
+package property_handlers
+
+import (
+	"log"
+	"net/http"
+
+	geo_service "github.com/Brian-Mashavakure/smart-prop-server/pkg/geo-service"
+	property_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/property-service/property-utils"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// published listings within radius_km of a point, closest first
+func NearbyPropertiesHandler(c *gin.Context) {
+	var req NearbyRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+
+	center := geo_service.Point{Lat: *req.Latitude, Lng: *req.Longitude}
+	properties, err := property_utils.NearbyProperties(center, req.RadiusKm, req.Limit)
+	if err != nil {
+		log.Printf("Error occurred trying to find nearby properties:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to search properties", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "Properties found", map[string]interface{}{"properties": properties}, nil))
+}
+
+// published listings inside a map viewport
+func PropertiesInBoundsHandler(c *gin.Context) {
+	var req BoundsRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+
+	bounds := geo_service.Bounds{MinLat: *req.MinLat, MinLng: *req.MinLng, MaxLat: *req.MaxLat, MaxLng: *req.MaxLng}
+	var center *geo_service.Point
+	if req.Latitude != nil && req.Longitude != nil {
+		center = &geo_service.Point{Lat: *req.Latitude, Lng: *req.Longitude}
+	}
+
+	properties, err := property_utils.PropertiesInBounds(bounds, center, req.Limit)
+	if err != nil {
+		log.Printf("Error occurred trying to find properties in bounds:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to search properties", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "Properties found", map[string]interface{}{"properties": properties}, nil))
+}
+
+// fill in coordinates for one batch of listings that have none, call again until nothing is left to geocode
+func GeocodeMissingHandler(c *gin.Context) {
+	var req GeocodeMissingRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+
+	geocoded, failed, err := property_utils.GeocodeMissing(req.BatchSize, req.RetryFailed)
+	if err != nil {
+		log.Printf("Error occurred trying to geocode properties:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to geocode properties", nil, map[string]interface{}{"error": "something went wrong", "geocoded": geocoded, "failed": failed}))
+		return
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "Properties geocoded", map[string]interface{}{"geocoded": geocoded, "failed": failed}, nil))
+}
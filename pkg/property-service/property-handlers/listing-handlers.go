@@ -0,0 +1,273 @@
+package property_handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/middleware"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	property_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/property-service/property-utils"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// load the listing named in the request if the caller may manage it, writes the error response and returns false otherwise
+func managedListing(c *gin.Context, propertyID uint) (models.Property, bool) {
+	property, err := property_utils.ManagedListing(propertyID, middleware.CurrentUserID(c), c.GetStringSlice("roles"))
+	switch {
+	case errors.Is(err, property_utils.ErrListingNotFound):
+		c.JSON(http.StatusNotFound, utils.ReturnJsonResponse("failed", "listing not found", nil, map[string]interface{}{"error": "listing does not exist"}))
+		return models.Property{}, false
+	case errors.Is(err, property_utils.ErrNotListingOwner):
+		log.Printf("User %d tried to manage listing %d they do not own\n", middleware.CurrentUserID(c), propertyID)
+		c.JSON(http.StatusForbidden, utils.ReturnJsonResponse("failed", "forbidden", nil, map[string]interface{}{"error": "you can only manage your own listings"}))
+		return models.Property{}, false
+	}
+	return property, true
+}
+
+// the amenities column and catalogue keys for a listing, free form names are accepted and only the ones the
+// catalogue knows are linked; writes the error response and returns false on failure
+func listingAmenities(c *gin.Context, names []string, failure string) (json.RawMessage, []string, bool) {
+	stored, keys, err := property_utils.ListingAmenities(names)
+	if err != nil {
+		log.Printf("Error occurred trying to normalise amenities:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", failure, nil, map[string]interface{}{"error": "something went wrong"}))
+		return nil, nil, false
+	}
+	return stored, keys, true
+}
+
+// coordinates for a listing, taken from the request when given and otherwise looked up from the address;
+// nil coordinates with a failure time when the address cannot be placed
+func listingLocation(latitude *float64, longitude *float64, address string, city string) (*float64, *float64, *time.Time) {
+	if latitude != nil && longitude != nil {
+		return latitude, longitude, nil
+	}
+
+	point, err := property_utils.GeocodeAddress(address, city)
+	if err != nil {
+		log.Printf("Error occurred trying to geocode listing address:\n %v", err)
+	}
+	if point == nil {
+		failedAt := time.Now()
+		return nil, nil, &failedAt
+	}
+	return &point.Lat, &point.Lng, nil
+}
+
+func CreateListingHandler(c *gin.Context) {
+	var req ListingRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+
+	amenities, amenityKeys, ok := listingAmenities(c, req.Amenities, "failed to create listing")
+	if !ok {
+		return
+	}
+
+	currency := req.Currency
+	if currency == "" {
+		currency = "USD"
+	}
+
+	ownerID := middleware.CurrentUserID(c)
+	property := models.Property{
+		Title:         strings.TrimSpace(req.Title),
+		Description:   strings.TrimSpace(req.Description),
+		PropertyType:  strings.TrimSpace(req.PropertyType),
+		Address:       strings.TrimSpace(req.Address),
+		City:          strings.TrimSpace(req.City),
+		Price:         req.Price,
+		Currency:      currency,
+		PricePeriod:   req.PricePeriod,
+		Bedrooms:      req.Bedrooms,
+		Bathrooms:     req.Bathrooms,
+		AreaSqft:      req.AreaSqft,
+		Amenities:     amenities,
+		SourceWebsite: property_utils.ListingSource,
+		OwnerID:       &ownerID,
+		Published:     true,
+	}
+	property.Latitude, property.Longitude, property.GeocodeFailedAt = listingLocation(req.Latitude, req.Longitude, property.Address, property.City)
+
+	err := connector.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&property).Error; err != nil {
+			return err
+		}
+		return property_utils.SetListingAmenities(tx, property.ID, amenityKeys)
+	})
+	if err != nil {
+		log.Printf("Error occurred trying to create listing:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to create listing", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+	connector.DB.Preload("CatalogueAmenities").First(&property, property.ID)
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusCreated, utils.ReturnJsonResponse("success", "Listing created successfully", map[string]interface{}{"property": property}, nil))
+}
+
+func UpdateListingHandler(c *gin.Context) {
+	var req UpdateListingRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+
+	property, ok := managedListing(c, req.PropertyID)
+	if !ok {
+		return
+	}
+
+	updates := map[string]interface{}{}
+	trimmed := map[string]*string{
+		"title":         req.Title,
+		"description":   req.Description,
+		"property_type": req.PropertyType,
+		"address":       req.Address,
+		"city":          req.City,
+		"currency":      req.Currency,
+		"price_period":  req.PricePeriod,
+	}
+	for column, value := range trimmed {
+		if value != nil {
+			updates[column] = strings.TrimSpace(*value)
+		}
+	}
+	if req.Price != nil {
+		updates["price"] = *req.Price
+	}
+	if req.Bedrooms != nil {
+		updates["bedrooms"] = *req.Bedrooms
+	}
+	if req.Bathrooms != nil {
+		updates["bathrooms"] = *req.Bathrooms
+	}
+	if req.AreaSqft != nil {
+		updates["area_sqft"] = *req.AreaSqft
+	}
+	var amenityKeys []string
+	if req.Amenities != nil {
+		amenities, keys, ok := listingAmenities(c, *req.Amenities, "failed to update listing")
+		if !ok {
+			return
+		}
+		amenityKeys = keys
+		updates["amenities"] = amenities
+	}
+
+	// a moved listing needs new coordinates unless the client sent them
+	if req.Latitude != nil || req.Address != nil || req.City != nil {
+		address, city := property.Address, property.City
+		if req.Address != nil {
+			address = strings.TrimSpace(*req.Address)
+		}
+		if req.City != nil {
+			city = strings.TrimSpace(*req.City)
+		}
+		updates["latitude"], updates["longitude"], updates["geocode_failed_at"] = listingLocation(req.Latitude, req.Longitude, address, city)
+	}
+
+	if len(updates) == 0 {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "nothing to update", nil, map[string]interface{}{"error": "no listing fields were provided"}))
+		return
+	}
+
+	err := connector.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&property).Updates(updates).Error; err != nil {
+			return err
+		}
+		if req.Amenities == nil {
+			return nil
+		}
+		return property_utils.SetListingAmenities(tx, property.ID, amenityKeys)
+	})
+	if err != nil {
+		log.Printf("Error occurred trying to update listing:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to update listing", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+	connector.DB.Preload("CatalogueAmenities").First(&property, property.ID)
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "Listing updated successfully", map[string]interface{}{"property": property}, nil))
+}
+
+func setListingPublished(c *gin.Context, published bool) {
+	var req ListingIDRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+
+	property, ok := managedListing(c, req.PropertyID)
+	if !ok {
+		return
+	}
+
+	if err := property_utils.SetListingPublished(property.ID, published); err != nil {
+		log.Printf("Error occurred trying to change listing visibility:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to update listing", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	message := "Listing unpublished, it is hidden from tenants until published again"
+	if published {
+		message = "Listing published"
+	}
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", message, map[string]interface{}{"property_id": property.ID, "published": published}, nil))
+}
+
+func UnpublishListingHandler(c *gin.Context) {
+	setListingPublished(c, false)
+}
+
+func PublishListingHandler(c *gin.Context) {
+	setListingPublished(c, true)
+}
+
+// listings with bookings still to come have to be unpublished instead
+func DeleteListingHandler(c *gin.Context) {
+	var req ListingIDRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+
+	property, ok := managedListing(c, req.PropertyID)
+	if !ok {
+		return
+	}
+
+	err := property_utils.DeleteListing(property.ID)
+	if errors.Is(err, property_utils.ErrListingHasActive) {
+		c.JSON(http.StatusConflict, utils.ReturnJsonResponse("failed", "listing has active bookings", nil, map[string]interface{}{"error": "listings with upcoming bookings cannot be deleted, unpublish it instead"}))
+		return
+	}
+	if err != nil {
+		log.Printf("Error occurred trying to delete listing:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to delete listing", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "Listing deleted successfully", map[string]interface{}{"property_id": property.ID}, nil))
+}
+
+func MyListingsHandler(c *gin.Context) {
+	properties, err := property_utils.OwnerListings(middleware.CurrentUserID(c))
+	if err != nil {
+		log.Printf("Error occurred trying to find listings:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to retrieve listings", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "Listings retrieved successfully", map[string]interface{}{"properties": properties}, nil))
+}
@@ -0,0 +1,227 @@
+package property_handlers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/middleware"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	media_service "github.com/Brian-Mashavakure/smart-prop-server/pkg/media-service"
+	property_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/property-service/property-utils"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxImagesPerUpload = 10
+	// room for the largest allowed images plus the rest of the multipart body
+	maxUploadBodyBytes = maxImagesPerUpload*media_service.MaxImageBytes + 1<<20
+	uploadMemoryBytes  = 32 << 20
+)
+
+func readUpload(file *multipart.FileHeader) ([]byte, error) {
+	if file.Size > media_service.MaxImageBytes {
+		return nil, media_service.ErrImageTooLarge
+	}
+	opened, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer opened.Close()
+
+	data, err := io.ReadAll(io.LimitReader(opened, media_service.MaxImageBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > media_service.MaxImageBytes {
+		return nil, media_service.ErrImageTooLarge
+	}
+	return data, nil
+}
+
+// multipart upload of one or more photos for a listing under "images" (or "image"), each is checked,
+// resized into thumbnails and added after the listing's existing photos
+func UploadListingImagesHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadBodyBytes)
+	if err := c.Request.ParseMultipartForm(uploadMemoryBytes); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, utils.ReturnJsonResponse("failed", "upload too large", nil, map[string]interface{}{"error": "upload is larger than allowed"}))
+			return
+		}
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "invalid request", nil, map[string]interface{}{"error": "images must be sent as multipart/form-data"}))
+		return
+	}
+
+	var req ListingIDRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+
+	property, ok := managedListing(c, req.PropertyID)
+	if !ok {
+		return
+	}
+
+	files := append(c.Request.MultipartForm.File["images"], c.Request.MultipartForm.File["image"]...)
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "invalid request", nil, map[string]interface{}{"images": "images is required"}))
+		return
+	}
+	if len(files) > maxImagesPerUpload {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "invalid request", nil, map[string]interface{}{"images": "at most 10 images can be uploaded at once"}))
+		return
+	}
+
+	uploaded := []models.PropertyImage{}
+	fileErrors := map[string]interface{}{}
+	for _, file := range files {
+		data, err := readUpload(file)
+		if err == nil {
+			var processed media_service.ProcessedImage
+			processed, err = media_service.ProcessImage(data)
+			if err == nil {
+				var image models.PropertyImage
+				image, err = property_utils.AddListingImage(property.ID, processed)
+				if err == nil {
+					uploaded = append(uploaded, image)
+					continue
+				}
+			}
+		}
+
+		switch {
+		case errors.Is(err, media_service.ErrImageTooLarge):
+			fileErrors[file.Filename] = "image is larger than 10MB or 40 megapixels"
+		case errors.Is(err, media_service.ErrUnsupportedImage), errors.Is(err, property_utils.ErrTooManyImages):
+			fileErrors[file.Filename] = err.Error()
+		default:
+			log.Printf("Error occurred trying to store listing image:\n %v", err)
+			fileErrors[file.Filename] = "image could not be stored"
+		}
+	}
+
+	if len(uploaded) == 0 {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "no images were uploaded", nil, fileErrors))
+		return
+	}
+
+	var errorsByFile map[string]interface{}
+	if len(fileErrors) > 0 {
+		errorsByFile = fileErrors
+	}
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusCreated, utils.ReturnJsonResponse("success", "Images uploaded", map[string]interface{}{"images": uploaded}, errorsByFile))
+}
+
+// photos of a published listing, owners and admins can also see the photos of unpublished ones
+func ListListingImagesHandler(c *gin.Context) {
+	var req ListingIDRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+
+	var property models.Property
+	if result := connector.DB.First(&property, req.PropertyID); result.Error != nil {
+		c.JSON(http.StatusNotFound, utils.ReturnJsonResponse("failed", "listing not found", nil, map[string]interface{}{"error": "listing does not exist"}))
+		return
+	}
+	if !property.Published {
+		if _, err := property_utils.ManagedListing(property.ID, middleware.CurrentUserID(c), c.GetStringSlice("roles")); err != nil {
+			c.JSON(http.StatusNotFound, utils.ReturnJsonResponse("failed", "listing not found", nil, map[string]interface{}{"error": "listing does not exist"}))
+			return
+		}
+	}
+
+	images, err := property_utils.ListingImages(property.ID)
+	if err != nil {
+		log.Printf("Error occurred trying to find listing images:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to retrieve images", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "Images retrieved", map[string]interface{}{"images": images}, nil))
+}
+
+func ReorderListingImagesHandler(c *gin.Context) {
+	var req ReorderImagesRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+
+	property, ok := managedListing(c, req.PropertyID)
+	if !ok {
+		return
+	}
+
+	images, err := property_utils.ReorderListingImages(property.ID, req.ImageIDs)
+	if errors.Is(err, property_utils.ErrImageOrderMismatch) {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "invalid request", nil, map[string]interface{}{"image_ids": err.Error()}))
+		return
+	}
+	if err != nil {
+		log.Printf("Error occurred trying to reorder listing images:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to reorder images", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "Images reordered", map[string]interface{}{"images": images}, nil))
+}
+
+func SetCoverImageHandler(c *gin.Context) {
+	var req ListingImageRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+
+	property, ok := managedListing(c, req.PropertyID)
+	if !ok {
+		return
+	}
+
+	err := property_utils.SetCoverImage(property.ID, req.ImageID)
+	if errors.Is(err, property_utils.ErrImageNotFound) {
+		c.JSON(http.StatusNotFound, utils.ReturnJsonResponse("failed", "image not found", nil, map[string]interface{}{"error": "this listing has no image with that id"}))
+		return
+	}
+	if err != nil {
+		log.Printf("Error occurred trying to set cover image:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to set cover image", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "Cover image set", map[string]interface{}{"image_id": req.ImageID}, nil))
+}
+
+func DeleteListingImageHandler(c *gin.Context) {
+	var req ListingImageRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+
+	property, ok := managedListing(c, req.PropertyID)
+	if !ok {
+		return
+	}
+
+	err := property_utils.DeleteListingImage(property.ID, req.ImageID)
+	if errors.Is(err, property_utils.ErrImageNotFound) {
+		c.JSON(http.StatusNotFound, utils.ReturnJsonResponse("failed", "image not found", nil, map[string]interface{}{"error": "this listing has no image with that id"}))
+		return
+	}
+	if err != nil {
+		log.Printf("Error occurred trying to delete listing image:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to delete image", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "Image deleted", map[string]interface{}{"image_id": req.ImageID}, nil))
+}
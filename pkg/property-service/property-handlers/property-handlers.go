@@ -5,12 +5,15 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/middleware"
 	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
 	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
 	genai_service "github.com/Brian-Mashavakure/smart-prop-server/pkg/genai-service"
+	property_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/property-service/property-utils"
 	"github.com/Brian-Mashavakure/smart-prop-server/pkg/utils"
 	"github.com/gin-gonic/gin"
 )
@@ -33,6 +36,13 @@ func GetPreferencesHandler(c *gin.Context) {
 		return
 	}
 
+	userID := middleware.CurrentUserID(c)
+	if prefReq.USERID != 0 && prefReq.USERID != userID {
+		log.Printf("User %d tried to save preferences for user %d\n", userID, prefReq.USERID)
+		c.JSON(http.StatusForbidden, utils.ReturnJsonResponse("failed", "forbidden", nil, map[string]interface{}{"error": "you can only save your own preferences"}))
+		return
+	}
+
 	//locations and amenities json stuff
 	locationsJson, err := json.Marshal(prefReq.LOCATIONS)
 	if err != nil {
@@ -47,13 +57,15 @@ func GetPreferencesHandler(c *gin.Context) {
 
 	fmt.Printf("Locations: %s\n", string(locationsJson))
 
-	amenitiesJson, err := json.Marshal(prefReq.AMENITIES)
+	// amenities are stored as catalogue keys so they line up with listings
+	amenitiesJson, err := property_utils.PreferenceAmenities(prefReq.AMENITIES)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse(
+		log.Printf("Error occurred trying to normalise amenities:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse(
 			"failed",
 			"could not encode amenities",
 			nil,
-			map[string]interface{}{"error": err.Error()},
+			map[string]interface{}{"error": "something went wrong"},
 		))
 		return
 	}
@@ -62,7 +74,7 @@ func GetPreferencesHandler(c *gin.Context) {
 
 	//preference
 	preference := models.Preferences{
-		UserID:        prefReq.USERID,
+		UserID:        userID,
 		LOCATIONS:     locationsJson,
 		BEDROOMS:      prefReq.BEDROOMS,
 		PROPERTY_SIZE: prefReq.PROPERTY_SIZE,
@@ -76,7 +88,7 @@ func GetPreferencesHandler(c *gin.Context) {
 			"failed",
 			"preference could not be created",
 			nil,
-			map[string]interface{}{"error": err.Error()},
+			map[string]interface{}{"error": create.Error.Error()},
 		))
 		return
 	}
@@ -91,7 +103,7 @@ func GetPreferencesHandler(c *gin.Context) {
 }
 
 func GetPropertiesHandler(c *gin.Context) {
-	userID := c.Request.FormValue("user_id")
+	userID := middleware.CurrentUserID(c)
 
 	// Use WaitGroup to fetch properties and preferences concurrently
 	var wg sync.WaitGroup
@@ -104,7 +116,7 @@ func GetPropertiesHandler(c *gin.Context) {
 	// Fetch properties in a goroutine
 	go func() {
 		defer wg.Done()
-		result := connector.DB.Find(&properties)
+		result := connector.DB.Where("published = ?", true).Find(&properties)
 		if result.Error != nil {
 			propertyErr = result.Error
 		}
@@ -176,6 +188,13 @@ func BookingHandler(c *gin.Context) {
 		return
 	}
 
+	userID := middleware.CurrentUserID(c)
+	if req.UserID != 0 && req.UserID != userID {
+		log.Printf("User %d tried to book for user %d\n", userID, req.UserID)
+		c.JSON(http.StatusForbidden, utils.ReturnJsonResponse("failed", "forbidden", nil, map[string]interface{}{"error": "you can only create bookings for yourself"}))
+		return
+	}
+
 	// Parse and validate dates
 	bookingDate, bookingDateErr := time.Parse("2006-01-02", req.BookingDate)
 	if bookingDateErr != nil {
@@ -201,6 +220,15 @@ func BookingHandler(c *gin.Context) {
 		return
 	}
 
+	// Only published listings can be booked
+	var property models.Property
+	propertyCheck := connector.DB.Where("id = ? AND published = ?", req.PropertyID, true).First(&property)
+	if propertyCheck.Error != nil {
+		log.Printf("Error occurred trying to find property %d:\n %v", req.PropertyID, propertyCheck.Error)
+		c.JSON(http.StatusNotFound, utils.ReturnJsonResponse("failed", "property not found", nil, map[string]interface{}{"error": "property does not exist or is no longer listed"}))
+		return
+	}
+
 	// Check if there's already a booking for this property on the same date
 	var existingBooking models.Booking
 	bookingCheck := connector.DB.Where("property_id = ? AND booking_date = ?", req.PropertyID, req.BookingDate).First(&existingBooking)
@@ -219,7 +247,7 @@ func BookingHandler(c *gin.Context) {
 		CheckoutDate: req.CheckoutDate,
 		CheckoutTime: req.CheckoutTime,
 		Status:       "active",
-		UserID:       req.UserID,
+		UserID:       userID,
 	}
 
 	result := connector.DB.Create(&booking)
@@ -252,6 +280,12 @@ func CancelBookingHandler(c *gin.Context) {
 		return
 	}
 
+	if booking.UserID != middleware.CurrentUserID(c) {
+		log.Printf("User %d tried to cancel booking %s they do not own\n", middleware.CurrentUserID(c), bookingID)
+		c.JSON(http.StatusForbidden, utils.ReturnJsonResponse("failed", "forbidden", nil, map[string]interface{}{"error": "you can only cancel your own bookings"}))
+		return
+	}
+
 	// Check if booking is already cancelled
 	if booking.Status == "cancelled" {
 		log.Printf("Booking %s is already cancelled\n", bookingID)
@@ -273,12 +307,12 @@ func CancelBookingHandler(c *gin.Context) {
 }
 
 func GetBookingsHandler(c *gin.Context) {
-	userID := c.Request.FormValue("user_id")
+	userID := middleware.CurrentUserID(c)
 
-	if userID == "" {
-		log.Println("user_id parameter is missing")
-		missingParamResponse := utils.ReturnJsonResponse("failed", "user_id is required", nil, map[string]interface{}{"error": "user_id parameter is missing"})
-		c.JSON(http.StatusBadRequest, missingParamResponse)
+	// user_id is still accepted from older clients but must match the token
+	if requested := c.Request.FormValue("user_id"); requested != "" && requested != strconv.FormatUint(uint64(userID), 10) {
+		log.Printf("User %d tried to read bookings of user %s\n", userID, requested)
+		c.JSON(http.StatusForbidden, utils.ReturnJsonResponse("failed", "forbidden", nil, map[string]interface{}{"error": "you can only view your own bookings"}))
 		return
 	}
 
@@ -0,0 +1,110 @@
+package property_handlers
+
+// request bodies for the listing endpoints, each binds from json or form data via utils.BindRequest
+
+type ListingRequest struct {
+	Title        string   `json:"title" form:"title" binding:"required,notblank,max=500"`
+	Description  string   `json:"description" form:"description" binding:"max=10000"`
+	PropertyType string   `json:"property_type" form:"property_type" binding:"required,notblank,max=100"`
+	Address      string   `json:"address" form:"address" binding:"max=500"`
+	City         string   `json:"city" form:"city" binding:"required,notblank,max=200"`
+	Price        float64  `json:"price" form:"price" binding:"required,gt=0,max=1000000000"`
+	Currency     string   `json:"currency" form:"currency" binding:"omitempty,iso4217"`
+	PricePeriod  string   `json:"price_period" form:"price_period" binding:"required,oneof=night week month year sale"`
+	Bedrooms     uint     `json:"bedrooms" form:"bedrooms" binding:"max=50"`
+	Bathrooms    uint     `json:"bathrooms" form:"bathrooms" binding:"max=50"`
+	AreaSqft     float64  `json:"area_sqft" form:"area_sqft" binding:"gte=0,max=1000000"`
+	Amenities    []string `json:"amenities" form:"amenities" binding:"max=50,dive,max=100"`
+	Latitude     *float64 `json:"latitude" form:"latitude" binding:"required_with=Longitude,omitempty,latitude"`
+	Longitude    *float64 `json:"longitude" form:"longitude" binding:"required_with=Latitude,omitempty,longitude"`
+}
+
+type ListingIDRequest struct {
+	PropertyID uint `json:"property_id" form:"property_id" binding:"required"`
+}
+
+// only the fields sent are changed
+type UpdateListingRequest struct {
+	ListingIDRequest
+	Title        *string   `json:"title" form:"title" binding:"omitempty,notblank,max=500"`
+	Description  *string   `json:"description" form:"description" binding:"omitempty,max=10000"`
+	PropertyType *string   `json:"property_type" form:"property_type" binding:"omitempty,notblank,max=100"`
+	Address      *string   `json:"address" form:"address" binding:"omitempty,max=500"`
+	City         *string   `json:"city" form:"city" binding:"omitempty,notblank,max=200"`
+	Price        *float64  `json:"price" form:"price" binding:"omitempty,gt=0,max=1000000000"`
+	Currency     *string   `json:"currency" form:"currency" binding:"omitempty,iso4217"`
+	PricePeriod  *string   `json:"price_period" form:"price_period" binding:"omitempty,oneof=night week month year sale"`
+	Bedrooms     *uint     `json:"bedrooms" form:"bedrooms" binding:"omitempty,max=50"`
+	Bathrooms    *uint     `json:"bathrooms" form:"bathrooms" binding:"omitempty,max=50"`
+	AreaSqft     *float64  `json:"area_sqft" form:"area_sqft" binding:"omitempty,gte=0,max=1000000"`
+	Amenities    *[]string `json:"amenities" form:"amenities" binding:"omitempty,max=50,dive,max=100"`
+	Latitude     *float64  `json:"latitude" form:"latitude" binding:"required_with=Longitude,omitempty,latitude"`
+	Longitude    *float64  `json:"longitude" form:"longitude" binding:"required_with=Latitude,omitempty,longitude"`
+}
+
+// query parameters of the property search, amenities may be repeated or comma separated
+type SearchRequest struct {
+	City         string   `json:"city" form:"city" binding:"max=200"`
+	PropertyType string   `json:"property_type" form:"property_type" binding:"max=100"`
+	MinPrice     *float64 `json:"min_price" form:"min_price" binding:"omitempty,gte=0"`
+	MaxPrice     *float64 `json:"max_price" form:"max_price" binding:"omitempty,gte=0"`
+	Currency     string   `json:"currency" form:"currency" binding:"omitempty,iso4217"`
+	PricePeriod  string   `json:"price_period" form:"price_period" binding:"omitempty,oneof=night week month year sale"`
+	MinBedrooms  *uint    `json:"min_bedrooms" form:"min_bedrooms" binding:"omitempty,max=50"`
+	MaxBedrooms  *uint    `json:"max_bedrooms" form:"max_bedrooms" binding:"omitempty,max=50"`
+	MinBathrooms *uint    `json:"min_bathrooms" form:"min_bathrooms" binding:"omitempty,max=50"`
+	MinArea      *float64 `json:"min_area" form:"min_area" binding:"omitempty,gte=0"`
+	MaxArea      *float64 `json:"max_area" form:"max_area" binding:"omitempty,gte=0"`
+	Amenities    []string `json:"amenities" form:"amenities" binding:"max=20,dive,max=100"`
+	Sort         string   `json:"sort" form:"sort" binding:"omitempty,oneof=newest price_asc price_desc area_desc"`
+	Cursor       string   `json:"cursor" form:"cursor" binding:"max=500"`
+	Limit        int      `json:"limit" form:"limit" binding:"omitempty,min=1,max=100"`
+}
+
+type NearbyRequest struct {
+	Latitude  *float64 `json:"latitude" form:"latitude" binding:"required,latitude"`
+	Longitude *float64 `json:"longitude" form:"longitude" binding:"required,longitude"`
+	RadiusKm  float64  `json:"radius_km" form:"radius_km" binding:"required,gt=0,max=500"`
+	Limit     int      `json:"limit" form:"limit" binding:"omitempty,min=1,max=200"`
+}
+
+// a map viewport, min_lng greater than max_lng means the box crosses the antimeridian; latitude and
+// longitude are optional and order the results by distance from that point
+type BoundsRequest struct {
+	MinLat    *float64 `json:"min_lat" form:"min_lat" binding:"required,latitude"`
+	MinLng    *float64 `json:"min_lng" form:"min_lng" binding:"required,longitude"`
+	MaxLat    *float64 `json:"max_lat" form:"max_lat" binding:"required,latitude,gtefield=MinLat"`
+	MaxLng    *float64 `json:"max_lng" form:"max_lng" binding:"required,longitude"`
+	Latitude  *float64 `json:"latitude" form:"latitude" binding:"required_with=Longitude,omitempty,latitude"`
+	Longitude *float64 `json:"longitude" form:"longitude" binding:"required_with=Latitude,omitempty,longitude"`
+	Limit     int      `json:"limit" form:"limit" binding:"omitempty,min=1,max=200"`
+}
+
+type GeocodeMissingRequest struct {
+	BatchSize   int  `json:"batch_size" form:"batch_size" binding:"omitempty,min=1,max=1000"`
+	RetryFailed bool `json:"retry_failed" form:"retry_failed"`
+}
+
+// free text such as "sunny loft near the river", pass next_cursor back as cursor for the following page
+type TextSearchRequest struct {
+	Query  string `json:"q" form:"q" binding:"required,notblank,max=200"`
+	City   string `json:"city" form:"city" binding:"max=200"`
+	Cursor string `json:"cursor" form:"cursor" binding:"max=500"`
+	Limit  int    `json:"limit" form:"limit" binding:"omitempty,min=1,max=100"`
+}
+
+type CitySuggestionRequest struct {
+	Query string `json:"q" form:"q" binding:"required,notblank,max=100"`
+	Limit int    `json:"limit" form:"limit" binding:"omitempty,min=1,max=10"`
+}
+
+type ListingImageRequest struct {
+	ListingIDRequest
+	ImageID uint `json:"image_id" form:"image_id" binding:"required"`
+}
+
+// every image of the listing, in the order they should be shown
+type ReorderImagesRequest struct {
+	ListingIDRequest
+	ImageIDs []uint `json:"image_ids" form:"image_ids" binding:"required,min=1,max=30,dive,required"`
+}
@@ -0,0 +1,146 @@
+package property_handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	property_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/property-service/property-utils"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// search published listings, pass next_cursor back as cursor to get the following page
+func SearchPropertiesHandler(c *gin.Context) {
+	var req SearchRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+
+	rangeErrors := map[string]interface{}{}
+	if req.MinPrice != nil && req.MaxPrice != nil && *req.MinPrice > *req.MaxPrice {
+		rangeErrors["max_price"] = "max_price must not be less than min_price"
+	}
+	if req.MinBedrooms != nil && req.MaxBedrooms != nil && *req.MinBedrooms > *req.MaxBedrooms {
+		rangeErrors["max_bedrooms"] = "max_bedrooms must not be less than min_bedrooms"
+	}
+	if req.MinArea != nil && req.MaxArea != nil && *req.MinArea > *req.MaxArea {
+		rangeErrors["max_area"] = "max_area must not be less than min_area"
+	}
+	if len(rangeErrors) > 0 {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "invalid request", nil, rangeErrors))
+		return
+	}
+
+	names := []string{}
+	for _, value := range req.Amenities {
+		names = append(names, strings.Split(value, ",")...)
+	}
+	amenities, unknown, err := property_utils.NormalizeAmenities(names)
+	if err != nil {
+		log.Printf("Error occurred trying to normalise amenities:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to search properties", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+	if len(unknown) > 0 {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "invalid request", nil, map[string]interface{}{"amenities": "unknown amenities: " + strings.Join(unknown, ", ")}))
+		return
+	}
+
+	properties, nextCursor, err := property_utils.SearchProperties(property_utils.SearchFilter{
+		City:         req.City,
+		PropertyType: req.PropertyType,
+		MinPrice:     req.MinPrice,
+		MaxPrice:     req.MaxPrice,
+		Currency:     req.Currency,
+		PricePeriod:  req.PricePeriod,
+		MinBedrooms:  req.MinBedrooms,
+		MaxBedrooms:  req.MaxBedrooms,
+		MinBathrooms: req.MinBathrooms,
+		MinArea:      req.MinArea,
+		MaxArea:      req.MaxArea,
+		Amenities:    amenities,
+		Sort:         req.Sort,
+		Cursor:       req.Cursor,
+		Limit:        req.Limit,
+	})
+	if errors.Is(err, property_utils.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "invalid request", nil, map[string]interface{}{"cursor": "cursor is invalid or was issued for a different sort"}))
+		return
+	}
+	if err != nil {
+		log.Printf("Error occurred trying to search properties:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to search properties", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "Properties found", map[string]interface{}{"properties": properties, "next_cursor": nextCursor}, nil))
+}
+
+// ranked full text search over titles, cities and descriptions with the matched words highlighted
+func TextSearchHandler(c *gin.Context) {
+	var req TextSearchRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+
+	results, nextCursor, err := property_utils.TextSearchProperties(strings.TrimSpace(req.Query), req.City, req.Cursor, req.Limit)
+	if errors.Is(err, property_utils.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, utils.ReturnJsonResponse("failed", "invalid request", nil, map[string]interface{}{"cursor": "cursor is invalid or was issued for a different search"}))
+		return
+	}
+	if err != nil {
+		log.Printf("Error occurred trying to run text search:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to search properties", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "Properties found", map[string]interface{}{"properties": results, "next_cursor": nextCursor}, nil))
+}
+
+// city names for autocomplete, tolerant of small typos
+func CitySuggestionsHandler(c *gin.Context) {
+	var req CitySuggestionRequest
+	if !utils.BindRequest(c, &req) {
+		return
+	}
+
+	cities, err := property_utils.SuggestCities(req.Query, req.Limit)
+	if err != nil {
+		log.Printf("Error occurred trying to suggest cities:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to suggest cities", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "Cities found", map[string]interface{}{"cities": cities}, nil))
+}
+
+// the amenities catalogue for client pickers, search filters and listings take the keys
+func ListAmenitiesHandler(c *gin.Context) {
+	amenities, err := property_utils.ListAmenities()
+	if err != nil {
+		log.Printf("Error occurred trying to list amenities:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to retrieve amenities", nil, map[string]interface{}{"error": "something went wrong"}))
+		return
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "Amenities retrieved", map[string]interface{}{"amenities": amenities}, nil))
+}
+
+// link existing listings and saved preferences to the catalogue, needed once for rows saved before it existed
+func BackfillAmenitiesHandler(c *gin.Context) {
+	listings, preferences, err := property_utils.BackfillAmenities()
+	if err != nil {
+		log.Printf("Error occurred trying to backfill amenities:\n %v", err)
+		c.JSON(http.StatusInternalServerError, utils.ReturnJsonResponse("failed", "failed to backfill amenities", nil, map[string]interface{}{"error": "something went wrong", "listings": listings, "preferences": preferences}))
+		return
+	}
+
+	c.Header("Content-Type", "application/json")
+	c.JSON(http.StatusOK, utils.ReturnJsonResponse("success", "Amenities backfilled", map[string]interface{}{"listings": listings, "preferences": preferences}, nil))
+}
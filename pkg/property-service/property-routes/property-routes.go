@@ -1,7 +1,11 @@
 package property_routes
 
 import (
+	"strings"
+
+	auth_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/auth-utils"
 	"github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/middleware"
+	media_service "github.com/Brian-Mashavakure/smart-prop-server/pkg/media-service"
 	property_handlers "github.com/Brian-Mashavakure/smart-prop-server/pkg/property-service/property-handlers"
 	"github.com/gin-gonic/gin"
 )
@@ -9,10 +13,46 @@ import (
 func PropertyRoutes(router *gin.Engine) {
 	api := router.Group("/smart-prop-api/prop/")
 
-	api.POST("user-preferences", property_handlers.GetPreferencesHandler, middleware.JWTMiddleware())
-	api.POST("get-properties", property_handlers.GetPropertiesHandler, middleware.JWTMiddleware())
-	api.POST("create-booking", property_handlers.BookingHandler, middleware.JWTMiddleware())
-	api.POST("cancel-booking", property_handlers.CancelBookingHandler, middleware.JWTMiddleware())
-	api.POST("get-bookings", property_handlers.GetBookingsHandler, middleware.JWTMiddleware())
+	tenants := middleware.RequireRole(auth_utils.RoleTenant, auth_utils.RoleAdmin)
+	listers := middleware.RequireRole(auth_utils.RoleLandlord, auth_utils.RoleAgent, auth_utils.RoleAdmin)
+	anyRole := middleware.RequireRole(auth_utils.RoleTenant, auth_utils.RoleLandlord, auth_utils.RoleAgent, auth_utils.RoleAdmin)
+
+	auth := middleware.JWTMiddleware(middleware.AllowAPIKeys())
+	verified := middleware.JWTMiddleware(middleware.AllowAPIKeys(), middleware.RequireVerifiedEmail())
+	noImpersonation := middleware.BlockImpersonation()
+
+	api.POST("user-preferences", auth, tenants, middleware.RequireScope(auth_utils.ScopePreferencesWrite), property_handlers.GetPreferencesHandler)
+	api.POST("get-properties", auth, anyRole, middleware.RequireScope(auth_utils.ScopePropertiesRead), property_handlers.GetPropertiesHandler)
+	api.GET("search", auth, anyRole, middleware.RequireScope(auth_utils.ScopePropertiesRead), property_handlers.SearchPropertiesHandler)
+	api.GET("amenities", auth, anyRole, middleware.RequireScope(auth_utils.ScopePropertiesRead), property_handlers.ListAmenitiesHandler)
+	api.GET("text-search", auth, anyRole, middleware.RequireScope(auth_utils.ScopePropertiesRead), property_handlers.TextSearchHandler)
+	api.GET("city-suggestions", auth, anyRole, middleware.RequireScope(auth_utils.ScopePropertiesRead), property_handlers.CitySuggestionsHandler)
+	api.GET("nearby", auth, anyRole, middleware.RequireScope(auth_utils.ScopePropertiesRead), property_handlers.NearbyPropertiesHandler)
+	api.GET("in-bounds", auth, anyRole, middleware.RequireScope(auth_utils.ScopePropertiesRead), property_handlers.PropertiesInBoundsHandler)
+	api.POST("create-booking", verified, tenants, middleware.RequireScope(auth_utils.ScopeBookingsWrite), property_handlers.BookingHandler)
+	api.POST("cancel-booking", verified, tenants, noImpersonation, middleware.RequireScope(auth_utils.ScopeBookingsWrite), property_handlers.CancelBookingHandler)
+	api.POST("get-bookings", verified, tenants, middleware.RequireScope(auth_utils.ScopeBookingsRead), property_handlers.GetBookingsHandler)
+
+	// landlords and agents manage their own listings, admins can manage any
+	listingsWrite := middleware.RequireScope(auth_utils.ScopePropertiesWrite)
+	api.POST("create-listing", verified, listers, listingsWrite, property_handlers.CreateListingHandler)
+	api.POST("update-listing", verified, listers, listingsWrite, property_handlers.UpdateListingHandler)
+	api.POST("unpublish-listing", verified, listers, noImpersonation, listingsWrite, property_handlers.UnpublishListingHandler)
+	api.POST("publish-listing", verified, listers, listingsWrite, property_handlers.PublishListingHandler)
+	api.POST("delete-listing", verified, listers, noImpersonation, listingsWrite, property_handlers.DeleteListingHandler)
+	api.GET("my-listings", verified, listers, middleware.RequireScope(auth_utils.ScopePropertiesRead), property_handlers.MyListingsHandler)
+	api.POST("listing-images", verified, listers, listingsWrite, property_handlers.UploadListingImagesHandler)
+	api.GET("listing-images", auth, anyRole, middleware.RequireScope(auth_utils.ScopePropertiesRead), property_handlers.ListListingImagesHandler)
+	api.POST("listing-images/reorder", verified, listers, listingsWrite, property_handlers.ReorderListingImagesHandler)
+	api.POST("listing-images/cover", verified, listers, listingsWrite, property_handlers.SetCoverImageHandler)
+	api.POST("listing-images/delete", verified, listers, noImpersonation, listingsWrite, property_handlers.DeleteListingImageHandler)
+
+	admin := api.Group("admin/", middleware.JWTMiddleware(), middleware.RequireRole(auth_utils.RoleAdmin))
+	admin.POST("geocode-missing", property_handlers.GeocodeMissingHandler)
+	admin.POST("backfill-amenities", property_handlers.BackfillAmenitiesHandler)
 
+	// uploads kept on local disk are served by the api itself, other storage serves its own urls
+	if local, ok := media_service.GetStorage().(media_service.LocalStorage); ok && strings.HasPrefix(local.URLPrefix, "/") {
+		router.Static(local.URLPrefix, local.Dir)
+	}
 }
@@ -0,0 +1,384 @@
+package property_utils
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	AmenityCategoryConnectivity = "connectivity"
+	AmenityCategoryUtilities    = "utilities"
+	AmenityCategorySecurity     = "security"
+	AmenityCategoryParking      = "parking"
+	AmenityCategoryOutdoor      = "outdoor"
+	AmenityCategoryComfort      = "comfort"
+	AmenityCategoryBuilding     = "building"
+
+	amenityCacheTTL      = 5 * time.Minute
+	amenityBackfillBatch = 500
+	amenityLinkInterval  = 5 * time.Minute
+)
+
+type amenitySeed struct {
+	Key      string
+	Name     string
+	Category string
+	Synonyms []string
+}
+
+// the catalogue written on every start, synonyms are the spellings scraped sites and users tend to use; the linker
+// attaches them to scraped listings, so only spellings that cannot mean anything else belong here
+var defaultAmenities = []amenitySeed{
+	{"wifi", "Wi-Fi", AmenityCategoryConnectivity, []string{"wireless internet"}},
+	{"satellite_tv", "Satellite TV", AmenityCategoryConnectivity, []string{"dstv", "satellite dish"}},
+	{"backup_power", "Backup power", AmenityCategoryUtilities, []string{"generator", "backup generator", "inverter", "solar backup"}},
+	{"borehole", "Borehole", AmenityCategoryUtilities, []string{"borehole water"}},
+	{"water_tank", "Water storage tank", AmenityCategoryUtilities, []string{"water tank", "water storage", "jojo tank"}},
+	{"security_guard", "Security guard", AmenityCategorySecurity, []string{"security guards", "24 hour security guard", "24/7 security guard", "guard house"}},
+	{"electric_fence", "Electric fence", AmenityCategorySecurity, []string{"electric fencing"}},
+	{"cctv", "CCTV", AmenityCategorySecurity, []string{"security cameras", "cameras", "surveillance"}},
+	{"alarm", "Alarm system", AmenityCategorySecurity, []string{"alarm system", "burglar alarm", "armed response"}},
+	{"gated", "Gated community", AmenityCategorySecurity, []string{"gated community", "gated estate", "boom gate", "secure complex"}},
+	{"parking", "Parking", AmenityCategoryParking, []string{"off street parking", "secure parking", "covered parking", "car park", "parking bay"}},
+	{"garage", "Garage", AmenityCategoryParking, []string{"double garage", "single garage", "lock up garage"}},
+	{"carport", "Carport", AmenityCategoryParking, []string{"car port", "shade port"}},
+	{"garden", "Garden", AmenityCategoryOutdoor, []string{"landscaped garden", "private garden"}},
+	{"pool", "Swimming pool", AmenityCategoryOutdoor, []string{"swimming pool", "pool access", "communal pool"}},
+	{"balcony", "Balcony", AmenityCategoryOutdoor, []string{"balconies", "private balcony"}},
+	{"braai_area", "Braai area", AmenityCategoryOutdoor, []string{"braai", "bbq", "barbecue", "barbeque", "bbq area"}},
+	{"air_conditioning", "Air conditioning", AmenityCategoryComfort, []string{"aircon", "air con", "ac", "a/c", "air conditioner"}},
+	{"heating", "Heating", AmenityCategoryComfort, []string{"central heating", "underfloor heating", "heater"}},
+	{"fireplace", "Fireplace", AmenityCategoryComfort, []string{"fire place", "wood burner"}},
+	{"furnished", "Furnished", AmenityCategoryComfort, []string{"fully furnished", "semi furnished", "furniture"}},
+	{"pet_friendly", "Pet friendly", AmenityCategoryComfort, []string{"pets allowed", "pets ok", "pets welcome", "pet friendly"}},
+	{"laundry", "Laundry", AmenityCategoryComfort, []string{"laundry room", "washing machine"}},
+	{"dishwasher", "Dishwasher", AmenityCategoryComfort, []string{"dish washer"}},
+	{"built_in_cupboards", "Built-in cupboards", AmenityCategoryComfort, []string{"bics", "built in wardrobes", "fitted wardrobes", "wardrobes"}},
+	{"elevator", "Elevator", AmenityCategoryBuilding, []string{"lift", "lifts"}},
+	{"gym", "Gym", AmenityCategoryBuilding, []string{"fitness centre", "fitness center", "gymnasium"}},
+	{"staff_quarters", "Staff quarters", AmenityCategoryBuilding, []string{"domestic quarters", "servants quarters", "staff room"}},
+	{"cottage", "Cottage", AmenityCategoryBuilding, []string{"garden cottage", "granny flat", "guest cottage"}},
+	{"wheelchair_access", "Wheelchair access", AmenityCategoryBuilding, []string{"wheelchair accessible", "wheelchair friendly", "step free access"}},
+}
+
+var (
+	amenityIndex     map[string]models.Amenity
+	amenityIndexAt   time.Time
+	amenityIndexLock sync.Mutex
+
+	amenityLinkerOnce sync.Once
+)
+
+// listings whose catalogue links are missing or older than their last scrape
+const pendingAmenityLinksSQL = "amenities_linked_at IS NULL OR last_scraped_at > amenities_linked_at"
+
+// fold an amenity name down to letters and digits so "Wi-Fi", "wifi" and "WiFi" compare equal
+func amenityLookupKey(name string) string {
+	var key strings.Builder
+	for _, r := range strings.ToLower(strings.ReplaceAll(name, "&", "and")) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			key.WriteRune(r)
+		}
+	}
+	return key.String()
+}
+
+// write the default catalogue, existing entries get the current name, category and synonyms
+func SeedAmenities() error {
+	amenities := make([]models.Amenity, 0, len(defaultAmenities))
+	for _, seed := range defaultAmenities {
+		synonyms, err := json.Marshal(seed.Synonyms)
+		if err != nil {
+			return err
+		}
+		amenities = append(amenities, models.Amenity{Key: seed.Key, Name: seed.Name, Category: seed.Category, Synonyms: synonyms})
+	}
+
+	var existing []models.Amenity
+	if err := connector.DB.Select("key", "name", "synonyms").Find(&existing).Error; err != nil {
+		return err
+	}
+
+	result := connector.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "category", "synonyms", "updated_at"}),
+	}).Create(&amenities)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	// links made with spellings that have since changed are redone by the linker
+	if len(existing) > 0 && amenitySpellingsChanged(existing, amenities) {
+		relink := connector.DB.Model(&models.Property{}).Where("amenities_linked_at IS NOT NULL").UpdateColumn("amenities_linked_at", nil)
+		if relink.Error != nil {
+			return relink.Error
+		}
+	}
+
+	amenityIndexLock.Lock()
+	amenityIndex = nil
+	amenityIndexLock.Unlock()
+	return nil
+}
+
+// true when a catalogue entry was added or its name or synonyms differ from what is stored, the synonyms are
+// compared decoded since postgres does not give jsonb back byte for byte
+func amenitySpellingsChanged(stored []models.Amenity, seeded []models.Amenity) bool {
+	synonyms := func(amenity models.Amenity) []string {
+		var names []string
+		json.Unmarshal(amenity.Synonyms, &names)
+		return names
+	}
+
+	byKey := map[string]models.Amenity{}
+	for _, amenity := range stored {
+		byKey[amenity.Key] = amenity
+	}
+	for _, amenity := range seeded {
+		current, ok := byKey[amenity.Key]
+		if !ok || current.Name != amenity.Name || !slices.Equal(synonyms(current), synonyms(amenity)) {
+			return true
+		}
+	}
+	return false
+}
+
+// the whole catalogue ordered for pickers
+func ListAmenities() ([]models.Amenity, error) {
+	var amenities []models.Amenity
+	result := connector.DB.Order("category, name").Find(&amenities)
+	return amenities, result.Error
+}
+
+// lookup of every key, name and synonym of the catalogue, cached since it is used on every listing save and search
+func amenityLookup() (map[string]models.Amenity, error) {
+	amenityIndexLock.Lock()
+	defer amenityIndexLock.Unlock()
+
+	if amenityIndex != nil && time.Since(amenityIndexAt) < amenityCacheTTL {
+		return amenityIndex, nil
+	}
+
+	amenities, err := ListAmenities()
+	if err != nil {
+		return nil, err
+	}
+
+	index := map[string]models.Amenity{}
+	for _, amenity := range amenities {
+		var synonyms []string
+		if len(amenity.Synonyms) > 0 {
+			if err := json.Unmarshal(amenity.Synonyms, &synonyms); err != nil {
+				return nil, err
+			}
+		}
+		for _, name := range append(synonyms, amenity.Name) {
+			if key := amenityLookupKey(name); key != "" {
+				index[key] = amenity
+			}
+		}
+	}
+	// keys win over a synonym that happens to spell another key
+	for _, amenity := range amenities {
+		index[amenityLookupKey(amenity.Key)] = amenity
+	}
+
+	amenityIndex, amenityIndexAt = index, time.Now()
+	return index, nil
+}
+
+// resolve free form amenity names to catalogue keys, names the catalogue does not know come back cleaned up in unknown
+func NormalizeAmenities(names []string) ([]string, []string, error) {
+	index, err := amenityLookup()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys, unknown := []string{}, []string{}
+	seen := map[string]bool{}
+	for _, name := range CleanAmenities(names) {
+		amenity, ok := index[amenityLookupKey(name)]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		if !seen[amenity.Key] {
+			seen[amenity.Key] = true
+			keys = append(keys, amenity.Key)
+		}
+	}
+	return keys, unknown, nil
+}
+
+// point a listing at the catalogue entries for keys, replacing whatever it was linked to before
+func SetListingAmenities(tx *gorm.DB, propertyID uint, keys []string) error {
+	property := models.Property{}
+	property.ID = propertyID
+
+	var err error
+	if len(keys) == 0 {
+		err = tx.Model(&property).Association("CatalogueAmenities").Clear()
+	} else {
+		var amenities []models.Amenity
+		if err := tx.Where("key IN ?", keys).Find(&amenities).Error; err != nil {
+			return err
+		}
+		err = tx.Model(&property).Association("CatalogueAmenities").Replace(amenities)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Model(&models.Property{}).Where("id = ?", propertyID).UpdateColumn("amenities_linked_at", time.Now()).Error
+}
+
+// names stored in a free form amenities column, anything that is not a json list of strings counts as none
+func storedAmenities(raw json.RawMessage) []string {
+	var names []string
+	if len(raw) == 0 || json.Unmarshal(raw, &names) != nil {
+		return nil
+	}
+	return names
+}
+
+// link every listing to the catalogue from its free form amenities and rewrite saved preferences to catalogue keys,
+// scraped amenities are left as they were scraped; returns how many listings and preferences were processed
+func BackfillAmenities() (int, int, error) {
+	listings := 0
+	var properties []models.Property
+	err := connector.DB.Select("id", "amenities").FindInBatches(&properties, amenityBackfillBatch, func(tx *gorm.DB, batch int) error {
+		for _, property := range properties {
+			keys, _, err := NormalizeAmenities(storedAmenities(property.Amenities))
+			if err != nil {
+				return err
+			}
+			if err := SetListingAmenities(connector.DB, property.ID, keys); err != nil {
+				return err
+			}
+			listings++
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return listings, 0, err
+	}
+
+	preferences := 0
+	var saved []models.Preferences
+	err = connector.DB.Select("id", "amenities").FindInBatches(&saved, amenityBackfillBatch, func(tx *gorm.DB, batch int) error {
+		for _, preference := range saved {
+			normalized, err := PreferenceAmenities(storedAmenities(preference.AMENITIES))
+			if err != nil {
+				return err
+			}
+			if err := connector.DB.Model(&models.Preferences{}).Where("id = ?", preference.ID).Update("amenities", normalized).Error; err != nil {
+				return err
+			}
+			preferences++
+		}
+		return nil
+	}).Error
+	return listings, preferences, err
+}
+
+// link one listing from its free form amenities unless another instance is already doing so or it has been linked since
+func linkPendingListing(propertyID uint) (bool, error) {
+	linked := false
+	err := connector.DB.Transaction(func(tx *gorm.DB) error {
+		var property models.Property
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Select("id", "amenities").Where(pendingAmenityLinksSQL).Limit(1).Find(&property, propertyID)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		keys, _, err := NormalizeAmenities(storedAmenities(property.Amenities))
+		if err != nil {
+			return err
+		}
+		if err := SetListingAmenities(tx, property.ID, keys); err != nil {
+			return err
+		}
+		linked = true
+		return nil
+	})
+	return linked, err
+}
+
+// link listings that were scraped or rescraped since they were last linked, the scraper writes to the table
+// directly so this is what makes its listings match amenity searches; returns how many were linked
+func LinkPendingAmenities() (int, error) {
+	linked := 0
+	var lastID uint
+	for {
+		var ids []uint
+		result := connector.DB.Model(&models.Property{}).Where("id > ?", lastID).Where(pendingAmenityLinksSQL).
+			Order("id").Limit(amenityBackfillBatch).Pluck("id", &ids)
+		if result.Error != nil {
+			return linked, result.Error
+		}
+
+		for _, id := range ids {
+			ok, err := linkPendingListing(id)
+			if err != nil {
+				return linked, err
+			}
+			if ok {
+				linked++
+			}
+		}
+		if len(ids) < amenityBackfillBatch {
+			return linked, nil
+		}
+		lastID = ids[len(ids)-1]
+	}
+}
+
+// link new scrapes in the background, the first run covers every listing saved before links existed; safe to
+// call more than once and to run on every instance since each listing is locked while it is linked
+func StartAmenityLinker() {
+	amenityLinkerOnce.Do(func() {
+		go func() {
+			for {
+				linked, err := LinkPendingAmenities()
+				if err != nil {
+					log.Printf("Error occurred trying to link listing amenities:\n %v", err)
+				} else if linked > 0 {
+					log.Printf("Linked amenities of %d listings\n", linked)
+				}
+				time.Sleep(amenityLinkInterval)
+			}
+		}()
+	})
+}
+
+// amenities of a saved preference as stored, catalogue keys first and then anything the catalogue does not
+// know so the recommendation prompt still sees it
+func PreferenceAmenities(names []string) (json.RawMessage, error) {
+	stored, _, err := ListingAmenities(names)
+	return stored, err
+}
+
+// amenities of a listing as stored, the same way as PreferenceAmenities, and the catalogue keys to link it to;
+// names the catalogue does not know are kept but not linked
+func ListingAmenities(names []string) (json.RawMessage, []string, error) {
+	keys, unknown, err := NormalizeAmenities(names)
+	if err != nil {
+		return nil, nil, err
+	}
+	stored := slices.Clone(keys)
+	for _, name := range unknown {
+		stored = append(stored, strings.ToLower(name))
+	}
+	encoded, err := json.Marshal(stored)
+	return encoded, keys, err
+}
@@ -0,0 +1,179 @@
+package property_utils
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+)
+
+func TestAmenityLookupKey(t *testing.T) {
+	tests := map[string]string{
+		"Wi-Fi":              "wifi",
+		"WiFi":               "wifi",
+		" wi fi ":            "wifi",
+		"A/C":                "ac",
+		"24/7 Security":      "247security",
+		"Bed & Breakfast":    "bedandbreakfast",
+		"Servants' quarters": "servantsquarters",
+		"":                   "",
+	}
+	for name, want := range tests {
+		if got := amenityLookupKey(name); got != want {
+			t.Errorf("amenityLookupKey(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// listings the scraper writes straight to the table have free form amenities and no links, they must match
+// amenity searches once the linker has run and be relinked when a later scrape changes them
+func TestLinkPendingAmenitiesForScrapedListings(t *testing.T) {
+	db := withTestDB(t)
+	if err := SeedAmenities(); err != nil {
+		t.Fatalf("SeedAmenities: %v", err)
+	}
+
+	const city = "Scrapeville"
+	scraped := func(title string, amenities ...string) models.Property {
+		raw, _ := json.Marshal(amenities)
+		property := models.Property{Title: title, PropertyType: "house", City: city, SourceWebsite: "scraper", Amenities: raw, Published: true, LastScrapedAt: time.Now().Add(-time.Minute)}
+		if err := db.Create(&property).Error; err != nil {
+			t.Fatal(err)
+		}
+		return property
+	}
+	dstv := scraped("dstv and borehole", "DSTV", "Borehole water", "Sea view")
+	wifi := scraped("wifi only", "Wi-Fi")
+	scraped("nothing known", "Sea view")
+
+	search := func(keys ...string) []string {
+		t.Helper()
+		properties, _, err := SearchProperties(SearchFilter{City: city, Amenities: keys})
+		if err != nil {
+			t.Fatal(err)
+		}
+		titles := []string{}
+		for _, property := range properties {
+			titles = append(titles, property.Title)
+		}
+		return titles
+	}
+
+	if got := search("satellite_tv"); len(got) != 0 {
+		t.Fatalf("unlinked listings matched %v", got)
+	}
+
+	linked, err := LinkPendingAmenities()
+	if err != nil {
+		t.Fatalf("LinkPendingAmenities: %v", err)
+	}
+	if linked < 3 {
+		t.Errorf("linked %d listings, want at least the 3 scraped ones", linked)
+	}
+	if got := search("satellite_tv", "borehole"); !reflect.DeepEqual(got, []string{"dstv and borehole"}) {
+		t.Errorf("satellite_tv and borehole matched %v", got)
+	}
+	if got := search("wifi"); !reflect.DeepEqual(got, []string{"wifi only"}) {
+		t.Errorf("wifi matched %v", got)
+	}
+
+	// nothing is left pending, including the listing none of whose amenities are in the catalogue
+	if again, err := LinkPendingAmenities(); err != nil || again != 0 {
+		t.Errorf("second run linked %d listings, %v", again, err)
+	}
+
+	// a rescrape that changes the amenities is picked up on the next run
+	rescraped, _ := json.Marshal([]string{"Wireless internet", "Swimming pool"})
+	db.Model(&models.Property{}).Where("id = ?", wifi.ID).
+		UpdateColumns(map[string]interface{}{"amenities": rescraped, "last_scraped_at": time.Now().Add(time.Minute)})
+	if _, err := LinkPendingAmenities(); err != nil {
+		t.Fatal(err)
+	}
+	if got := search("wifi", "pool"); !reflect.DeepEqual(got, []string{"wifi only"}) {
+		t.Errorf("wifi and pool after the rescrape matched %v", got)
+	}
+	if got := search("satellite_tv"); !reflect.DeepEqual(got, []string{dstv.Title}) {
+		t.Errorf("satellite_tv after the rescrape matched %v", got)
+	}
+}
+
+// a synonym is attached to scraped listings without anyone checking it, words that also describe something
+// else must not resolve to a specific amenity
+func TestDefaultAmenitySynonyms(t *testing.T) {
+	owner := map[string]string{}
+	for _, seed := range defaultAmenities {
+		for _, name := range append([]string{seed.Key, seed.Name}, seed.Synonyms...) {
+			key := amenityLookupKey(name)
+			if other, ok := owner[key]; ok && other != seed.Key {
+				t.Errorf("%q resolves to both %s and %s", name, other, seed.Key)
+			}
+			owner[key] = seed.Key
+		}
+	}
+
+	for _, generic := range []string{"security", "accessible", "tv", "internet", "wireless", "broadband", "solar", "scullery", "deck", "patio", "terrace", "well", "yard"} {
+		if key, ok := owner[amenityLookupKey(generic)]; ok {
+			t.Errorf("%q resolves to %s", generic, key)
+		}
+	}
+}
+
+func TestAmenitySpellingsChanged(t *testing.T) {
+	seeded := []models.Amenity{
+		{Key: "wifi", Name: "Wi-Fi", Synonyms: json.RawMessage(`["wireless internet","wlan"]`)},
+		{Key: "pool", Name: "Swimming pool", Synonyms: json.RawMessage(`["swimming pool"]`)},
+	}
+	// postgres hands jsonb back with its own spacing
+	stored := []models.Amenity{
+		{Key: "wifi", Name: "Wi-Fi", Synonyms: json.RawMessage(`["wireless internet", "wlan"]`)},
+		{Key: "pool", Name: "Swimming pool", Synonyms: json.RawMessage(`["swimming pool"]`)},
+	}
+	if amenitySpellingsChanged(stored, seeded) {
+		t.Error("unchanged catalogue reported as changed")
+	}
+
+	removed := []models.Amenity{stored[0], {Key: "pool", Name: "Swimming pool", Synonyms: json.RawMessage(`["swimming pool", "pool access"]`)}}
+	if !amenitySpellingsChanged(removed, seeded) {
+		t.Error("a dropped synonym was not noticed")
+	}
+	if !amenitySpellingsChanged(stored[:1], seeded) {
+		t.Error("a new entry was not noticed")
+	}
+	renamed := []models.Amenity{{Key: "wifi", Name: "WiFi", Synonyms: stored[0].Synonyms}, stored[1]}
+	if !amenitySpellingsChanged(renamed, seeded) {
+		t.Error("a renamed entry was not noticed")
+	}
+}
+
+// free form names are kept for display, only the ones the catalogue knows are linked
+func TestListingAmenities(t *testing.T) {
+	wifi := models.Amenity{Key: "wifi", Name: "Wi-Fi"}
+	pool := models.Amenity{Key: "pool", Name: "Swimming pool"}
+	amenityIndexLock.Lock()
+	saved, savedAt := amenityIndex, amenityIndexAt
+	amenityIndex = map[string]models.Amenity{"wifi": wifi, "wirelessinternet": wifi, "pool": pool, "swimmingpool": pool}
+	amenityIndexAt = time.Now()
+	amenityIndexLock.Unlock()
+	t.Cleanup(func() {
+		amenityIndexLock.Lock()
+		amenityIndex, amenityIndexAt = saved, savedAt
+		amenityIndexLock.Unlock()
+	})
+
+	stored, keys, err := ListingAmenities([]string{"Wireless internet", "Sea View", "WiFi", "swimming pool", "Solar geyser"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"wifi", "pool"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("linked keys %v, want %v", keys, want)
+	}
+	var names []string
+	if err := json.Unmarshal(stored, &names); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"wifi", "pool", "sea view", "solar geyser"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("stored %v, want %v", names, want)
+	}
+}
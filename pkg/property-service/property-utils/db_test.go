@@ -0,0 +1,89 @@
+package property_utils
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// a postgres session that builds statements without sending them, for checking the sql a query produces
+func dryRunDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.Open("host=127.0.0.1 user=smart_prop dbname=smart_prop"), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+		Logger:               logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open dry run session: %v", err)
+	}
+	return db
+}
+
+// the statement a dry run query built, with whitespace collapsed so expectations can be written on one line
+func builtSQL(result *gorm.DB) (string, []interface{}) {
+	return strings.Join(strings.Fields(result.Statement.SQL.String()), " "), result.Statement.Vars
+}
+
+// the last query sent through a dry run connector.DB
+type capturedQuery struct {
+	SQL  string
+	Vars []interface{}
+}
+
+// point connector.DB at a dry run session for the length of the test and record what each query would send
+func dryRunConnector(t *testing.T) *capturedQuery {
+	t.Helper()
+	db := dryRunDB(t)
+	captured := &capturedQuery{}
+	err := db.Callback().Query().After("gorm:query").Register("test:capture", func(tx *gorm.DB) {
+		captured.SQL, captured.Vars = builtSQL(tx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := connector.DB
+	connector.DB = db
+	t.Cleanup(func() { connector.DB = previous })
+	return captured
+}
+
+// point connector.DB at a transaction on TEST_DATABASE_URL for the length of the test, it is rolled back afterwards
+// so tests can insert freely; skipped when no test database is configured
+func withTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to connect to the test database: %v", err)
+	}
+	if err := db.AutoMigrate(models.User{}, models.Amenity{}, models.Property{}, models.PropertyImage{}); err != nil {
+		t.Fatalf("failed to migrate the test database: %v", err)
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		t.Fatalf("failed to start a test transaction: %v", tx.Error)
+	}
+	previous := connector.DB
+	connector.DB = tx
+	t.Cleanup(func() {
+		connector.DB = previous
+		tx.Rollback()
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return tx
+}
@@ -0,0 +1,131 @@
+package property_utils
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	geo_service "github.com/Brian-Mashavakure/smart-prop-server/pkg/geo-service"
+	"gorm.io/gorm"
+)
+
+const (
+	MaxNearbyRadiusKm  = 500
+	DefaultGeoLimit    = 50
+	MaxGeoLimit        = 200
+	MaxGeocodeBatch    = 1000
+	defaultGeocodeSize = 100
+)
+
+// great circle distance in km from the point bound to the three placeholders (lat, lat, lng)
+const distanceSQL = `2 * 6371 * asin(least(1, sqrt(
+	power(sin(radians(latitude - ?) / 2), 2) +
+	cos(radians(?)) * cos(radians(latitude)) * power(sin(radians(longitude - ?) / 2), 2)
+)))`
+
+// a listing with how far it is from the searched point
+type NearbyProperty struct {
+	models.Property
+	DistanceKm float64 `json:"distance_km"`
+}
+
+func clampGeoLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultGeoLimit
+	}
+	if limit > MaxGeoLimit {
+		return MaxGeoLimit
+	}
+	return limit
+}
+
+// keep only coordinates inside the bounds, handling viewports that cross the antimeridian
+func withinBounds(query *gorm.DB, bounds geo_service.Bounds) *gorm.DB {
+	query = query.Where("latitude BETWEEN ? AND ?", bounds.MinLat, bounds.MaxLat)
+	if bounds.MinLng > bounds.MaxLng {
+		return query.Where("(longitude >= ? OR longitude <= ?)", bounds.MinLng, bounds.MaxLng)
+	}
+	return query.Where("longitude BETWEEN ? AND ?", bounds.MinLng, bounds.MaxLng)
+}
+
+// published listings within radiusKm of center, closest first
+func NearbyProperties(center geo_service.Point, radiusKm float64, limit int) ([]NearbyProperty, error) {
+	query := connector.DB.Model(&models.Property{}).
+		Select("properties.*, "+distanceSQL+" AS distance_km", center.Lat, center.Lat, center.Lng).
+		Where("published = ? AND latitude IS NOT NULL AND longitude IS NOT NULL", true)
+	query = withinBounds(query, geo_service.BoundsAround(center, radiusKm)).
+		Where(distanceSQL+" <= ?", center.Lat, center.Lat, center.Lng, radiusKm)
+
+	var properties []NearbyProperty
+	result := query.Order("distance_km, id").Limit(clampGeoLimit(limit)).Find(&properties)
+	return properties, result.Error
+}
+
+// published listings inside a map viewport, ordered by distance from center when it is given
+func PropertiesInBounds(bounds geo_service.Bounds, center *geo_service.Point, limit int) ([]NearbyProperty, error) {
+	query := connector.DB.Model(&models.Property{}).
+		Where("published = ? AND latitude IS NOT NULL AND longitude IS NOT NULL", true)
+	query = withinBounds(query, bounds)
+
+	if center != nil {
+		query = query.Select("properties.*, "+distanceSQL+" AS distance_km", center.Lat, center.Lat, center.Lng).Order("distance_km, id")
+	} else {
+		query = query.Select("properties.*, 0 AS distance_km").Order("id")
+	}
+
+	var properties []NearbyProperty
+	result := query.Limit(clampGeoLimit(limit)).Find(&properties)
+	return properties, result.Error
+}
+
+// coordinates for a listing's address, nil when the geocoder cannot place it
+func GeocodeAddress(address string, city string) (*geo_service.Point, error) {
+	point, err := geo_service.GetGeocoder().Geocode(address, city)
+	if errors.Is(err, geo_service.ErrLocationNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &point, nil
+}
+
+// geocode listings without coordinates, addresses that failed before are skipped unless retryFailed is set
+func GeocodeMissing(batchSize int, retryFailed bool) (int, int, error) {
+	if batchSize <= 0 {
+		batchSize = defaultGeocodeSize
+	}
+	if batchSize > MaxGeocodeBatch {
+		batchSize = MaxGeocodeBatch
+	}
+
+	query := connector.DB.Where("latitude IS NULL OR longitude IS NULL")
+	if !retryFailed {
+		query = query.Where("geocode_failed_at IS NULL")
+	}
+	var properties []models.Property
+	if err := query.Order("id").Limit(batchSize).Find(&properties).Error; err != nil {
+		return 0, 0, err
+	}
+
+	geocoded, failed := 0, 0
+	for _, property := range properties {
+		point, err := GeocodeAddress(property.Address, property.City)
+		if err != nil {
+			return geocoded, failed, err
+		}
+
+		updates := map[string]interface{}{"geocode_failed_at": time.Now()}
+		if point != nil {
+			updates = map[string]interface{}{"latitude": point.Lat, "longitude": point.Lng, "geocode_failed_at": nil}
+			geocoded++
+		} else {
+			failed++
+		}
+		if err := connector.DB.Model(&models.Property{}).Where("id = ?", property.ID).Updates(updates).Error; err != nil {
+			return geocoded, failed, err
+		}
+	}
+	return geocoded, failed, nil
+}
@@ -0,0 +1,223 @@
+package property_utils
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	geo_service "github.com/Brian-Mashavakure/smart-prop-server/pkg/geo-service"
+)
+
+// reference great circle distance the sql has to agree with
+func haversineKm(a, b geo_service.Point) float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat, dLng := rad(b.Lat-a.Lat), rad(b.Lng-a.Lng)
+	h := math.Pow(math.Sin(dLat/2), 2) + math.Cos(rad(a.Lat))*math.Cos(rad(b.Lat))*math.Pow(math.Sin(dLng/2), 2)
+	return 2 * geo_service.EarthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// distanceSQL run by postgres, bound the way NearbyProperties binds it
+func TestDistanceSQL(t *testing.T) {
+	db := withTestDB(t)
+
+	harare := geo_service.Point{Lat: -17.8252, Lng: 31.0335}
+	tests := []struct {
+		name     string
+		center   geo_service.Point
+		property geo_service.Point
+		wantKm   float64
+	}{
+		{name: "same point", center: harare, property: harare, wantKm: 0},
+		{name: "one degree along the equator", center: geo_service.Point{Lat: 0, Lng: 10}, property: geo_service.Point{Lat: 0, Lng: 11}, wantKm: 111.195},
+		{name: "one degree of latitude", center: geo_service.Point{Lat: 10, Lng: 0}, property: geo_service.Point{Lat: 11, Lng: 0}, wantKm: 111.195},
+		{name: "harare to bulawayo", center: harare, property: geo_service.Point{Lat: -20.1325, Lng: 28.6265}, wantKm: 360.37},
+		{name: "across the antimeridian", center: geo_service.Point{Lat: -18, Lng: 179.9}, property: geo_service.Point{Lat: -18, Lng: -179.9}, wantKm: 21.15},
+		{name: "over the pole", center: geo_service.Point{Lat: 89.5, Lng: 0}, property: geo_service.Point{Lat: 89.5, Lng: 180}, wantKm: 111.195},
+		{name: "antipodes", center: geo_service.Point{Lat: 0, Lng: 0}, property: geo_service.Point{Lat: 0, Lng: 180}, wantKm: math.Pi * geo_service.EarthRadiusKm},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got float64
+			query := "SELECT " + distanceSQL + " FROM (SELECT ?::float8 AS latitude, ?::float8 AS longitude) AS listing"
+			err := db.Raw(query, tt.center.Lat, tt.center.Lat, tt.center.Lng, tt.property.Lat, tt.property.Lng).Row().Scan(&got)
+			if err != nil {
+				t.Fatalf("running distanceSQL: %v", err)
+			}
+			if math.IsNaN(got) {
+				t.Fatal("distance is NaN")
+			}
+			if want := haversineKm(tt.center, tt.property); math.Abs(got-want) > 1e-6 {
+				t.Errorf("sql gives %.6fkm, haversine %.6fkm", got, want)
+			}
+			if math.Abs(got-tt.wantKm) > 0.1 {
+				t.Errorf("sql gives %.3fkm, want about %.3fkm", got, tt.wantKm)
+			}
+		})
+	}
+}
+
+func TestWithinBoundsSQL(t *testing.T) {
+	tests := []struct {
+		name     string
+		bounds   geo_service.Bounds
+		wantSQL  string
+		wantVars []interface{}
+	}{
+		{
+			name:     "viewport",
+			bounds:   geo_service.Bounds{MinLat: -18, MinLng: 30.9, MaxLat: -17.7, MaxLng: 31.2},
+			wantSQL:  "WHERE (latitude BETWEEN $1 AND $2) AND (longitude BETWEEN $3 AND $4) AND",
+			wantVars: []interface{}{-18.0, -17.7, 30.9, 31.2},
+		},
+		{
+			name:     "crossing the antimeridian",
+			bounds:   geo_service.Bounds{MinLat: -19, MinLng: 179, MaxLat: -17, MaxLng: -179},
+			wantSQL:  "WHERE (latitude BETWEEN $1 AND $2) AND ((longitude >= $3 OR longitude <= $4)) AND",
+			wantVars: []interface{}{-19.0, -17.0, 179.0, -179.0},
+		},
+		{
+			name:     "whole world",
+			bounds:   geo_service.Bounds{MinLat: -90, MinLng: -180, MaxLat: 90, MaxLng: 180},
+			wantSQL:  "WHERE (latitude BETWEEN $1 AND $2) AND (longitude BETWEEN $3 AND $4) AND",
+			wantVars: []interface{}{-90.0, 90.0, -180.0, 180.0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := dryRunDB(t)
+			var properties []models.Property
+			sql, vars := builtSQL(withinBounds(db.Model(&models.Property{}), tt.bounds).Find(&properties))
+			if !strings.Contains(sql, tt.wantSQL) {
+				t.Errorf("sql %q does not contain %q", sql, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(vars, tt.wantVars) {
+				t.Errorf("vars %v, want %v", vars, tt.wantVars)
+			}
+		})
+	}
+}
+
+// every point bound to the distance expressions has to be the searched centre, in lat, lat, lng order
+func TestNearbyPropertiesSQL(t *testing.T) {
+	captured := dryRunConnector(t)
+	center := geo_service.Point{Lat: -18, Lng: 179.9}
+	if _, err := NearbyProperties(center, 50, 500); err != nil {
+		t.Fatal(err)
+	}
+
+	box := geo_service.BoundsAround(center, 50)
+	want := []interface{}{
+		center.Lat, center.Lat, center.Lng,
+		true,
+		box.MinLat, box.MaxLat, box.MinLng, box.MaxLng,
+		center.Lat, center.Lat, center.Lng, 50.0,
+		MaxGeoLimit,
+	}
+	if !reflect.DeepEqual(captured.Vars, want) {
+		t.Errorf("vars %v, want %v", captured.Vars, want)
+	}
+	for _, fragment := range []string{
+		"AS distance_km FROM",
+		"(longitude >= $7 OR longitude <= $8)",
+		"))) <= $12",
+		`"properties"."deleted_at" IS NULL ORDER BY distance_km, id LIMIT $13`,
+	} {
+		if !strings.Contains(captured.SQL, fragment) {
+			t.Errorf("sql %q does not contain %q", captured.SQL, fragment)
+		}
+	}
+}
+
+func TestPropertiesInBoundsSQL(t *testing.T) {
+	captured := dryRunConnector(t)
+	bounds := geo_service.Bounds{MinLat: -19, MinLng: 179, MaxLat: -17, MaxLng: -179}
+
+	if _, err := PropertiesInBounds(bounds, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(captured.SQL, "SELECT properties.*, 0 AS distance_km FROM") || !strings.HasSuffix(captured.SQL, "ORDER BY id LIMIT $6") {
+		t.Errorf("without a centre: %q", captured.SQL)
+	}
+	if want := []interface{}{true, -19.0, -17.0, 179.0, -179.0, DefaultGeoLimit}; !reflect.DeepEqual(captured.Vars, want) {
+		t.Errorf("without a centre vars %v, want %v", captured.Vars, want)
+	}
+
+	center := geo_service.Point{Lat: -18, Lng: -179.5}
+	if _, err := PropertiesInBounds(bounds, &center, 20); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(captured.SQL, "ORDER BY distance_km, id LIMIT $9") {
+		t.Errorf("with a centre: %q", captured.SQL)
+	}
+	if want := []interface{}{-18.0, -18.0, -179.5, true, -19.0, -17.0, 179.0, -179.0, 20}; !reflect.DeepEqual(captured.Vars, want) {
+		t.Errorf("with a centre vars %v, want %v", captured.Vars, want)
+	}
+}
+
+// the same searches against postgres, including listings either side of the antimeridian
+func TestNearbyPropertiesDatabase(t *testing.T) {
+	db := withTestDB(t)
+
+	points := map[string]geo_service.Point{
+		"suva":        {Lat: -18.1416, Lng: 178.4419},
+		"east of 180": {Lat: -18, Lng: 179.9},
+		"west of 180": {Lat: -18, Lng: -179.9},
+		"taveuni":     {Lat: -16.8, Lng: -179.97},
+		"harare":      {Lat: -17.8252, Lng: 31.0335},
+	}
+	for title, point := range points {
+		lat, lng := point.Lat, point.Lng
+		property := models.Property{Title: title, PropertyType: "house", SourceWebsite: "test", Published: true, Latitude: &lat, Longitude: &lng}
+		if err := db.Create(&property).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// unpublished listings never show up
+	lat, lng := -18.0, 179.95
+	if err := db.Create(&models.Property{Title: "draft", PropertyType: "house", SourceWebsite: "test", Latitude: &lat, Longitude: &lng}).Error; err != nil {
+		t.Fatal(err)
+	}
+	db.Model(&models.Property{}).Where("title = ?", "draft").Update("published", false)
+
+	center := points["east of 180"]
+	for _, radiusKm := range []float64{1, 25, 150, 250} {
+		found, err := NearbyProperties(center, radiusKm, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var want []string
+		for title, point := range points {
+			if haversineKm(center, point) <= radiusKm {
+				want = append(want, title)
+			}
+		}
+		if len(found) != len(want) {
+			t.Fatalf("within %.0fkm found %d listings, want %v", radiusKm, len(found), want)
+		}
+		for i, property := range found {
+			if expected := haversineKm(center, points[property.Title]); math.Abs(property.DistanceKm-expected) > 1e-6 {
+				t.Errorf("%s is %.6fkm away, want %.6fkm", property.Title, property.DistanceKm, expected)
+			}
+			if i > 0 && found[i-1].DistanceKm > property.DistanceKm {
+				t.Errorf("results are not ordered by distance")
+			}
+		}
+	}
+
+	inBounds, err := PropertiesInBounds(geo_service.Bounds{MinLat: -19, MinLng: 179, MaxLat: -16, MaxLng: -179}, &center, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, property := range inBounds {
+		titles = append(titles, property.Title)
+	}
+	if want := []string{"east of 180", "west of 180", "taveuni"}; !reflect.DeepEqual(titles, want) {
+		t.Errorf("viewport across the antimeridian found %v, want %v", titles, want)
+	}
+}
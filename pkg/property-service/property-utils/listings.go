@@ -0,0 +1,90 @@
+package property_utils
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	auth_utils "github.com/Brian-Mashavakure/smart-prop-server/pkg/auth-service/auth-utils"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	"gorm.io/gorm"
+)
+
+const (
+	PricePeriodNight = "night"
+	PricePeriodWeek  = "week"
+	PricePeriodMonth = "month"
+	PricePeriodYear  = "year"
+	// a purchase price rather than rent
+	PricePeriodSale = "sale"
+
+	// source_website of listings created through the api rather than scraped
+	ListingSource = "smart-prop"
+)
+
+var (
+	ErrListingNotFound  = errors.New("listing not found")
+	ErrNotListingOwner  = errors.New("listing belongs to another account")
+	ErrListingHasActive = errors.New("listing has active bookings")
+)
+
+// trim amenities and drop blanks and case-insensitive duplicates, keeping the first spelling
+func CleanAmenities(amenities []string) []string {
+	seen := map[string]bool{}
+	cleaned := make([]string, 0, len(amenities))
+	for _, amenity := range amenities {
+		amenity = strings.Join(strings.Fields(amenity), " ")
+		key := strings.ToLower(amenity)
+		if amenity == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		cleaned = append(cleaned, amenity)
+	}
+	return cleaned
+}
+
+// load a listing the user is allowed to change, admins may manage any listing including scraped ones
+func ManagedListing(propertyID uint, userID uint, roles []string) (models.Property, error) {
+	var property models.Property
+	if err := connector.DB.First(&property, propertyID).Error; err != nil {
+		return models.Property{}, ErrListingNotFound
+	}
+
+	if auth_utils.HasAnyRole(roles, auth_utils.RoleAdmin) {
+		return property, nil
+	}
+	if property.OwnerID == nil || *property.OwnerID != userID {
+		return models.Property{}, ErrNotListingOwner
+	}
+	return property, nil
+}
+
+// every listing owned by the user with its images, unpublished ones included, newest first
+func OwnerListings(ownerID uint) ([]models.Property, error) {
+	var properties []models.Property
+	result := connector.DB.Preload("Images", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position, id")
+	}).Preload("CatalogueAmenities").Where("owner_id = ?", ownerID).Order("created_at desc").Find(&properties)
+	return properties, result.Error
+}
+
+func SetListingPublished(propertyID uint, published bool) error {
+	return connector.DB.Model(&models.Property{}).Where("id = ?", propertyID).Update("published", published).Error
+}
+
+// soft delete a listing, refused while bookings that have not checked out yet are still active
+func DeleteListing(propertyID uint) error {
+	var active int64
+	today := time.Now().Format("2006-01-02")
+	count := connector.DB.Model(&models.Booking{}).Where("property_id = ? AND status = ? AND checkout_date >= ?", propertyID, "active", today).Count(&active)
+	if count.Error != nil {
+		return count.Error
+	}
+	if active > 0 {
+		return ErrListingHasActive
+	}
+
+	return connector.DB.Delete(&models.Property{}, propertyID).Error
+}
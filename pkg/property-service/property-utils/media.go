@@ -0,0 +1,242 @@
+package property_utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	media_service "github.com/Brian-Mashavakure/smart-prop-server/pkg/media-service"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const MaxListingImages = 30
+
+var (
+	ErrImageNotFound      = errors.New("image not found")
+	ErrTooManyImages      = fmt.Errorf("a listing can have at most %d images", MaxListingImages)
+	ErrImageOrderMismatch = errors.New("image order must list every image of the listing exactly once")
+)
+
+// images of a listing in display order
+func ListingImages(propertyID uint) ([]models.PropertyImage, error) {
+	var images []models.PropertyImage
+	result := connector.DB.Where("property_id = ?", propertyID).Order("position, id").Find(&images)
+	return images, result.Error
+}
+
+// every key stored for an image, the original first
+func imageKeys(image models.PropertyImage) []string {
+	keys := []string{media_service.OriginalKey(image.StorageKey, media_service.ExtensionFor(image.ContentType))}
+	for _, size := range media_service.ThumbnailSizes {
+		keys = append(keys, media_service.ThumbnailKey(image.StorageKey, size))
+	}
+	return keys
+}
+
+// best effort, a failed delete only leaves an unreferenced file behind
+func deleteStoredKeys(keys []string) {
+	storage := media_service.GetStorage()
+	for _, key := range keys {
+		if err := storage.Delete(key); err != nil {
+			log.Printf("Error occurred trying to delete stored media %s:\n %v", key, err)
+		}
+	}
+}
+
+// lock the listing row for the rest of the transaction, image writes to one listing run one at a time
+// so the image limit, positions and cover are decided on a count nothing else is changing
+func lockListing(tx *gorm.DB, propertyID uint) error {
+	var property models.Property
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&property, propertyID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrListingNotFound
+	}
+	return err
+}
+
+// store an already processed upload and its thumbnails and add it after the listing's other images,
+// the first image of a listing becomes its cover; the count here only saves storing an upload that cannot fit,
+// the limit is enforced again under the listing lock
+func AddListingImage(propertyID uint, processed media_service.ProcessedImage) (models.PropertyImage, error) {
+	var count int64
+	if err := connector.DB.Model(&models.PropertyImage{}).Where("property_id = ?", propertyID).Count(&count).Error; err != nil {
+		return models.PropertyImage{}, err
+	}
+	if count >= MaxListingImages {
+		return models.PropertyImage{}, ErrTooManyImages
+	}
+
+	objectID, err := media_service.NewObjectID()
+	if err != nil {
+		return models.PropertyImage{}, err
+	}
+	prefix := fmt.Sprintf("properties/%d/%s", propertyID, objectID)
+
+	storage := media_service.GetStorage()
+	stored := []string{}
+	originalKey := media_service.OriginalKey(prefix, processed.Extension)
+	if err := storage.Put(originalKey, processed.Original, processed.ContentType); err != nil {
+		return models.PropertyImage{}, err
+	}
+	stored = append(stored, originalKey)
+
+	thumbnails := map[string]string{}
+	for _, size := range media_service.ThumbnailSizes {
+		key := media_service.ThumbnailKey(prefix, size)
+		if err := storage.Put(key, processed.Thumbnails[size], "image/jpeg"); err != nil {
+			deleteStoredKeys(stored)
+			return models.PropertyImage{}, err
+		}
+		stored = append(stored, key)
+		thumbnails[strconv.Itoa(size)] = storage.URL(key)
+	}
+
+	encodedThumbnails, err := json.Marshal(thumbnails)
+	if err != nil {
+		deleteStoredKeys(stored)
+		return models.PropertyImage{}, err
+	}
+
+	image := models.PropertyImage{
+		PropertyID:  propertyID,
+		ContentType: processed.ContentType,
+		Width:       processed.Width,
+		Height:      processed.Height,
+		SizeBytes:   int64(len(processed.Original)),
+		StorageKey:  prefix,
+		URL:         storage.URL(originalKey),
+		Thumbnails:  encodedThumbnails,
+	}
+	err = connector.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockListing(tx, propertyID); err != nil {
+			return err
+		}
+		var last struct {
+			Position int
+			Images   int
+		}
+		if err := tx.Model(&models.PropertyImage{}).Select("coalesce(max(position), 0) AS position, count(*) AS images").
+			Where("property_id = ?", propertyID).Scan(&last).Error; err != nil {
+			return err
+		}
+		if last.Images >= MaxListingImages {
+			return ErrTooManyImages
+		}
+		image.Position = last.Position + 1
+		image.IsCover = last.Images == 0
+		return tx.Create(&image).Error
+	})
+	if err != nil {
+		deleteStoredKeys(stored)
+		return models.PropertyImage{}, err
+	}
+	return image, nil
+}
+
+func findListingImage(tx *gorm.DB, propertyID uint, imageID uint) (models.PropertyImage, error) {
+	var image models.PropertyImage
+	if err := tx.Where("id = ? AND property_id = ?", imageID, propertyID).First(&image).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.PropertyImage{}, ErrImageNotFound
+		}
+		return models.PropertyImage{}, err
+	}
+	return image, nil
+}
+
+// remove an image and its files, the next image in order takes over as cover when the cover is deleted
+func DeleteListingImage(propertyID uint, imageID uint) error {
+	var deleted models.PropertyImage
+	err := connector.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockListing(tx, propertyID); err != nil {
+			return err
+		}
+		image, err := findListingImage(tx, propertyID, imageID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Delete(&image).Error; err != nil {
+			return err
+		}
+
+		if image.IsCover {
+			var next models.PropertyImage
+			result := tx.Where("property_id = ?", propertyID).Order("position, id").Limit(1).Find(&next)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected > 0 {
+				if err := tx.Model(&next).Update("is_cover", true).Error; err != nil {
+					return err
+				}
+			}
+		}
+		deleted = image
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	deleteStoredKeys(imageKeys(deleted))
+	return nil
+}
+
+// put the listing's images in the given order, imageIDs must hold every image of the listing once
+func ReorderListingImages(propertyID uint, imageIDs []uint) ([]models.PropertyImage, error) {
+	err := connector.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockListing(tx, propertyID); err != nil {
+			return err
+		}
+		var existing []uint
+		if err := tx.Model(&models.PropertyImage{}).Where("property_id = ?", propertyID).Pluck("id", &existing).Error; err != nil {
+			return err
+		}
+
+		wanted := map[uint]bool{}
+		for _, id := range existing {
+			wanted[id] = true
+		}
+		if len(imageIDs) != len(existing) {
+			return ErrImageOrderMismatch
+		}
+		for _, id := range imageIDs {
+			if !wanted[id] {
+				return ErrImageOrderMismatch
+			}
+			delete(wanted, id)
+		}
+
+		for position, id := range imageIDs {
+			if err := tx.Model(&models.PropertyImage{}).Where("id = ?", id).Update("position", position+1).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ListingImages(propertyID)
+}
+
+// make one image the cover, clearing it from the rest of the listing
+func SetCoverImage(propertyID uint, imageID uint) error {
+	return connector.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockListing(tx, propertyID); err != nil {
+			return err
+		}
+		if _, err := findListingImage(tx, propertyID, imageID); err != nil {
+			return err
+		}
+		if err := tx.Model(&models.PropertyImage{}).Where("property_id = ? AND id <> ?", propertyID, imageID).Update("is_cover", false).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.PropertyImage{}).Where("id = ?", imageID).Update("is_cover", true).Error
+	})
+}
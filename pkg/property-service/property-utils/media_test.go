@@ -0,0 +1,78 @@
+package property_utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	media_service "github.com/Brian-Mashavakure/smart-prop-server/pkg/media-service"
+)
+
+// uploads go to a temporary directory for the length of the test
+func withTestStorage(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	previous := media_service.GetStorage()
+	media_service.SetStorage(media_service.LocalStorage{Dir: dir, URLPrefix: "/media"})
+	t.Cleanup(func() { media_service.SetStorage(previous) })
+	return dir
+}
+
+func storedFiles(t *testing.T, dir string) int {
+	t.Helper()
+	files := 0
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			files++
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestAddListingImage(t *testing.T) {
+	db := withTestDB(t)
+	dir := withTestStorage(t)
+	processed := media_service.ProcessedImage{ContentType: "image/jpeg", Extension: "jpg", Width: 1, Height: 1, Original: []byte("original")}
+
+	if _, err := AddListingImage(999999, processed); !errors.Is(err, ErrListingNotFound) {
+		t.Fatalf("image for a missing listing: %v, want ErrListingNotFound", err)
+	}
+	if files := storedFiles(t, dir); files != 0 {
+		t.Errorf("a refused upload left %d files behind", files)
+	}
+
+	property := models.Property{Title: "flat", PropertyType: "flat", SourceWebsite: "test", Published: true}
+	if err := db.Create(&property).Error; err != nil {
+		t.Fatal(err)
+	}
+	first, err := AddListingImage(property.ID, processed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := AddListingImage(property.ID, processed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Position != 1 || !first.IsCover || second.Position != 2 || second.IsCover {
+		t.Errorf("first image at %d cover %v, second at %d cover %v", first.Position, first.IsCover, second.Position, second.IsCover)
+	}
+
+	for position := 3; position <= MaxListingImages; position++ {
+		if err := db.Create(&models.PropertyImage{PropertyID: property.ID, Position: position, StorageKey: "filler", Thumbnails: []byte("{}")}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := storedFiles(t, dir)
+	if _, err := AddListingImage(property.ID, processed); !errors.Is(err, ErrTooManyImages) {
+		t.Fatalf("image past the limit: %v, want ErrTooManyImages", err)
+	}
+	if after := storedFiles(t, dir); after != before {
+		t.Errorf("a refused upload left %d files behind", after-before)
+	}
+}
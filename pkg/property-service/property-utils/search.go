@@ -0,0 +1,259 @@
+package property_utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+)
+
+const (
+	SortNewest    = "newest"
+	SortPriceAsc  = "price_asc"
+	SortPriceDesc = "price_desc"
+	SortAreaDesc  = "area_desc"
+
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// scraped listings spell their period many ways ("per night", "pcm", "p.a."), this folds them into the
+// listing periods and gives the monthly equivalent of the price, NULL for sale prices; periods are matched as
+// whole words so "fortnight" is not a night and "holiday let" not a day
+const monthlyPriceSQL = `CASE
+	WHEN lower(price_period) ~ '\m(sale|once)\M' THEN NULL
+	WHEN lower(price_period) ~ '\m(night|nights|nightly|day|days|daily)\M' THEN price * 30
+	WHEN lower(price_period) ~ '\m(fortnight|fortnights|fortnightly)\M' THEN price * 26 / 12
+	WHEN lower(price_period) ~ '\m(week|weeks|weekly)\M' THEN price * 52 / 12
+	WHEN lower(price_period) ~ '\m(year|years|yearly|annum|annual|annually|pa)\M' OR lower(price_period) ~ '\mp\.a\M' THEN price / 12
+	ELSE price
+END`
+
+// months per period, used to turn a monthly equivalent into the period a search asked for
+var periodsPerMonth = map[string]float64{
+	PricePeriodNight: 30,
+	PricePeriodWeek:  52.0 / 12,
+	PricePeriodMonth: 1,
+	PricePeriodYear:  1.0 / 12,
+}
+
+var ErrInvalidCursor = errors.New("cursor is invalid or belongs to a different sort")
+
+// search filters, zero values are ignored; prices are compared in Currency and per PricePeriod and
+// Amenities are catalogue keys as returned by NormalizeAmenities
+type SearchFilter struct {
+	City         string
+	PropertyType string
+	MinPrice     *float64
+	MaxPrice     *float64
+	Currency     string
+	PricePeriod  string
+	MinBedrooms  *uint
+	MaxBedrooms  *uint
+	MinBathrooms *uint
+	MinArea      *float64
+	MaxArea      *float64
+	Amenities    []string
+	Sort         string
+	Cursor       string
+	Limit        int
+}
+
+// position after the last row of a page, Value is whatever the sort orders by
+type searchCursor struct {
+	Sort  string          `json:"s"`
+	Value json.RawMessage `json:"v"`
+	ID    uint            `json:"id"`
+}
+
+type searchRow struct {
+	models.Property
+	SortPrice *float64
+}
+
+func encodeCursor(sort string, value interface{}, id uint) (string, error) {
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	raw, err := json.Marshal(searchCursor{Sort: sort, Value: encodedValue, ID: id})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodeCursor(cursor string, sort string, value interface{}) (uint, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	var decoded searchCursor
+	if err := json.Unmarshal(raw, &decoded); err != nil || decoded.Sort != sort || decoded.ID == 0 {
+		return 0, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(decoded.Value, value); err != nil {
+		return 0, ErrInvalidCursor
+	}
+	return decoded.ID, nil
+}
+
+// sql for the price of a listing in the given search period, NULL where the listing cannot be compared
+func searchPriceSQL(period string) (string, bool) {
+	if period == PricePeriodSale {
+		return "CASE WHEN (" + monthlyPriceSQL + ") IS NULL THEN price END", true
+	}
+	perMonth, ok := periodsPerMonth[period]
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("(%s) / %g", monthlyPriceSQL, perMonth), true
+}
+
+// published listings matching the filter and the cursor for the next page, "" once there are no more
+func SearchProperties(filter SearchFilter) ([]models.Property, string, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultSearchLimit
+	}
+	if filter.Limit > MaxSearchLimit {
+		filter.Limit = MaxSearchLimit
+	}
+	if filter.Sort == "" {
+		filter.Sort = SortNewest
+	}
+	if filter.PricePeriod == "" {
+		filter.PricePeriod = PricePeriodMonth
+	}
+	if filter.Currency == "" {
+		filter.Currency = "USD"
+	}
+
+	priceSQL, ok := searchPriceSQL(filter.PricePeriod)
+	if !ok {
+		return nil, "", fmt.Errorf("unknown price period %q", filter.PricePeriod)
+	}
+
+	query := connector.DB.Model(&models.Property{}).
+		Select("properties.*, ("+priceSQL+") AS sort_price").
+		Where("published = ?", true)
+
+	if filter.City != "" {
+		query = query.Where("lower(city) = lower(?)", strings.TrimSpace(filter.City))
+	}
+	if filter.PropertyType != "" {
+		query = query.Where("lower(property_type) = lower(?)", strings.TrimSpace(filter.PropertyType))
+	}
+
+	// prices in other currencies or periods that cannot be converted never match a price filter or sort
+	byPrice := filter.MinPrice != nil || filter.MaxPrice != nil || filter.Sort == SortPriceAsc || filter.Sort == SortPriceDesc
+	if byPrice {
+		query = query.Where("upper(currency) = ? AND ("+priceSQL+") IS NOT NULL", strings.ToUpper(filter.Currency))
+	}
+	if filter.MinPrice != nil {
+		query = query.Where("("+priceSQL+") >= ?", *filter.MinPrice)
+	}
+	if filter.MaxPrice != nil {
+		query = query.Where("("+priceSQL+") <= ?", *filter.MaxPrice)
+	}
+
+	if filter.MinBedrooms != nil {
+		query = query.Where("bedrooms >= ?", *filter.MinBedrooms)
+	}
+	if filter.MaxBedrooms != nil {
+		query = query.Where("bedrooms <= ?", *filter.MaxBedrooms)
+	}
+	if filter.MinBathrooms != nil {
+		query = query.Where("bathrooms >= ?", *filter.MinBathrooms)
+	}
+	if filter.MinArea != nil {
+		query = query.Where("area_sqft >= ?", *filter.MinArea)
+	}
+	if filter.MaxArea != nil {
+		query = query.Where("area_sqft <= ?", *filter.MaxArea)
+	}
+
+	// every requested amenity has to be linked to the listing, scraped listings are linked by the amenity linker
+	if len(filter.Amenities) > 0 {
+		query = query.Where(`id IN (
+			SELECT property_amenities.property_id FROM property_amenities
+			JOIN amenities ON amenities.id = property_amenities.amenity_id
+			WHERE amenities.key IN ? AND amenities.deleted_at IS NULL
+			GROUP BY property_amenities.property_id
+			HAVING count(DISTINCT amenities.key) = ?
+		)`, filter.Amenities, len(filter.Amenities))
+	}
+
+	var sortColumn, direction string
+	switch filter.Sort {
+	case SortNewest:
+		sortColumn, direction = "created_at", "desc"
+	case SortPriceAsc:
+		sortColumn, direction = "("+priceSQL+")", "asc"
+	case SortPriceDesc:
+		sortColumn, direction = "("+priceSQL+")", "desc"
+	case SortAreaDesc:
+		// scraped listings can have no area, they sort as 0 so the cursor taken from them still compares
+		sortColumn, direction = "coalesce(area_sqft, 0)", "desc"
+	default:
+		return nil, "", fmt.Errorf("unknown sort %q", filter.Sort)
+	}
+
+	if filter.Cursor != "" {
+		comparison := "<"
+		if direction == "asc" {
+			comparison = ">"
+		}
+		var lastID uint
+		var err error
+		if filter.Sort == SortNewest {
+			var lastCreated time.Time
+			lastID, err = decodeCursor(filter.Cursor, filter.Sort, &lastCreated)
+			query = query.Where("(created_at, id) "+comparison+" (?, ?)", lastCreated, lastID)
+		} else {
+			var lastValue float64
+			lastID, err = decodeCursor(filter.Cursor, filter.Sort, &lastValue)
+			query = query.Where("("+sortColumn+", id) "+comparison+" (?, ?)", lastValue, lastID)
+		}
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	var rows []searchRow
+	result := query.Order(sortColumn + " " + direction + ", id " + direction).Limit(filter.Limit + 1).Find(&rows)
+	if result.Error != nil {
+		return nil, "", result.Error
+	}
+
+	nextCursor := ""
+	if len(rows) > filter.Limit {
+		rows = rows[:filter.Limit]
+		last := rows[len(rows)-1]
+
+		var value interface{}
+		switch filter.Sort {
+		case SortNewest:
+			value = last.CreatedAt
+		case SortAreaDesc:
+			value = last.AreaSqft
+		default:
+			value = last.SortPrice
+		}
+		cursor, err := encodeCursor(filter.Sort, value, last.ID)
+		if err != nil {
+			return nil, "", err
+		}
+		nextCursor = cursor
+	}
+
+	properties := make([]models.Property, len(rows))
+	for i, row := range rows {
+		properties[i] = row.Property
+	}
+	return properties, nextCursor, nil
+}
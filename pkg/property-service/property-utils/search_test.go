@@ -0,0 +1,358 @@
+package property_utils
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+	"gorm.io/gorm"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	created := time.Date(2026, 5, 17, 9, 30, 12, 345678000, time.FixedZone("CAT", 2*60*60))
+
+	var gotTime time.Time
+	id, err := decodeCursor(mustEncodeCursor(t, SortNewest, created, 31), SortNewest, &gotTime)
+	if err != nil || id != 31 || !gotTime.Equal(created) {
+		t.Errorf("newest cursor decoded to %v, %d, %v", gotTime, id, err)
+	}
+
+	// prices and ranks are compared for equality against the database, so they must survive exactly
+	for _, value := range []float64{0, 1200, 1200.0 / 30, 52.0 / 12 * 300, 0.1 + 0.2, math.SmallestNonzeroFloat64, 1e300} {
+		var got float64
+		id, err := decodeCursor(mustEncodeCursor(t, SortPriceAsc, value, 7), SortPriceAsc, &got)
+		if err != nil || id != 7 || got != value {
+			t.Errorf("cursor for %v decoded to %v, %d, %v", value, got, id, err)
+		}
+	}
+}
+
+func mustEncodeCursor(t *testing.T, sort string, value interface{}, id uint) string {
+	t.Helper()
+	cursor, err := encodeCursor(sort, value, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cursor
+}
+
+func TestDecodeCursorRejects(t *testing.T) {
+	raw := func(json string) string { return base64.RawURLEncoding.EncodeToString([]byte(json)) }
+
+	tests := []struct {
+		name   string
+		cursor string
+		sort   string
+	}{
+		{name: "another sort", cursor: mustEncodeCursor(t, SortPriceAsc, 1200.0, 3), sort: SortPriceDesc},
+		{name: "relevance cursor on a price sort", cursor: mustEncodeCursor(t, SortRelevance, 0.4, 3), sort: SortPriceAsc},
+		{name: "not base64", cursor: "%%%", sort: SortNewest},
+		{name: "padded base64", cursor: base64.URLEncoding.EncodeToString([]byte(`{"s":"newest","v":"2026-01-01T00:00:00Z","id":31}`)), sort: SortNewest},
+		{name: "not json", cursor: raw("newest|3"), sort: SortNewest},
+		{name: "no id", cursor: raw(`{"s":"price_asc","v":1200}`), sort: SortPriceAsc},
+		{name: "zero id", cursor: raw(`{"s":"price_asc","v":1200,"id":0}`), sort: SortPriceAsc},
+		{name: "negative id", cursor: raw(`{"s":"price_asc","v":1200,"id":-4}`), sort: SortPriceAsc},
+		{name: "no value", cursor: raw(`{"s":"price_asc","id":4}`), sort: SortPriceAsc},
+		{name: "value of the wrong type", cursor: raw(`{"s":"price_asc","v":"cheap","id":4}`), sort: SortPriceAsc},
+		{name: "time that does not parse", cursor: raw(`{"s":"newest","v":"yesterday","id":4}`), sort: SortNewest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value interface{} = new(float64)
+			if tt.sort == SortNewest {
+				value = new(time.Time)
+			}
+			if _, err := decodeCursor(tt.cursor, tt.sort, value); !errors.Is(err, ErrInvalidCursor) {
+				t.Fatalf("error %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
+
+// the CASE folding scraped price periods, run by postgres for each period a search can ask for
+func TestSearchPriceSQL(t *testing.T) {
+	db := withTestDB(t)
+
+	tests := []struct {
+		price  float64
+		period string
+		want   map[string]*float64
+	}{
+		{1200, "month", map[string]*float64{PricePeriodMonth: monthly(1200), PricePeriodNight: monthly(40), PricePeriodWeek: monthly(1200 / (52.0 / 12)), PricePeriodYear: monthly(14400), PricePeriodSale: nil}},
+		{1200, "pcm", map[string]*float64{PricePeriodMonth: monthly(1200)}},
+		{1200, "", map[string]*float64{PricePeriodMonth: monthly(1200), PricePeriodSale: nil}},
+		{40, "per night", map[string]*float64{PricePeriodMonth: monthly(1200), PricePeriodNight: monthly(40)}},
+		{40, "Nightly", map[string]*float64{PricePeriodMonth: monthly(1200)}},
+		{40, "DAILY", map[string]*float64{PricePeriodMonth: monthly(1200), PricePeriodYear: monthly(14400)}},
+		{40, "per day", map[string]*float64{PricePeriodMonth: monthly(1200)}},
+		{300, "per week", map[string]*float64{PricePeriodMonth: monthly(1300), PricePeriodWeek: monthly(300)}},
+		{300, "Weekly", map[string]*float64{PricePeriodMonth: monthly(1300)}},
+		{600, "per fortnight", map[string]*float64{PricePeriodMonth: monthly(1300), PricePeriodWeek: monthly(300), PricePeriodNight: monthly(1300.0 / 30)}},
+		{600, "Fortnightly", map[string]*float64{PricePeriodMonth: monthly(1300)}},
+		// words that only contain a period are not that period
+		{1200, "holiday let", map[string]*float64{PricePeriodMonth: monthly(1200), PricePeriodNight: monthly(40)}},
+		{1200, "Holiday", map[string]*float64{PricePeriodMonth: monthly(1200)}},
+		{1200, "weekday rate", map[string]*float64{PricePeriodMonth: monthly(1200)}},
+		{1200, "month, bills included", map[string]*float64{PricePeriodMonth: monthly(1200)}},
+		{1200, "wholesale", map[string]*float64{PricePeriodMonth: monthly(1200), PricePeriodSale: nil}},
+		{14400, "year", map[string]*float64{PricePeriodMonth: monthly(1200), PricePeriodYear: monthly(14400), PricePeriodNight: monthly(40)}},
+		{14400, "Per Annum", map[string]*float64{PricePeriodMonth: monthly(1200)}},
+		{14400, "annually", map[string]*float64{PricePeriodMonth: monthly(1200)}},
+		{14400, "p.a.", map[string]*float64{PricePeriodMonth: monthly(1200)}},
+		{14400, "P.A.", map[string]*float64{PricePeriodMonth: monthly(1200)}},
+		{150000, "sale", map[string]*float64{PricePeriodSale: monthly(150000), PricePeriodMonth: nil, PricePeriodNight: nil}},
+		{150000, "For Sale", map[string]*float64{PricePeriodSale: monthly(150000), PricePeriodYear: nil}},
+		{5000, "once off", map[string]*float64{PricePeriodSale: monthly(5000), PricePeriodMonth: nil}},
+	}
+
+	for _, tt := range tests {
+		for period, want := range tt.want {
+			t.Run(fmt.Sprintf("%v %q as %s", tt.price, tt.period, period), func(t *testing.T) {
+				priceSQL, ok := searchPriceSQL(period)
+				if !ok {
+					t.Fatalf("period %q is not supported", period)
+				}
+				var got *float64
+				query := "SELECT " + priceSQL + " FROM (SELECT ?::float8 AS price, ?::text AS price_period) AS listing"
+				if err := db.Raw(query, tt.price, tt.period).Row().Scan(&got); err != nil {
+					t.Fatalf("running the price sql: %v", err)
+				}
+				if want == nil || got == nil {
+					if want != nil || got != nil {
+						t.Errorf("got %v, want %v", got, want)
+					}
+					return
+				}
+				if math.Abs(*got-*want) > 1e-9 {
+					t.Errorf("got %v, want %v", *got, *want)
+				}
+			})
+		}
+	}
+
+	if _, ok := searchPriceSQL("fortnight"); ok {
+		t.Error("unknown period was accepted")
+	}
+}
+
+func collapseSQL(sql string) string {
+	return strings.Join(strings.Fields(sql), " ")
+}
+
+// the cursor condition has to compare the same expression, in the same direction, as the ORDER BY
+func TestSearchPropertiesCursorSQL(t *testing.T) {
+	monthPrice, _ := searchPriceSQL(PricePeriodMonth)
+	nightPrice, _ := searchPriceSQL(PricePeriodNight)
+	created := time.Date(2026, 2, 3, 4, 5, 6, 7000, time.UTC)
+
+	tests := []struct {
+		name       string
+		filter     SearchFilter
+		value      interface{}
+		sortExpr   string
+		comparison string
+		direction  string
+	}{
+		{name: "newest", filter: SearchFilter{Sort: SortNewest}, value: created, sortExpr: "created_at", comparison: "<", direction: "desc"},
+		{name: "default sort", filter: SearchFilter{}, value: created, sortExpr: "created_at", comparison: "<", direction: "desc"},
+		{name: "cheapest", filter: SearchFilter{Sort: SortPriceAsc}, value: 1200.0, sortExpr: "(" + monthPrice + ")", comparison: ">", direction: "asc"},
+		{name: "cheapest per night", filter: SearchFilter{Sort: SortPriceAsc, PricePeriod: PricePeriodNight}, value: 40.0, sortExpr: "(" + nightPrice + ")", comparison: ">", direction: "asc"},
+		{name: "dearest", filter: SearchFilter{Sort: SortPriceDesc}, value: 1200.0, sortExpr: "(" + monthPrice + ")", comparison: "<", direction: "desc"},
+		{name: "largest", filter: SearchFilter{Sort: SortAreaDesc}, value: 85.5, sortExpr: "coalesce(area_sqft, 0)", comparison: "<", direction: "desc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captured := dryRunConnector(t)
+			sortName := tt.filter.Sort
+			if sortName == "" {
+				sortName = SortNewest
+			}
+			tt.filter.Cursor = mustEncodeCursor(t, sortName, tt.value, 42)
+			tt.filter.Limit = 2
+
+			if _, _, err := SearchProperties(tt.filter); err != nil {
+				t.Fatal(err)
+			}
+
+			sortExpr := collapseSQL(tt.sortExpr)
+			keyset := fmt.Sprintf("%s, id) %s ($", sortExpr, tt.comparison)
+			if !strings.Contains(captured.SQL, keyset) {
+				t.Errorf("sql %q has no keyset condition %q", captured.SQL, keyset)
+			}
+			order := fmt.Sprintf("ORDER BY %s %s, id %s LIMIT $", sortExpr, tt.direction, tt.direction)
+			if !strings.Contains(captured.SQL, order) {
+				t.Errorf("sql %q is not ordered by %q", captured.SQL, order)
+			}
+			if tt.filter.Sort == SortPriceAsc || tt.filter.Sort == SortPriceDesc {
+				// the next cursor is taken from sort_price, so it has to be the expression sorted on
+				if selected := "SELECT properties.*, " + sortExpr + " AS sort_price"; !strings.HasPrefix(captured.SQL, selected) {
+					t.Errorf("sql %q does not select the sort price", captured.SQL)
+				}
+			}
+
+			// the cursor position is bound last, just before the page size plus the row telling whether there is more
+			vars := captured.Vars
+			if len(vars) < 3 {
+				t.Fatalf("vars %v", vars)
+			}
+			tail := vars[len(vars)-3:]
+			if !reflect.DeepEqual(tail[1:], []interface{}{uint(42), 3}) {
+				t.Errorf("vars end in %v, want the cursor id and a limit of 3", tail)
+			}
+			if wantTime, ok := tt.value.(time.Time); ok {
+				if got, ok := tail[0].(time.Time); !ok || !got.Equal(wantTime) {
+					t.Errorf("cursor time bound as %v, want %v", tail[0], wantTime)
+				}
+			} else if tail[0] != tt.value {
+				t.Errorf("cursor value bound as %v, want %v", tail[0], tt.value)
+			}
+		})
+	}
+}
+
+func TestSearchPropertiesRejectsCursor(t *testing.T) {
+	dryRunConnector(t)
+	tests := []SearchFilter{
+		{Sort: SortPriceAsc, Cursor: mustEncodeCursor(t, SortPriceDesc, 1200.0, 4)},
+		{Sort: SortNewest, Cursor: mustEncodeCursor(t, SortAreaDesc, 80.0, 4)},
+		{Sort: SortAreaDesc, Cursor: mustEncodeCursor(t, SortRelevance, 0.3, 4)},
+		{Cursor: "bm90IGEgY3Vyc29y"},
+	}
+	for _, filter := range tests {
+		if _, _, err := SearchProperties(filter); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%+v returned %v, want ErrInvalidCursor", filter, err)
+		}
+	}
+}
+
+// a listing for the paging tests, monthly is its price per month after normalisation, nil for sales; an area
+// of 0 is stored as NULL the way scraped listings without one are
+type pagingListing struct {
+	price   float64
+	period  string
+	age     time.Duration
+	area    float64
+	monthly *float64
+}
+
+func monthly(value float64) *float64 { return &value }
+
+// page through every sort with small pages over listings whose sort values tie, the pages joined together
+// have to hold every matching listing exactly once in order
+func TestSearchPropertiesPagesThroughTies(t *testing.T) {
+	db := withTestDB(t)
+
+	const city = "Cursorville"
+	now := time.Now().UTC().Truncate(time.Second)
+	listings := []pagingListing{
+		{price: 1200, period: "month", age: 0, area: 80, monthly: monthly(1200)},
+		{price: 40, period: "per night", age: 0, area: 80, monthly: monthly(1200)},
+		{price: 14400, period: "p.a.", age: 0, area: 100, monthly: monthly(1200)},
+		{price: 900, period: "month", age: time.Hour, area: 100, monthly: monthly(900)},
+		{price: 300, period: "weekly", age: time.Hour, area: 80, monthly: monthly(1300)},
+		{price: 1200, period: "pcm", age: 2 * time.Hour, area: 60, monthly: monthly(1200)},
+		{price: 150000, period: "for sale", age: 0, area: 80},
+		{price: 1200, period: "month", age: time.Hour, area: 100, monthly: monthly(1200)},
+		{price: 700, period: "month", age: 3 * time.Hour, area: 0, monthly: monthly(700)},
+		{price: 800, period: "month", age: 3 * time.Hour, area: 0, monthly: monthly(800)},
+	}
+
+	ids := make([]uint, len(listings))
+	for i, listing := range listings {
+		property := models.Property{
+			Title:         fmt.Sprintf("listing %d", i),
+			PropertyType:  "house",
+			City:          city,
+			Price:         listing.price,
+			Currency:      "USD",
+			PricePeriod:   listing.period,
+			AreaSqft:      listing.area,
+			SourceWebsite: "test",
+			Published:     true,
+			CreatedAt:     now.Add(-listing.age),
+		}
+		if err := db.Create(&property).Error; err != nil {
+			t.Fatal(err)
+		}
+		if listing.area == 0 {
+			db.Model(&property).UpdateColumn("area_sqft", gorm.Expr("NULL"))
+		}
+		ids[i] = property.ID
+	}
+
+	// expected order worked out from the fixtures, ties broken by id in the sort's direction
+	expected := func(sortName string) []uint {
+		var order []int
+		for i, listing := range listings {
+			if (sortName == SortPriceAsc || sortName == SortPriceDesc) && listing.monthly == nil {
+				continue
+			}
+			order = append(order, i)
+		}
+		key := func(i int) float64 {
+			switch sortName {
+			case SortNewest:
+				return -listings[i].age.Seconds()
+			case SortAreaDesc:
+				return listings[i].area
+			}
+			return *listings[i].monthly
+		}
+		descending := sortName != SortPriceAsc
+		sort.Slice(order, func(a, b int) bool {
+			ka, kb := key(order[a]), key(order[b])
+			if ka != kb {
+				return (ka > kb) == descending
+			}
+			return (ids[order[a]] > ids[order[b]]) == descending
+		})
+		result := make([]uint, len(order))
+		for i, index := range order {
+			result[i] = ids[index]
+		}
+		return result
+	}
+
+	for _, sortName := range []string{SortNewest, SortPriceAsc, SortPriceDesc, SortAreaDesc} {
+		for _, period := range []string{PricePeriodMonth, PricePeriodNight} {
+			for _, limit := range []int{1, 2, 3} {
+				t.Run(fmt.Sprintf("%s %s pages of %d", sortName, period, limit), func(t *testing.T) {
+					var got []uint
+					cursor := ""
+					for page := 0; ; page++ {
+						if page > len(listings) {
+							t.Fatalf("still paging after %d pages, ids so far %v", page, got)
+						}
+						properties, next, err := SearchProperties(SearchFilter{City: city, Sort: sortName, PricePeriod: period, Cursor: cursor, Limit: limit})
+						if err != nil {
+							t.Fatal(err)
+						}
+						if next != "" && len(properties) != limit {
+							t.Fatalf("page %d has %d listings and a next cursor", page, len(properties))
+						}
+						for _, property := range properties {
+							got = append(got, property.ID)
+						}
+						if next == "" {
+							break
+						}
+						cursor = next
+					}
+
+					if want := expected(sortName); !reflect.DeepEqual(got, want) {
+						t.Errorf("pages gave %v, want %v", got, want)
+					}
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package property_utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/connector"
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+)
+
+const (
+	SortRelevance = "relevance"
+
+	MaxCitySuggestions = 10
+	cityCacheTTL       = 5 * time.Minute
+)
+
+// title matches count most, then the city, then the description; the city is not stemmed since it is a name
+var searchIndexSQL = []string{
+	`ALTER TABLE properties ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+		setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+		setweight(to_tsvector('simple', coalesce(city, '')), 'B') ||
+		setweight(to_tsvector('english', coalesce(description, '')), 'C')
+	) STORED`,
+	`CREATE INDEX IF NOT EXISTS idx_properties_search_vector ON properties USING GIN (search_vector)`,
+}
+
+// html in listings is escaped before highlighting so the snippets can be shown as html with only the <mark> tags live
+const escapeHTMLSQL = `replace(replace(replace(coalesce(%s, ''), '&', '&amp;'), '<', '&lt;'), '>', '&gt;')`
+
+const (
+	searchQuerySQL = `websearch_to_tsquery('english', ?)`
+	searchRankSQL  = `ts_rank_cd(search_vector, ` + searchQuerySQL + `)::float8`
+)
+
+var (
+	cityCache     []string
+	cityCacheAt   time.Time
+	cityCacheLock sync.Mutex
+)
+
+// a listing matching a text search, TitleHighlight and Snippet wrap the matched words in <mark>
+type TextSearchResult struct {
+	models.Property
+	Rank           float64 `json:"rank"`
+	TitleHighlight string  `json:"title_highlight"`
+	Snippet        string  `json:"snippet"`
+}
+
+// add the generated search column and its index, AutoMigrate cannot describe either; safe to run on every start
+func EnsureSearchIndex() error {
+	for _, statement := range searchIndexSQL {
+		if err := connector.DB.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func headlineSQL(column string, options string) string {
+	return `ts_headline('english', ` + fmt.Sprintf(escapeHTMLSQL, column) + `, ` + searchQuerySQL + `, '` + options + `')`
+}
+
+// published listings matching free text such as "sunny loft near the river", best match first
+func TextSearchProperties(text string, city string, cursor string, limit int) ([]TextSearchResult, string, error) {
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	if limit > MaxSearchLimit {
+		limit = MaxSearchLimit
+	}
+
+	query := connector.DB.Model(&models.Property{}).
+		Select("properties.*, "+searchRankSQL+" AS rank, "+
+			headlineSQL("title", "HighlightAll=true, StartSel=<mark>, StopSel=</mark>")+" AS title_highlight, "+
+			headlineSQL("description", `StartSel=<mark>, StopSel=</mark>, MaxWords=35, MinWords=15, MaxFragments=2, FragmentDelimiter=" ... "`)+" AS snippet",
+			text, text, text).
+		Where("published = ? AND search_vector @@ "+searchQuerySQL, true, text)
+
+	if city != "" {
+		query = query.Where("lower(city) = lower(?)", strings.TrimSpace(city))
+	}
+
+	if cursor != "" {
+		var lastRank float64
+		lastID, err := decodeCursor(cursor, SortRelevance, &lastRank)
+		if err != nil {
+			return nil, "", err
+		}
+		query = query.Where("("+searchRankSQL+" < ? OR ("+searchRankSQL+" = ? AND id > ?))", text, lastRank, text, lastRank, lastID)
+	}
+
+	var results []TextSearchResult
+	if err := query.Order("rank desc, id").Limit(limit + 1).Find(&results).Error; err != nil {
+		return nil, "", err
+	}
+
+	nextCursor := ""
+	if len(results) > limit {
+		results = results[:limit]
+		last := results[len(results)-1]
+		encoded, err := encodeCursor(SortRelevance, last.Rank, last.ID)
+		if err != nil {
+			return nil, "", err
+		}
+		nextCursor = encoded
+	}
+	return results, nextCursor, nil
+}
+
+// distinct cities of published listings, cached briefly since suggestions are requested on every keystroke
+func listingCities() ([]string, error) {
+	cityCacheLock.Lock()
+	defer cityCacheLock.Unlock()
+
+	if cityCache != nil && time.Since(cityCacheAt) < cityCacheTTL {
+		return cityCache, nil
+	}
+
+	var cities []string
+	result := connector.DB.Model(&models.Property{}).
+		Where("published = ? AND city <> ''", true).
+		Distinct("city").Order("city").Pluck("city", &cities)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	cityCache, cityCacheAt = cities, time.Now()
+	return cities, nil
+}
+
+// edit distance between two strings, counted in runes
+func levenshtein(a string, b string) int {
+	source, target := []rune(a), []rune(b)
+	previous := make([]int, len(target)+1)
+	current := make([]int, len(target)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(source); i++ {
+		current[0] = i
+		for j := 1; j <= len(target); j++ {
+			cost := 1
+			if source[i-1] == target[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(target)]
+}
+
+// cities close to what was typed, prefixes first and then by fewest typos; one typo is allowed per three
+// letters so "harrare" or "bulwayo" still find their city while two letters only match as a prefix
+func SuggestCities(typed string, limit int) ([]string, error) {
+	if limit <= 0 || limit > MaxCitySuggestions {
+		limit = MaxCitySuggestions
+	}
+	typed = strings.ToLower(strings.Join(strings.Fields(typed), " "))
+	if typed == "" {
+		return []string{}, nil
+	}
+
+	cities, err := listingCities()
+	if err != nil {
+		return nil, err
+	}
+
+	type suggestion struct {
+		city     string
+		prefix   bool
+		distance int
+	}
+	allowed := len([]rune(typed)) / 3
+	suggestions := []suggestion{}
+	seen := map[string]bool{}
+	for _, city := range cities {
+		name := strings.ToLower(strings.TrimSpace(city))
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		if strings.HasPrefix(name, typed) {
+			suggestions = append(suggestions, suggestion{city: city, prefix: true})
+			continue
+		}
+		// compare against the start of the name as well so a typo in a partly typed city still matches
+		distance := levenshtein(typed, name)
+		if runes := []rune(name); len(runes) > len([]rune(typed)) {
+			distance = min(distance, levenshtein(typed, string(runes[:len([]rune(typed))])))
+		}
+		if distance <= allowed {
+			suggestions = append(suggestions, suggestion{city: city, distance: distance})
+		}
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		if suggestions[i].prefix != suggestions[j].prefix {
+			return suggestions[i].prefix
+		}
+		return suggestions[i].distance < suggestions[j].distance
+	})
+
+	matched := make([]string, 0, limit)
+	for _, s := range suggestions {
+		if len(matched) == limit {
+			break
+		}
+		matched = append(matched, strings.TrimSpace(s.city))
+	}
+	return matched, nil
+}
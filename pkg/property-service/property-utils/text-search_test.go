@@ -0,0 +1,131 @@
+package property_utils
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Brian-Mashavakure/smart-prop-server/pkg/database/models"
+)
+
+// listings after the cursor rank lower, or rank the same and come later by id, matching "rank desc, id"
+func TestTextSearchCursorSQL(t *testing.T) {
+	captured := dryRunConnector(t)
+	cursor := mustEncodeCursor(t, SortRelevance, 0.1, 42)
+
+	if _, _, err := TextSearchProperties("sunny loft", "Harare", cursor, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	rank := "ts_rank_cd(search_vector, websearch_to_tsquery('english', $%d))::float8"
+	for _, fragment := range []string{
+		fmt.Sprintf("("+rank+" < $%d OR ("+rank+" = $%d AND id > $%d))", 7, 8, 9, 10, 11),
+		"ORDER BY rank desc, id LIMIT $12",
+		"SELECT properties.*, " + fmt.Sprintf(rank, 1) + " AS rank,",
+	} {
+		if !strings.Contains(captured.SQL, fragment) {
+			t.Errorf("sql %q does not contain %q", captured.SQL, fragment)
+		}
+	}
+
+	want := []interface{}{"sunny loft", "sunny loft", "sunny loft", true, "sunny loft", "Harare", "sunny loft", 0.1, "sunny loft", 0.1, uint(42), 3}
+	if !reflect.DeepEqual(captured.Vars, want) {
+		t.Errorf("vars %v, want %v", captured.Vars, want)
+	}
+}
+
+func TestTextSearchRejectsCursor(t *testing.T) {
+	dryRunConnector(t)
+	for _, cursor := range []string{
+		mustEncodeCursor(t, SortPriceDesc, 0.1, 4),
+		mustEncodeCursor(t, SortRelevance, "high", 4),
+		"bm90IGEgY3Vyc29y",
+	} {
+		if _, _, err := TextSearchProperties("loft", "", cursor, 5); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("cursor %q returned %v, want ErrInvalidCursor", cursor, err)
+		}
+	}
+}
+
+// identical listings rank the same, pages must still hold every match exactly once in rank then id order
+func TestTextSearchPagesThroughTiedRanks(t *testing.T) {
+	db := withTestDB(t)
+	if err := EnsureSearchIndex(); err != nil {
+		t.Fatalf("EnsureSearchIndex: %v", err)
+	}
+
+	const city = "Rankville"
+	listings := []struct{ title, description string }{
+		{"Sunny loft", "Bright open plan room"},
+		{"Sunny loft", "Bright open plan room"},
+		{"Garden cottage", "A sunny garden and a quiet street"},
+		{"Sunny loft", "Bright open plan room"},
+		{"Sunny loft with a sunny balcony", "Sunny all afternoon"},
+		{"Dark basement", "No windows at all"},
+		{"Sunny loft", "Bright open plan room"},
+		{"Garden cottage", "A sunny garden and a quiet street"},
+	}
+	for _, listing := range listings {
+		property := models.Property{Title: listing.title, Description: listing.description, PropertyType: "flat", City: city, SourceWebsite: "test", Published: true}
+		if err := db.Create(&property).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all, next, err := TextSearchProperties("sunny", city, "", MaxSearchLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != "" || len(all) != 7 {
+		t.Fatalf("one page found %d listings with next cursor %q, want all 7", len(all), next)
+	}
+	ties := 0
+	for i := 1; i < len(all); i++ {
+		previous, current := all[i-1], all[i]
+		if previous.Rank < current.Rank || previous.Rank == current.Rank && previous.ID > current.ID {
+			t.Fatalf("results are not in rank then id order at %d: %+v before %+v", i, previous, current)
+		}
+		if previous.Rank == current.Rank {
+			ties++
+		}
+	}
+	if ties < 4 {
+		t.Fatalf("expected the identical listings to tie, got %d ties", ties)
+	}
+	var want []uint
+	for _, result := range all {
+		want = append(want, result.ID)
+	}
+
+	for _, limit := range []int{1, 2, 3} {
+		t.Run(fmt.Sprintf("pages of %d", limit), func(t *testing.T) {
+			var got []uint
+			cursor := ""
+			for page := 0; ; page++ {
+				if page > len(listings) {
+					t.Fatalf("still paging after %d pages, ids so far %v", page, got)
+				}
+				results, next, err := TextSearchProperties("sunny", city, cursor, limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if next != "" && len(results) != limit {
+					t.Fatalf("page %d has %d results and a next cursor", page, len(results))
+				}
+				for _, result := range results {
+					got = append(got, result.ID)
+				}
+				if next == "" {
+					break
+				}
+				cursor = next
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("pages gave %v, want %v", got, want)
+			}
+		})
+	}
+}
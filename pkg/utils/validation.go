@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/go-playground/validator/v10/non-standard/validators"
+)
+
+var (
+	validatorOnce sync.Once
+	// messages for validation tags registered through RegisterValidation, %s is the field name
+	customMessages = map[string]string{}
+)
+
+// gin's validator, reporting fields by their json name and with the extra tags the api uses
+func validatorEngine() *validator.Validate {
+	engine := binding.Validator.Engine().(*validator.Validate)
+	validatorOnce.Do(func() {
+		engine.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+			if name == "" || name == "-" {
+				return field.Name
+			}
+			return name
+		})
+		engine.RegisterValidation("notblank", validators.NotBlank)
+	})
+	return engine
+}
+
+// add a validation tag usable in binding struct tags, message is shown as the field error with %s as the field name
+func RegisterValidation(tag string, fn validator.Func, message string) error {
+	if err := validatorEngine().RegisterValidation(tag, fn); err != nil {
+		return err
+	}
+	customMessages[tag] = message
+	return nil
+}
+
+// struct field named in a tag like nefield=CurrentPassword, as the client knows it (current_password)
+func paramField(param string) string {
+	var name strings.Builder
+	for i, r := range param {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				name.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		name.WriteRune(r)
+	}
+	return name.String()
+}
+
+func fieldMessage(fe validator.FieldError) string {
+	field := fe.Field()
+	if message, ok := customMessages[fe.Tag()]; ok {
+		return fmt.Sprintf(message, field)
+	}
+
+	switch fe.Tag() {
+	case "required", "notblank", "required_without":
+		return field + " is required"
+	case "email":
+		return field + " must be a valid email address"
+	case "url":
+		return field + " must be a valid url"
+	case "numeric":
+		return field + " must contain only digits"
+	case "len":
+		return fmt.Sprintf("%s must be exactly %s characters", field, fe.Param())
+	case "oneof":
+		return fmt.Sprintf("%s must be one of %s", field, strings.ReplaceAll(fe.Param(), " ", ", "))
+	case "nefield":
+		return field + " must be different from " + paramField(fe.Param())
+	case "required_with":
+		return fmt.Sprintf("%s is required when %s is given", field, paramField(fe.Param()))
+	case "latitude":
+		return field + " must be a latitude between -90 and 90"
+	case "longitude":
+		return field + " must be a longitude between -180 and 180"
+	case "gtefield":
+		return fmt.Sprintf("%s must not be less than %s", field, paramField(fe.Param()))
+	case "iso4217":
+		return field + " must be a three letter ISO 4217 currency code such as USD"
+	case "gt":
+		return fmt.Sprintf("%s must be greater than %s", field, fe.Param())
+	case "min", "gte":
+		if fe.Kind() == reflect.String {
+			return fmt.Sprintf("%s must be at least %s characters", field, fe.Param())
+		}
+		return fmt.Sprintf("%s must be at least %s", field, fe.Param())
+	case "max", "lte", "lt":
+		if fe.Kind() == reflect.String {
+			return fmt.Sprintf("%s must be at most %s characters", field, fe.Param())
+		}
+		return fmt.Sprintf("%s must be at most %s", field, fe.Param())
+	default:
+		return field + " is invalid"
+	}
+}
+
+// turn a binding error into field level messages for the Errors part of an ApiResponse
+func FieldErrors(err error) map[string]interface{} {
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return map[string]interface{}{"error": "request body could not be parsed"}
+	}
+
+	fields := map[string]interface{}{}
+	for _, fe := range validationErrors {
+		if _, seen := fields[fe.Field()]; !seen {
+			fields[fe.Field()] = fieldMessage(fe)
+		}
+	}
+	return fields
+}
+
+// bind a json, form or query request into req and validate it, on failure the 400 response is
+// written with field level errors and false is returned
+func BindRequest(c *gin.Context, req interface{}) bool {
+	validatorEngine()
+	if err := c.ShouldBind(req); err != nil {
+		c.JSON(http.StatusBadRequest, ReturnJsonResponse("failed", "invalid request", nil, FieldErrors(err)))
+		return false
+	}
+	return true
+}